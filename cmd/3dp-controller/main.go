@@ -1,9 +1,10 @@
 package main
 
 import (
+	_ "3dp-controller/internal/backends"
 	"3dp-controller/internal/config"
 	"3dp-controller/internal/controller"
-	"3dp-controller/internal/moonraker"
+	"3dp-controller/internal/power"
 	"3dp-controller/internal/printer"
 	"3dp-controller/internal/web"
 	"bufio"
@@ -81,14 +82,20 @@ func main() {
 
 	for _, p := range cfg.Printers {
 		monConfig := printer.MonitorConfig{
-			NoPauseDuration:      cfg.NoPauseDuration,
-			ShouldPauseProgress:  cfg.ShouldPauseProgress,
-			ShouldCancelProgress: cfg.ShouldCancelProgress,
-			WillPauseMessage:     cfg.DisplayMessages.WillPauseMessage,
-			PauseMessage:         cfg.DisplayMessages.PauseMessage,
+			NoPauseDuration:       cfg.NoPauseDuration,
+			ShouldPauseProgress:   cfg.ShouldPauseProgress,
+			ShouldCancelProgress:  cfg.ShouldCancelProgress,
+			WillPauseMessage:      cfg.DisplayMessages.WillPauseMessage,
+			PauseMessage:          cfg.DisplayMessages.PauseMessage,
+			ScheduledPauseMessage: cfg.DisplayMessages.ScheduledPauseMessage,
 		}
 
-		m, err := moonraker.NewMonitor(p.Name, p.Url, monConfig, sugar.With("PrinterName", p.Name))
+		m, err := printer.New(p.Type, printer.BackendConfig{
+			Name:    p.Name,
+			Url:     p.Url,
+			Options: p.Options,
+			Monitor: monConfig,
+		}, sugar.With("PrinterName", p.Name))
 		if err != nil {
 			panic(err)
 		}
@@ -107,7 +114,13 @@ func main() {
 		ctrlConnector.Connect(ctx)
 	}
 
-	server := web.NewServer(ctx, isDevMode, sugar.Named("web"), monitors)
+	var powerScheduler *power.Scheduler
+	if cfg.AutoPowerOff != nil {
+		powerScheduler = power.NewScheduler(*cfg.AutoPowerOff, monitors, sugar.Named("power"))
+		powerScheduler.Start(ctx)
+	}
+
+	server := web.NewServer(ctx, isDevMode, sugar.Named("web"), monitors, cfg.Console, cfg.Tuning)
 	go server.Run()
 
 	for {
@@ -136,6 +149,10 @@ func main() {
 				go ctrlConnector.Close()
 			}
 
+			if powerScheduler != nil {
+				powerScheduler.Stop()
+			}
+
 			for _, m := range monitors {
 				m.Stop()
 			}
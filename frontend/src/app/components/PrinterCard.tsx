@@ -47,6 +47,13 @@ function PrinterCard({printer, apiURLBase}: PrinterCardProps) {
     }, [printer]);
 
 
+    // Nozzle and bed only; chamber and board sensors would crowd the card.
+    const heaterTemps = printer.temperatures
+        .filter(t => t.kind === "nozzle" || t.kind === "bed")
+        .map(t => `${t.name} ${t.current.toFixed(0)}` +
+            (t.target ? `/${t.target.toFixed(0)}` : "") + "°C")
+        .join(", ");
+
     const printerNotOpen = printer.printerNotOpen;
 
     const bgColor = (jobInfo?.isActive && jobInfo.jobWillPause) ?
@@ -114,10 +121,30 @@ function PrinterCard({printer, apiURLBase}: PrinterCardProps) {
                         {jobInfo.isActive ? <Card.Text>
                             <abbr title="Print / Total">Time</abbr>: {jobInfo.printTime} / {jobInfo.totalTime}
                         </Card.Text> : null}
+
+                        {jobInfo.isActive && (jobInfo.layer || typeof jobInfo.zHeight === "number") ? <Card.Text>
+                            {jobInfo.layer ? <>Layer: {jobInfo.layer}</> : null}
+                            {jobInfo.layer && typeof jobInfo.zHeight === "number" ? ", " : null}
+                            {typeof jobInfo.zHeight === "number" ? <>Z: {jobInfo.zHeight.toFixed(2)} mm</> : null}
+                        </Card.Text> : null}
                     </> : null}
 
                     <Card.Text>Job Owner: <Badge bg="dark">N/A</Badge></Card.Text>
 
+                    {heaterTemps ? <Card.Text>Temperature: {heaterTemps}</Card.Text> : null}
+
+                    {printer.state === PrinterState.Pause && printer.jobPausedByMonitor ?
+                        <Card.Subtitle className="mb-2">Paused until the job is registered.</Card.Subtitle> : null}
+
+                    {printer.state === PrinterState.Pause && printer.jobPausedBySchedule ?
+                        <Card.Subtitle className="mb-2">Paused as scheduled, resume when ready.</Card.Subtitle> : null}
+
+                    {printer.scheduledPause ? <Card.Text>
+                        Pause scheduled at {printer.scheduledPause.layer ?
+                            <>layer {printer.scheduledPause.layer}</> :
+                            <>Z {printer.scheduledPause.zHeight?.toFixed(2)} mm</>}
+                    </Card.Text> : null}
+
                     {jobInfo?.isActive && jobInfo.jobWillPause ? <>
                         <Card.Subtitle>
                             Job will be paused
@@ -3,6 +3,7 @@ import {
     PrinterErrorInfo,
     PrinterJob,
     PrinterPrinterState,
+    PrinterTemperature,
 } from "./api";
 import {getJobStatsColor, secondsToDurationString} from "./utils";
 
@@ -19,6 +20,9 @@ export interface Job {
     printDuration?: number;
     totalDuration?: number;
     estimatedRemainingSec?: number;
+    currentLayer?: number;
+    totalLayer?: number;
+    zHeight?: number;
 }
 
 export function convertJob(job: PrinterJob): Job {
@@ -32,6 +36,9 @@ export function convertJob(job: PrinterJob): Job {
         printDuration: job.print_duration,
         totalDuration: job.total_duration,
         estimatedRemainingSec: job.remaining_sec,
+        currentLayer: job.current_layer,
+        totalLayer: job.total_layer,
+        zHeight: job.z_height,
     }
 }
 
@@ -47,6 +54,22 @@ export function convertErrorDetail(errorDetail: PrinterErrorInfo): ErrorDetail {
     }
 }
 
+export interface Temperature {
+    name: string;
+    kind: string;
+    current: number;
+    target?: number;
+}
+
+export function convertTemperature(temperature: PrinterTemperature): Temperature {
+    return {
+        name: temperature.name!,
+        kind: String(temperature.kind),
+        current: temperature.current!,
+        target: temperature.target,
+    }
+}
+
 export interface Printer {
     key: string;
     name: string;
@@ -56,6 +79,9 @@ export interface Printer {
     registeredJobId: string;
     allowNoRegisteredPrint: boolean;
     noPauseDuration: number;
+    jobPausedByMonitor: boolean;
+    scheduledPause?: { layer?: number; zHeight?: number };
+    jobPausedBySchedule: boolean;
 
     state: PrinterState;
     printerNotOpen: boolean;
@@ -64,6 +90,7 @@ export interface Printer {
     lastUpdateTime: Date;
 
     job?: Job;
+    temperatures: Temperature[];
 }
 
 export function convertPrinter(printer: WebPrinter): Printer {
@@ -81,6 +108,12 @@ export function convertPrinter(printer: WebPrinter): Printer {
         registeredJobId: printer.registered_job_id ?? "",
         allowNoRegisteredPrint: printer.allow_no_register_print!,
         noPauseDuration: printer.no_pause_duration!,
+        jobPausedByMonitor: printer.job_paused_by_monitor ?? false,
+        scheduledPause: printer.scheduled_pause ? {
+            layer: printer.scheduled_pause.layer,
+            zHeight: printer.scheduled_pause.z_height,
+        } : undefined,
+        jobPausedBySchedule: printer.job_paused_by_schedule ?? false,
 
         state: printer.state!,
         printerNotOpen: false,
@@ -89,6 +122,7 @@ export function convertPrinter(printer: WebPrinter): Printer {
         lastUpdateTime: new Date(printer.last_update_time!),
 
         job: printer.job ? convertJob(printer.job) : undefined,
+        temperatures: printer.temperatures?.map(convertTemperature) ?? [],
     }
 }
 
@@ -113,6 +147,9 @@ export type ActiveJobInfo = Omit<JobInfo, 'isActive'> & {
     estRemainSec?: number;
     printTime?: string;
     totalTime?: string;
+
+    layer?: string;
+    zHeight?: number;
 }
 
 export function getLatestJobInfo(printer: Printer): ActiveJobInfo | JobInfo | undefined {
@@ -140,6 +177,12 @@ export function getLatestJobInfo(printer: Printer): ActiveJobInfo | JobInfo | un
             estRemainSec = 0;
         }
 
+        let layer: string | undefined;
+        if (typeof job.currentLayer === "number") {
+            layer = typeof job.totalLayer === "number" ?
+                `${job.currentLayer} / ${job.totalLayer}` : `${job.currentLayer}`;
+        }
+
         const willPause = !printer.allowNoRegisteredPrint && job.jobId !== printer.registeredJobId;
         const pauseRemainSec = typeof job.printDuration === "number" ?
             Math.max(printer.noPauseDuration - job.printDuration, 0) : undefined;
@@ -150,6 +193,8 @@ export function getLatestJobInfo(printer: Printer): ActiveJobInfo | JobInfo | un
             estRemainSec,
             printTime,
             totalTime,
+            layer,
+            zHeight: job.zHeight,
             jobWillPause: willPause,
             pauseRemainSec,
         }
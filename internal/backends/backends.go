@@ -0,0 +1,17 @@
+// Package backends links every printer backend into the binary. Each
+// backend registers itself with printer.Register when its package is
+// imported; add new backends here.
+package backends
+
+import (
+	_ "3dp-controller/internal/bambu"
+	_ "3dp-controller/internal/creality"
+	_ "3dp-controller/internal/duet"
+	_ "3dp-controller/internal/elegoo"
+	_ "3dp-controller/internal/grbl"
+	_ "3dp-controller/internal/klippy"
+	_ "3dp-controller/internal/marlin"
+	_ "3dp-controller/internal/moonraker"
+	_ "3dp-controller/internal/octoprint"
+	_ "3dp-controller/internal/prusalink"
+)
@@ -0,0 +1,545 @@
+package bambu
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+// Options are the connection settings specific to a Bambu printer.
+type Options struct {
+	// Serial is the printer's serial number; it names the MQTT topics.
+	Serial string `yaml:"serial"`
+	// AccessCode is the LAN access code shown on the printer's screen.
+	AccessCode string `yaml:"access_code"`
+}
+
+func (o *Options) Validate() error {
+	if o.Serial == "" {
+		return errors.New("serial is required")
+	}
+
+	if o.AccessCode == "" {
+		return errors.New("access code is required")
+	}
+
+	return nil
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	options     Options
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	client   mqtt.Client
+	sequence atomic.Uint64
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastUpdateTime time.Time
+	report         map[string]any
+	printReport    *PrintReport
+	// printingSince is when the current job was first seen printing; used for
+	// the print duration when the firmware doesn't send gcode_start_time.
+	printingSince time.Time
+	printingJobId string
+
+	reportCh chan struct{}
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "bambu"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+// Message returns the printer's active HMS codes; Bambu printers have no
+// free-text status message.
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.printReport == nil {
+		return ""
+	}
+
+	return formatHMS(m.printReport.Hms)
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error || m.printReport == nil {
+		return nil
+	}
+
+	r := m.printReport
+	if r.PrintError == 0 && len(r.Hms) == 0 {
+		return nil
+	}
+
+	info := &printer.ErrorInfo{Message: formatHMS(r.Hms)}
+	if r.PrintError != 0 {
+		code := r.PrintError
+		info.Code = &code
+	}
+
+	return info
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r := m.printReport
+	if r == nil {
+		return nil
+	}
+
+	status := jobStatus(r)
+	if status == "" {
+		return nil
+	}
+
+	j := &printer.Job{
+		JobId:  jobId(r),
+		Name:   r.SubtaskName,
+		Status: status,
+	}
+
+	if j.Name == "" {
+		j.Name = r.GCodeFile
+	}
+
+	if sec, ok := r.StartTime(); ok {
+		t := time.Unix(sec, 0)
+		j.StartTime = &t
+	}
+
+	if status == "in_progress" {
+		if r.McPercent != nil {
+			progress := float32(*r.McPercent) / 100
+			j.Progress = &progress
+		}
+
+		if d, ok := m.printDuration(); ok {
+			printDuration := printer.Seconds(d.Seconds())
+			j.PrintDuration = &printDuration
+			j.TotalDuration = &printDuration
+		}
+
+		if r.McRemainingTime != nil {
+			remaining := printer.Seconds(*r.McRemainingTime * 60)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the merged "print" report as last received.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := json.Marshal(m.report)
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(b)
+}
+
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("printer url '%s' has no host", printerURL)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.options = options
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.report = make(map[string]any)
+	m.reportCh = make(chan struct{}, 1)
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	port := m.printerUrl.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker("tls://" + net.JoinHostPort(m.printerUrl.Hostname(), port)).
+		SetClientID(fmt.Sprintf("3dp-controller-%s-%d", m.options.Serial, time.Now().UnixNano())).
+		SetUsername(Username).
+		SetPassword(m.options.AccessCode).
+		// The printer's certificate is self-signed by Bambu's own CA.
+		SetTLSConfig(&tls.Config{InsecureSkipVerify: true}).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(client mqtt.Client) { m.onConnect(ctx, client) }).
+		SetConnectionLostHandler(m.onConnectionLost)
+
+	m.client = mqtt.NewClient(opts)
+	m.client.Connect()
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-m.reportCh:
+				m.update(ctx)
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.client.Disconnect(250)
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+func (m *Monitor) onConnect(ctx context.Context, client mqtt.Client) {
+	m.logger.Infoln("Connected to printer MQTT broker")
+
+	m.mu.Lock()
+	m.state = printer.Unknown
+	m.report = make(map[string]any)
+	m.printReport = nil
+	m.mu.Unlock()
+
+	token := client.Subscribe(reportTopic(m.options.Serial), 0, m.onReport)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			m.logger.Errorf("Failed to subscribe: %s\n", token.Error())
+			return
+		}
+
+		// Ask for the full state; later reports may only carry changes.
+		if err := m.publish(ctx, commandRequest{
+			Pushing: &printCommand{SequenceId: m.nextSequenceId(), Command: "pushall"},
+		}); err != nil {
+			m.logger.Errorf("Failed to request full report: %s\n", err)
+		}
+	}()
+}
+
+func (m *Monitor) onConnectionLost(_ mqtt.Client, err error) {
+	m.logger.Warnf("Lost connection to printer: %s\n", err)
+
+	m.mu.Lock()
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.mu.Unlock()
+}
+
+func (m *Monitor) onReport(_ mqtt.Client, msg mqtt.Message) {
+	var report Report
+	if err := json.Unmarshal(msg.Payload(), &report); err != nil {
+		m.logger.Warnf("Failed to decode report: %s\n", err)
+		return
+	}
+
+	if report.Print == nil {
+		return
+	}
+
+	m.mu.Lock()
+	mergeReport(m.report, report.Print)
+
+	printReport, err := decodePrintReport(m.report)
+	if err != nil {
+		m.mu.Unlock()
+		m.logger.Warnf("Failed to decode print report: %s\n", err)
+		return
+	}
+
+	m.printReport = printReport
+	m.lastUpdateTime = time.Now()
+	m.state = mapState(printReport)
+
+	if m.state == printer.Printing {
+		if id := jobId(printReport); m.printingSince.IsZero() || m.printingJobId != id {
+			m.printingSince = time.Now()
+			m.printingJobId = id
+		}
+	} else if m.state != printer.Pause {
+		m.printingSince = time.Time{}
+		m.printingJobId = ""
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.reportCh <- struct{}{}:
+	default:
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	m.mu.RLock()
+	r := m.printReport
+	state := m.state
+	printDuration, _ := m.printDuration()
+	m.mu.RUnlock()
+
+	if r == nil {
+		return
+	}
+
+	activeJobId := ""
+	if jobStatus(r) == "in_progress" {
+		activeJobId = jobId(r)
+	}
+	m.enforcer.ClearStaleRegistration(activeJobId)
+
+	var progress float32
+	if r.McPercent != nil {
+		progress = float32(*r.McPercent) / 100
+	}
+
+	m.enforcer.Enforce(ctx, printer.Observation{
+		State:         state,
+		PrintDuration: printDuration,
+		Progress:      progress,
+	})
+}
+
+// printDuration must be called with m.mu held.
+func (m *Monitor) printDuration() (time.Duration, bool) {
+	if m.printReport == nil {
+		return 0, false
+	}
+
+	if sec, ok := m.printReport.StartTime(); ok {
+		return time.Since(time.Unix(sec, 0)), true
+	}
+
+	if !m.printingSince.IsZero() {
+		return time.Since(m.printingSince), true
+	}
+
+	return 0, false
+}
+
+func (m *Monitor) nextSequenceId() string {
+	return strconv.FormatUint(m.sequence.Add(1), 10)
+}
+
+func (m *Monitor) publish(ctx context.Context, req commandRequest) error {
+	if ctx == nil {
+		return errors.New("monitor not started")
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	token := m.client.Publish(requestTopic(m.options.Serial), 0, false, b)
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return errors.New("timed out publishing command")
+	}
+}
+
+func (m *Monitor) sendPrintCommand(ctx context.Context, command string) error {
+	return m.publish(ctx, commandRequest{
+		Print: &printCommand{SequenceId: m.nextSequenceId(), Command: command},
+	})
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	return m.sendPrintCommand(ctx, "pause")
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	return m.sendPrintCommand(ctx, "resume")
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	return m.sendPrintCommand(ctx, "stop")
+}
+
+// SetStatusMessage is a no-op: Bambu printers have no user message display.
+func (m *Monitor) SetStatusMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+func mapState(r *PrintReport) printer.PrinterState {
+	switch r.GCodeState {
+	case "IDLE", "FINISH":
+		return printer.Ready
+	case "FAILED":
+		if r.PrintError == printErrorCancelled {
+			return printer.Ready
+		}
+		return printer.Error
+	case "PREPARE", "SLICING", "INIT":
+		return printer.PrePrint
+	case "RUNNING":
+		return printer.Printing
+	case "PAUSE":
+		return printer.Pause
+	case "OFFLINE":
+		return printer.Disconnected
+	default:
+		return printer.Unknown
+	}
+}
+
+// jobStatus maps the printer state onto printer.Job.Status; "" means there is
+// no job to report.
+func jobStatus(r *PrintReport) string {
+	if r.SubtaskName == "" && r.GCodeFile == "" {
+		return ""
+	}
+
+	switch r.GCodeState {
+	case "PREPARE", "SLICING", "INIT", "RUNNING", "PAUSE":
+		return "in_progress"
+	case "FINISH":
+		return "completed"
+	case "FAILED":
+		if r.PrintError == printErrorCancelled {
+			return "cancelled"
+		}
+		return "error"
+	default:
+		return ""
+	}
+}
+
+// jobId is the cloud task ID, or the job's start time for prints started
+// from LAN/SD card, which report a task_id of "0".
+func jobId(r *PrintReport) string {
+	if r.TaskId != "" && r.TaskId != "0" {
+		return r.TaskId
+	}
+
+	return r.GCodeStartTime
+}
+
+func formatHMS(hms []HMS) string {
+	codes := make([]string, 0, len(hms))
+	for _, h := range hms {
+		codes = append(codes, h.String())
+	}
+
+	return strings.Join(codes, ", ")
+}
@@ -0,0 +1,368 @@
+package bambu
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"go.uber.org/zap"
+)
+
+const (
+	testSerial     = "01S00C000000000"
+	testAccessCode = "12345678"
+)
+
+// fakeBroker stands in for the printer's MQTT broker: it accepts one client
+// at a time over TLS, acknowledges its subscriptions and hands the commands
+// it publishes to the test.
+type fakeBroker struct {
+	t  *testing.T
+	ln net.Listener
+
+	subscribed chan string
+	// requests receives the requests published, commands the command
+	// of each print request among them.
+	requests chan commandRequest
+	commands chan string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBroker{
+		t:          t,
+		ln:         ln,
+		subscribed: make(chan string, 10),
+		requests:   make(chan commandRequest, 100),
+		commands:   make(chan string, 100),
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go b.accept()
+
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "tls://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) accept() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+
+		go b.serve(conn)
+	}
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if p.Username != Username || string(p.Password) != testAccessCode {
+				ack.ReturnCode = packets.ErrRefusedNotAuthorised
+			}
+			b.write(ack)
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = p.Qoss
+			b.write(ack)
+
+			for _, topic := range p.Topics {
+				b.subscribed <- topic
+			}
+		case *packets.PublishPacket:
+			if p.TopicName != requestTopic(testSerial) {
+				b.t.Errorf("command published to %s", p.TopicName)
+				continue
+			}
+
+			var req commandRequest
+			if err := json.Unmarshal(p.Payload, &req); err != nil {
+				b.t.Errorf("bad command %s: %s", p.Payload, err)
+				continue
+			}
+			b.requests <- req
+			if req.Print != nil {
+				b.commands <- req.Print.Command
+			}
+		case *packets.PingreqPacket:
+			b.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) write(p packets.ControlPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		return
+	}
+
+	if err := p.Write(b.conn); err != nil {
+		b.t.Logf("write %s: %s", p, err)
+	}
+}
+
+// report publishes a "print" report on the printer's report topic.
+func (b *fakeBroker) report(print map[string]any) {
+	payload, err := json.Marshal(Report{Print: print})
+	if err != nil {
+		b.t.Fatal(err)
+	}
+
+	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	p.TopicName = reportTopic(testSerial)
+	p.Payload = payload
+	b.write(p)
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startMonitor connects a Monitor to a new fakeBroker and waits until it
+// has subscribed and asked for the full report.
+func startMonitor(t *testing.T) (*Monitor, *fakeBroker) {
+	t.Helper()
+
+	b := newFakeBroker(t)
+
+	m, err := NewMonitor("X1C", b.url(), Options{Serial: testSerial, AccessCode: testAccessCode}, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	select {
+	case topic := <-b.subscribed:
+		if topic != reportTopic(testSerial) {
+			t.Fatalf("subscribed to %s", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription")
+	}
+
+	select {
+	case req := <-b.requests:
+		if req.Pushing == nil || req.Pushing.Command != "pushall" {
+			t.Fatalf("first request is %+v, want pushall", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for pushall")
+	}
+
+	return m, b
+}
+
+func TestMergeReport(t *testing.T) {
+	dst := map[string]any{
+		"gcode_state": "RUNNING",
+		"mc_percent":  10.0,
+		"hms":         []any{map[string]any{"attr": 1.0, "code": 2.0}},
+		"lights":      map[string]any{"chamber": "on", "work": "off"},
+	}
+
+	mergeReport(dst, map[string]any{
+		"mc_percent": 20.0,
+		"hms":        []any{},
+		"lights":     map[string]any{"work": "on"},
+	})
+
+	if dst["gcode_state"] != "RUNNING" || dst["mc_percent"] != 20.0 {
+		t.Errorf("scalars not merged: %v", dst)
+	}
+	if hms := dst["hms"].([]any); len(hms) != 0 {
+		t.Errorf("arrays should be replaced, got %v", hms)
+	}
+	if lights := dst["lights"].(map[string]any); lights["chamber"] != "on" || lights["work"] != "on" {
+		t.Errorf("objects should be merged by key, got %v", lights)
+	}
+}
+
+func TestMapState(t *testing.T) {
+	tests := []struct {
+		gcodeState string
+		printError int
+		want       printer.PrinterState
+		wantJob    string
+	}{
+		{"IDLE", 0, printer.Ready, ""},
+		{"PREPARE", 0, printer.PrePrint, "in_progress"},
+		{"SLICING", 0, printer.PrePrint, "in_progress"},
+		{"RUNNING", 0, printer.Printing, "in_progress"},
+		{"PAUSE", 0, printer.Pause, "in_progress"},
+		{"FINISH", 0, printer.Ready, "completed"},
+		{"FAILED", 0x0500400E, printer.Error, "error"},
+		{"FAILED", printErrorCancelled, printer.Ready, "cancelled"},
+		{"OFFLINE", 0, printer.Disconnected, ""},
+		{"SOMETHING_NEW", 0, printer.Unknown, ""},
+	}
+
+	for _, tt := range tests {
+		r := &PrintReport{GCodeState: tt.gcodeState, PrintError: tt.printError, SubtaskName: "benchy"}
+
+		if got := mapState(r); got != tt.want {
+			t.Errorf("mapState(%s, %#x) = %s, want %s", tt.gcodeState, tt.printError, got, tt.want)
+		}
+		if got := jobStatus(r); got != tt.wantJob {
+			t.Errorf("jobStatus(%s, %#x) = %q, want %q", tt.gcodeState, tt.printError, got, tt.wantJob)
+		}
+	}
+}
+
+func TestHMSString(t *testing.T) {
+	h := HMS{Attr: 0x03000100, Code: 0x00010001}
+
+	if got, want := h.String(), "HMS_0300_0100_0001_0001"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestMonitorMergesIncrementalReports(t *testing.T) {
+	m, b := startMonitor(t)
+
+	start := time.Now().Add(-10 * time.Second).Unix()
+	b.report(map[string]any{
+		"gcode_state":       "RUNNING",
+		"mc_percent":        10,
+		"mc_remaining_time": 30,
+		"print_error":       0,
+		"hms":               []any{},
+		"subtask_name":      "benchy",
+		"gcode_file":        "/data/Metadata/plate_1.gcode",
+		"task_id":           "0",
+		"gcode_start_time":  strconv.FormatInt(start, 10),
+	})
+	testutil.Eventually(t, "printing", func() bool { return m.State() == printer.Printing })
+
+	// P1/A1 printers only send what changed.
+	b.report(map[string]any{"mc_percent": 55})
+	testutil.Eventually(t, "progress 55%", func() bool {
+		j := m.Job()
+		return j != nil && j.Progress != nil && *j.Progress == 0.55
+	})
+
+	j := m.Job()
+	if j.Name != "benchy" || j.Status != "in_progress" || j.JobId != strconv.FormatInt(start, 10) {
+		t.Errorf("job = %+v", j)
+	}
+	if j.StartTime == nil || j.StartTime.Unix() != start {
+		t.Errorf("start time = %v, want %d", j.StartTime, start)
+	}
+	if j.EstimatedRemaining == nil || *j.EstimatedRemaining != 30*60 {
+		t.Errorf("estimated remaining = %v", j.EstimatedRemaining)
+	}
+	if j.PrintDuration == nil || *j.PrintDuration < 10 {
+		t.Errorf("print duration = %v", j.PrintDuration)
+	}
+
+	b.report(map[string]any{
+		"gcode_state": "FAILED",
+		"print_error": 0x0500400E,
+		"hms":         []any{map[string]any{"attr": 0x03000100, "code": 0x00010001}},
+	})
+	testutil.Eventually(t, "error", func() bool { return m.State() == printer.Error })
+
+	if got, want := m.Message(), "HMS_0300_0100_0001_0001"; got != want {
+		t.Errorf("Message() = %q, want %q", got, want)
+	}
+
+	info := m.ErrorDetail()
+	if info == nil || info.Code == nil || *info.Code != 0x0500400E || info.Message != "HMS_0300_0100_0001_0001" {
+		t.Errorf("ErrorDetail() = %+v", info)
+	}
+	if j := m.Job(); j == nil || j.Status != "error" || j.Name != "benchy" {
+		t.Errorf("job after failure = %+v", j)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(m.RawReport().(json.RawMessage), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["subtask_name"] != "benchy" || raw["gcode_state"] != "FAILED" {
+		t.Errorf("raw report = %v", raw)
+	}
+}
+
+func TestJobCommands(t *testing.T) {
+	m, b := startMonitor(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		send func(context.Context) error
+		want string
+	}{
+		{m.PausePrint, "pause"},
+		{m.ResumePrint, "resume"},
+		{m.CancelPrint, "stop"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.send(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.WaitFor(t, b.commands, tt.want)
+	}
+}
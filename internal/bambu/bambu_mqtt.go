@@ -0,0 +1,122 @@
+package bambu
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Bambu printers run an MQTT broker on port 8883 (TLS with a self-signed
+// certificate). The username is always "bblp" and the password is the LAN
+// access code shown on the printer's screen. Reports are published on
+// device/<serial>/report and commands accepted on device/<serial>/request.
+
+const (
+	DefaultPort = 8883
+	Username    = "bblp"
+)
+
+func reportTopic(serial string) string {
+	return fmt.Sprintf("device/%s/report", serial)
+}
+
+func requestTopic(serial string) string {
+	return fmt.Sprintf("device/%s/request", serial)
+}
+
+// ---------------
+// Print report
+
+// HMS is one Health Management System entry. Attr and Code are each two
+// 16-bit halves of the code shown in Bambu's wiki/app.
+type HMS struct {
+	Attr int64 `json:"attr"`
+	Code int64 `json:"code"`
+}
+
+func (h HMS) String() string {
+	return fmt.Sprintf("HMS_%04X_%04X_%04X_%04X",
+		(h.Attr>>16)&0xFFFF, h.Attr&0xFFFF, (h.Code>>16)&0xFFFF, h.Code&0xFFFF)
+}
+
+// PrintReport is the subset of the merged "print" report the monitor reads.
+// P1/A1 printers only send changed fields in each push_status message, so
+// this is decoded from the merged report rather than a single message.
+type PrintReport struct {
+	GCodeState      string `json:"gcode_state"`
+	McPercent       *int   `json:"mc_percent"`
+	McRemainingTime *int   `json:"mc_remaining_time"` // minutes
+	PrintError      int    `json:"print_error"`
+	Hms             []HMS  `json:"hms"`
+
+	SubtaskName    string `json:"subtask_name"`
+	GCodeFile      string `json:"gcode_file"`
+	TaskId         string `json:"task_id"`
+	GCodeStartTime string `json:"gcode_start_time"` // unix seconds, as a string
+}
+
+// printErrorCancelled is the print_error a job ends with when it was
+// cancelled from the screen, app or a "stop" command rather than failing.
+const printErrorCancelled = 0x0300400C
+
+// StartTime parses GCodeStartTime; ok is false when the printer hasn't sent
+// one (not every firmware does).
+func (r *PrintReport) StartTime() (sec int64, ok bool) {
+	sec, err := strconv.ParseInt(r.GCodeStartTime, 10, 64)
+	if err != nil || sec <= 0 {
+		return 0, false
+	}
+
+	return sec, true
+}
+
+// Report is the envelope of every message on the report topic. Only "print"
+// messages carry printer state; others (info, system, ...) are ignored.
+type Report struct {
+	Print map[string]any `json:"print"`
+}
+
+// mergeReport merges an incremental report into dst in place. Nested objects
+// are merged key by key; every other value, arrays included, replaces the old
+// one.
+func mergeReport(dst, src map[string]any) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]any)
+		dstMap, dstIsMap := dst[k].(map[string]any)
+
+		if srcIsMap && dstIsMap {
+			mergeReport(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+func decodePrintReport(merged map[string]any) (*PrintReport, error) {
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(PrintReport)
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ---------------
+// Commands
+
+type commandRequest struct {
+	Print *printCommand `json:"print,omitempty"`
+
+	Pushing *printCommand `json:"pushing,omitempty"`
+}
+
+type printCommand struct {
+	SequenceId string `json:"sequence_id"`
+	Command    string `json:"command"`
+	Param      string `json:"param,omitempty"`
+}
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"3dp-controller/internal/power"
+	"3dp-controller/internal/printer"
+	"bytes"
 	"errors"
 	"fmt"
 	"net/url"
@@ -18,8 +21,9 @@ type ConfigServer struct {
 }
 
 type RawConfigDisplayMessages struct {
-	WillPauseMessage string `yaml:"will_pause_message"`
-	PauseMessage     string `yaml:"pause_message"`
+	WillPauseMessage      string `yaml:"will_pause_message"`
+	PauseMessage          string `yaml:"pause_message"`
+	ScheduledPauseMessage string `yaml:"scheduled_pause_message"`
 }
 
 type RawConfigController struct {
@@ -28,6 +32,30 @@ type RawConfigController struct {
 	FailMode string `yaml:"fail_mode"`
 }
 
+type RawConfigConsole struct {
+	// Only these commands may be run if given
+	Allow []string `yaml:"allow"`
+	// Commands that may not be run, default printer.DefaultGCodeDeny
+	Deny *[]string `yaml:"deny"`
+}
+
+type RawConfigTuning struct {
+	// [min, max], default printer.DefaultTuningLimits
+	SpeedFactor   []float64 `yaml:"speed_factor"`
+	ExtrudeFactor []float64 `yaml:"extrude_factor"`
+	// mm either way, default printer.DefaultTuningLimits
+	MaxZOffset *float64 `yaml:"max_z_offset"`
+}
+
+type RawConfigAutoPowerOff struct {
+	// Ready and cold this long before powering down, disabled if empty
+	IdleTime string `yaml:"idle_time"`
+	// °C, default power.DefaultMaxTemperature
+	MaxTemperature *float64 `yaml:"max_temperature"`
+	// Power devices to switch off, default all
+	Devices []string `yaml:"devices"`
+}
+
 type RawConfig struct {
 	Server               ConfigServer             `yaml:"server"`
 	NoPauseDuration      string                   `yaml:"no_pause_duration"`
@@ -35,10 +63,17 @@ type RawConfig struct {
 	ShouldCancelProgress string                   `yaml:"should_cancel_progress"`
 	DisplayMessages      RawConfigDisplayMessages `yaml:"display_messages"`
 	Controller           RawConfigController      `yaml:"controller"`
+	Console              RawConfigConsole         `yaml:"console"`
+	Tuning               RawConfigTuning          `yaml:"tuning"`
+	AutoPowerOff         RawConfigAutoPowerOff    `yaml:"auto_power_off"`
 	Printers             []struct {
 		Key  string `yaml:"key"`
 		Name string `yaml:"name"`
 		Url  string `yaml:"url"`
+		// Registered backend name, default moonraker
+		Type string `yaml:"type"`
+		// Backend-specific settings, see the backend's Options type
+		Options yaml.Node `yaml:"options"`
 		// Should be allow_print or no_print, default allow_print
 		ControllerFailMode string `yaml:"controller_fail_mode"`
 	} `yaml:"printers"`
@@ -72,16 +107,24 @@ type ConfigController struct {
 	FailMode ControllerFailMode
 }
 
+// DefaultPrinterType is the backend used for printers without a type.
+const DefaultPrinterType = "moonraker"
+
 type ConfigPrinter struct {
-	Key                string
-	Name               string
-	Url                string
+	Key  string
+	Name string
+	Url  string
+	Type string
+	// Options is the backend's decoded and validated options value, see
+	// printer.DecodeOptions.
+	Options            any
 	ControllerFailMode ControllerFailMode
 }
 
 type ConfigDisplayMessages struct {
-	WillPauseMessage *template.Template
-	PauseMessage     *template.Template
+	WillPauseMessage      *template.Template
+	PauseMessage          *template.Template
+	ScheduledPauseMessage *template.Template
 }
 
 type Config struct {
@@ -91,6 +134,9 @@ type Config struct {
 	ShouldCancelProgress float32
 	DisplayMessages      ConfigDisplayMessages
 	Controller           ConfigController
+	Console              printer.GCodePolicy
+	Tuning               printer.TuningLimits
+	AutoPowerOff         *power.Config // nil when disabled
 	Printers             map[string]ConfigPrinter
 }
 
@@ -133,9 +179,15 @@ func ParseRawConfig(raw RawConfig) (*Config, error) {
 		return nil, err
 	}
 
+	scheduledPauseMsg, err := template.New("scheduled_pause").Parse(raw.DisplayMessages.ScheduledPauseMessage)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg.DisplayMessages = ConfigDisplayMessages{
-		WillPauseMessage: willPauseMsg,
-		PauseMessage:     pauseMsg,
+		WillPauseMessage:      willPauseMsg,
+		PauseMessage:          pauseMsg,
+		ScheduledPauseMessage: scheduledPauseMsg,
 	}
 
 	{
@@ -185,13 +237,73 @@ func ParseRawConfig(raw RawConfig) (*Config, error) {
 		}
 	}
 
+	cfg.Console = printer.GCodePolicy{
+		Allow: raw.Console.Allow,
+		Deny:  printer.DefaultGCodeDeny,
+	}
+	if raw.Console.Deny != nil {
+		cfg.Console.Deny = *raw.Console.Deny
+	}
+
+	cfg.Tuning = printer.DefaultTuningLimits
+	if r := raw.Tuning.SpeedFactor; r != nil {
+		if len(r) != 2 || r[0] <= 0 || r[0] > r[1] {
+			return nil, fmt.Errorf("tuning: speed_factor must be [min, max]")
+		}
+		cfg.Tuning.MinSpeedFactor, cfg.Tuning.MaxSpeedFactor = r[0], r[1]
+	}
+	if r := raw.Tuning.ExtrudeFactor; r != nil {
+		if len(r) != 2 || r[0] <= 0 || r[0] > r[1] {
+			return nil, fmt.Errorf("tuning: extrude_factor must be [min, max]")
+		}
+		cfg.Tuning.MinExtrudeFactor, cfg.Tuning.MaxExtrudeFactor = r[0], r[1]
+	}
+	if raw.Tuning.MaxZOffset != nil {
+		if *raw.Tuning.MaxZOffset < 0 {
+			return nil, fmt.Errorf("tuning: max_z_offset must not be negative")
+		}
+		cfg.Tuning.MaxZOffset = *raw.Tuning.MaxZOffset
+	}
+
+	if raw.AutoPowerOff.IdleTime != "" {
+		idleTime, err := time.ParseDuration(raw.AutoPowerOff.IdleTime)
+		if err != nil {
+			return nil, fmt.Errorf("auto_power_off: %w", err)
+		}
+
+		cfg.AutoPowerOff = &power.Config{
+			IdleTime:       idleTime,
+			MaxTemperature: power.DefaultMaxTemperature,
+			Devices:        raw.AutoPowerOff.Devices,
+		}
+		if raw.AutoPowerOff.MaxTemperature != nil {
+			cfg.AutoPowerOff.MaxTemperature = *raw.AutoPowerOff.MaxTemperature
+		}
+	}
+
 	for _, rp := range raw.Printers {
 		p := ConfigPrinter{
 			Key:  rp.Key,
 			Name: rp.Name,
 			Url:  rp.Url,
+			Type: rp.Type,
+		}
+
+		if p.Type == "" {
+			p.Type = DefaultPrinterType
+		}
+
+		var decode func(v any) error
+		if !rp.Options.IsZero() {
+			decode = strictNodeDecoder(&rp.Options)
 		}
 
+		options, err := printer.DecodeOptions(p.Type, decode)
+		if err != nil {
+			return nil, fmt.Errorf("printer '%s': %w", rp.Key, err)
+		}
+		p.Options = options
+
 		failMode, err := ParseFailMode(rp.ControllerFailMode)
 		if err != nil {
 			return nil, err
@@ -206,3 +318,18 @@ func ParseRawConfig(raw RawConfig) (*Config, error) {
 
 	return &cfg, nil
 }
+
+// strictNodeDecoder decodes node, rejecting fields the target doesn't have so
+// that misspelled options fail at load time.
+func strictNodeDecoder(node *yaml.Node) func(v any) error {
+	return func(v any) error {
+		b, err := yaml.Marshal(node)
+		if err != nil {
+			return err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		decoder.KnownFields(true)
+		return decoder.Decode(v)
+	}
+}
@@ -60,4 +60,13 @@ type JobReport struct {
 
 	ContentId string    `json:"content_id"`
 	StartTime time.Time `json:"start_time"`
+
+	// CurrentLayer, TotalLayer and ZHeight (mm) are reported while the job
+	// is active, when known.
+	CurrentLayer *int     `json:"current_layer,omitempty"`
+	TotalLayer   *int     `json:"total_layer,omitempty"`
+	ZHeight      *float64 `json:"z_height,omitempty"`
+
+	// ExcludedObjects are the parts dropped from the job while it printed.
+	ExcludedObjects []string `json:"excluded_objects,omitempty"`
 }
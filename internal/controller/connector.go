@@ -92,11 +92,21 @@ func (c *Connector) update() {
 				Status: jobStatus,
 
 				ContentId: job.ContentId,
+
+				CurrentLayer: job.CurrentLayer,
+				TotalLayer:   job.TotalLayer,
+				ZHeight:      job.ZHeight,
 			}
 
 			if job.StartTime != nil {
 				jobReport.StartTime = *job.StartTime
 			}
+
+			if oe, ok := monitor.(printer.ObjectExcluder); ok {
+				if objects := oe.PrintObjects(); objects != nil {
+					jobReport.ExcludedObjects = objects.Excluded
+				}
+			}
 		}
 
 		// build status
@@ -0,0 +1,502 @@
+package creality
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+// trackedJob is a job as observed by the monitor. The firmware has no job
+// IDs, so jobs are identified by file name and the time they started.
+type trackedJob struct {
+	id        string
+	fileName  string
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	wsUrl       *url.URL
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastUpdateTime time.Time
+	report         map[string]any
+	status         *Status
+	job            *trackedJob
+
+	reportCh chan struct{}
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "creality"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	return ""
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error || m.status == nil || m.status.Err == nil || m.status.Err.ErrCode == 0 {
+		return nil
+	}
+
+	code := m.status.Err.ErrCode
+	return &printer.ErrorInfo{Code: &code}
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Name:      path.Base(m.job.fileName),
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status == "in_progress" && m.status != nil {
+		if m.status.PrintProgress != nil {
+			progress := float32(*m.status.PrintProgress) / 100
+			j.Progress = &progress
+		}
+
+		if m.status.PrintJobTime != nil {
+			printDuration := printer.Seconds(*m.status.PrintJobTime)
+			j.PrintDuration = &printDuration
+		}
+
+		totalDuration := printer.Seconds(time.Since(m.job.startTime).Seconds())
+		j.TotalDuration = &totalDuration
+
+		if m.status.PrintLeftTime != nil {
+			remaining := printer.Seconds(*m.status.PrintLeftTime)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the merged status report as last received.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := json.Marshal(m.report)
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(b)
+}
+
+func NewMonitor(name string, printerURL string, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("printer url '%s' has no host", printerURL)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.wsUrl = &url.URL{Scheme: "ws", Host: net.JoinHostPort(u.Hostname(), port)}
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.reportCh = make(chan struct{}, 1)
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	go m.connectLoop(ctx)
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-m.reportCh:
+				m.update(ctx)
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+// connectLoop keeps the WebSocket open, reconnecting with backoff.
+func (m *Monitor) connectLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Warnf("Printer connection closed: %s\n", err)
+
+		m.mu.Lock()
+		m.state = printer.Disconnected
+		m.lastUpdateTime = time.Now()
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// serve runs one connection until it fails.
+func (m *Monitor) serve(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, m.wsUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	m.writeMu.Lock()
+	m.conn = conn
+	m.writeMu.Unlock()
+
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer func() {
+		cancelConn()
+
+		m.writeMu.Lock()
+		m.conn = nil
+		m.writeMu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	m.mu.Lock()
+	m.state = printer.Unknown
+	m.report = make(map[string]any)
+	m.status = nil
+	m.mu.Unlock()
+
+	// Ask for a full report, then keep the connection alive.
+	if err := m.send(setRequest{Method: "get", Params: map[string]any{"ReqPrinterPara": 1}}); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-connCtx.Done():
+				return
+			case <-ticker.C:
+				err := m.send(heartbeat{ModeCode: "heart_beat", Msg: time.Now().Format(time.RFC3339)})
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		m.handleFrame(b)
+	}
+}
+
+func (m *Monitor) handleFrame(b []byte) {
+	var frame map[string]any
+	if err := json.Unmarshal(b, &frame); err != nil {
+		// Heartbeat replies ("ok") aren't JSON objects.
+		return
+	}
+
+	m.mu.Lock()
+	for k, v := range frame {
+		m.report[k] = v
+	}
+
+	status, err := decodeStatus(m.report)
+	if err != nil {
+		m.mu.Unlock()
+		m.logger.Warnf("Failed to decode status: %s\n", err)
+		return
+	}
+
+	m.status = status
+	m.lastUpdateTime = time.Now()
+
+	var printDuration time.Duration
+	if status.PrintJobTime != nil {
+		printDuration = time.Duration(*status.PrintJobTime) * time.Second
+	}
+
+	m.state = mapState(status, printDuration)
+	m.trackJob(status, printDuration)
+	m.mu.Unlock()
+
+	select {
+	case m.reportCh <- struct{}{}:
+	default:
+	}
+}
+
+// trackJob starts or finishes m.job from the latest status. It must be
+// called with m.mu held.
+func (m *Monitor) trackJob(status *Status, printDuration time.Duration) {
+	if status.State == nil {
+		return
+	}
+
+	switch *status.State {
+	case StatePrinting, StatePaused:
+		if status.PrintFileName == "" {
+			return
+		}
+
+		if m.job != nil && m.job.status == "in_progress" && m.job.fileName == status.PrintFileName {
+			return
+		}
+
+		startTime := time.Now().Add(-printDuration)
+		m.job = &trackedJob{
+			id:        fmt.Sprintf("%s@%d", status.PrintFileName, startTime.Unix()),
+			fileName:  status.PrintFileName,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+	default:
+		if m.job == nil || m.job.status != "in_progress" {
+			return
+		}
+
+		endTime := time.Now()
+		m.job.endTime = &endTime
+
+		switch *status.State {
+		case StateCompleted:
+			m.job.status = "completed"
+		case StateFailed:
+			m.job.status = "error"
+		case StateStopped:
+			m.job.status = "cancelled"
+		default:
+			m.job.status = "interrupted"
+		}
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	m.mu.RLock()
+	status := m.status
+	state := m.state
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+	m.mu.RUnlock()
+
+	if status == nil {
+		return
+	}
+
+	var printDuration time.Duration
+	if status.PrintJobTime != nil {
+		printDuration = time.Duration(*status.PrintJobTime) * time.Second
+	}
+
+	var progress float32
+	if status.PrintProgress != nil {
+		progress = float32(*status.PrintProgress) / 100
+	}
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, printer.Observation{
+		State:         state,
+		PrintDuration: printDuration,
+		Progress:      progress,
+	})
+}
+
+func (m *Monitor) send(v any) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if m.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_ = m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return m.conn.WriteJSON(v)
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(_ context.Context) error {
+	return m.send(setRequest{Method: "set", Params: map[string]any{"pause": 1}})
+}
+
+func (m *Monitor) ResumePrint(_ context.Context) error {
+	return m.send(setRequest{Method: "set", Params: map[string]any{"pause": 0}})
+}
+
+func (m *Monitor) CancelPrint(_ context.Context) error {
+	return m.send(setRequest{Method: "set", Params: map[string]any{"stop": 1}})
+}
+
+// SetStatusMessage is a no-op: the stock firmware has no message display.
+func (m *Monitor) SetStatusMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+func mapState(status *Status, printDuration time.Duration) printer.PrinterState {
+	if status.State == nil {
+		return printer.Unknown
+	}
+
+	switch *status.State {
+	case StateIdle, StateCompleted, StateStopped:
+		return printer.Ready
+	case StatePrinting:
+		if printDuration > 0 {
+			return printer.Printing
+		}
+		return printer.PrePrint
+	case StatePaused:
+		return printer.Pause
+	case StateFailed:
+		return printer.Error
+	default:
+		return printer.Unknown
+	}
+}
@@ -0,0 +1,86 @@
+package creality
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Creality's stock K1/K1C/K1 Max firmware pushes its status as JSON text
+// frames on a WebSocket at ws://<printer>:9999. Frames only carry the fields
+// that changed since the previous one, so the monitor merges them into a full
+// report. Commands are sent on the same socket as {"method": "set",
+// "params": {...}} frames.
+
+const DefaultPort = 9999
+
+// Print states reported in the "state" field.
+const (
+	StateIdle      = 0
+	StatePrinting  = 1
+	StateCompleted = 2
+	StateFailed    = 3
+	StateStopped   = 4
+	StatePaused    = 5
+)
+
+// flexInt decodes a number the firmware sends either as a JSON number or as
+// a numeric string.
+type flexInt int
+
+func (f *flexInt) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		n = json.Number(s)
+	}
+
+	v, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return err
+	}
+
+	*f = flexInt(v)
+	return nil
+}
+
+// Status is the subset of the merged report the monitor reads.
+type Status struct {
+	State         *flexInt `json:"state"`
+	PrintProgress *flexInt `json:"printProgress"` // percent
+	PrintJobTime  *flexInt `json:"printJobTime"`  // seconds
+	PrintLeftTime *flexInt `json:"printLeftTime"` // seconds
+	PrintFileName string   `json:"printFileName"`
+
+	Err *struct {
+		ErrCode int `json:"errcode"`
+		Key     int `json:"key"`
+	} `json:"err"`
+}
+
+func decodeStatus(merged map[string]any) (*Status, error) {
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(Status)
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// setRequest is a command frame, e.g. {"method":"set","params":{"pause":1}}.
+type setRequest struct {
+	Method string         `json:"method"`
+	Params map[string]any `json:"params"`
+}
+
+type heartbeat struct {
+	ModeCode string `json:"ModeCode"`
+	Msg      string `json:"msg"`
+}
@@ -0,0 +1,409 @@
+package duet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ERRRespNotOk is returned when the board answers with an unexpected HTTP
+// status code.
+type ERRRespNotOk struct {
+	error
+
+	statusCode int
+	respBody   []byte
+}
+
+func (e ERRRespNotOk) RespStatusCode() int {
+	return e.statusCode
+}
+
+func (e ERRRespNotOk) RespBody() []byte {
+	return e.respBody
+}
+
+func (e ERRRespNotOk) Error() string {
+	return e.error.Error()
+}
+
+// ---------------------------
+// Object model
+
+// StateModel is the subset of the object model's "state" key the monitor
+// reads.
+type StateModel struct {
+	// Status is one of disconnected, starting, updating, off, halted,
+	// pausing, paused, resuming, cancelling, processing, simulating, busy,
+	// changingTool or idle.
+	Status         string `json:"status"`
+	DisplayMessage string `json:"displayMessage"`
+}
+
+type JobFileModel struct {
+	FileName *string `json:"fileName"`
+	Size     int64   `json:"size"`
+}
+
+type JobTimesLeft struct {
+	File     *float64 `json:"file"`
+	Filament *float64 `json:"filament"`
+	Slicer   *float64 `json:"slicer"`
+}
+
+// JobModel is the subset of the object model's "job" key the monitor reads.
+type JobModel struct {
+	File              *JobFileModel `json:"file"`
+	FilePosition      *int64        `json:"filePosition"`
+	Duration          *float64      `json:"duration"` // seconds since the job started
+	Layer             *int          `json:"layer"`
+	LastFileName      *string       `json:"lastFileName"`
+	LastFileCancelled bool          `json:"lastFileCancelled"`
+	LastFileAborted   bool          `json:"lastFileAborted"`
+	TimesLeft         JobTimesLeft  `json:"timesLeft"`
+}
+
+// FileName returns the file being printed, "" when there is none.
+func (j *JobModel) FileName() string {
+	if j.File == nil || j.File.FileName == nil {
+		return ""
+	}
+
+	return *j.File.FileName
+}
+
+// Progress returns the fraction of the file processed, as DWC computes it.
+func (j *JobModel) Progress() (float32, bool) {
+	if j.File == nil || j.File.Size <= 0 || j.FilePosition == nil {
+		return 0, false
+	}
+
+	return float32(float64(*j.FilePosition) / float64(j.File.Size)), true
+}
+
+type ObjectModel struct {
+	State StateModel `json:"state"`
+	Job   JobModel   `json:"job"`
+}
+
+// ---------------------------
+// Transports
+
+// ErrInvalidPassword is returned when the board rejects the configured
+// password.
+var ErrInvalidPassword = errors.New("invalid password")
+
+// Mode selects how the board is reached.
+type Mode string
+
+const (
+	// ModeAuto probes for DSF and falls back to standalone.
+	ModeAuto Mode = ""
+	// ModeStandalone talks to RepRapFirmware's own web server (rr_* requests).
+	ModeStandalone Mode = "standalone"
+	// ModeDSF talks to the Duet Software Framework on an attached SBC.
+	ModeDSF Mode = "dsf"
+)
+
+// Client reads the object model and sends G-code in either mode. It logs in
+// lazily and again whenever the session expires.
+type Client struct {
+	baseUrl    *url.URL
+	password   string
+	mode       Mode
+	httpClient *http.Client
+
+	mu         sync.Mutex
+	connected  bool
+	sessionKey string
+}
+
+func NewClient(baseUrl *url.URL, password string, mode Mode) *Client {
+	return &Client{
+		baseUrl:    baseUrl,
+		password:   password,
+		mode:       mode,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Mode returns the mode in use; ModeAuto until the first successful
+// request.
+func (c *Client) Mode() Mode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.mode
+}
+
+func (c *Client) do(ctx context.Context, method string, u *url.URL, body []byte) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.sessionKey != "" {
+		req.Header.Set("X-Session-Key", c.sessionKey)
+	}
+	c.mu.Unlock()
+
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ERRRespNotOk{
+			error:      fmt.Errorf("non-200 http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+			respBody:   b,
+		}
+	}
+
+	return b, nil
+}
+
+// withSession runs f after logging in if needed, and retries it once after
+// logging in again if the session has expired.
+func (c *Client) withSession(ctx context.Context, f func() error) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	err := f()
+
+	var nonOkErr ERRRespNotOk
+	if errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.connected = false
+		c.mu.Unlock()
+
+		if err := c.connect(ctx); err != nil {
+			return err
+		}
+
+		return f()
+	}
+
+	return err
+}
+
+func (c *Client) connect(ctx context.Context) error {
+	c.mu.Lock()
+	connected := c.connected
+	mode := c.mode
+	c.mu.Unlock()
+
+	if connected {
+		return nil
+	}
+
+	if mode == ModeAuto {
+		u := c.baseUrl.JoinPath("/machine/status")
+		_, err := c.do(ctx, "GET", u, nil)
+
+		var nonOkErr ERRRespNotOk
+		switch {
+		case err == nil, errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == http.StatusUnauthorized:
+			mode = ModeDSF
+		case errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == http.StatusNotFound:
+			mode = ModeStandalone
+		default:
+			return err
+		}
+	}
+
+	var sessionKey string
+	var err error
+	if mode == ModeDSF {
+		sessionKey, err = c.connectDSF(ctx)
+	} else {
+		sessionKey, err = c.connectStandalone(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.mode = mode
+	c.connected = true
+	c.sessionKey = sessionKey
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Client) connectStandalone(ctx context.Context) (string, error) {
+	u := c.baseUrl.JoinPath("/rr_connect")
+	query := u.Query()
+	password := c.password
+	if password == "" {
+		// RepRapFirmware's default; accepted when no password is set.
+		password = "reprap"
+	}
+	query.Set("password", password)
+	query.Set("time", time.Now().Format("2006-01-02T15:04:05"))
+	u.RawQuery = query.Encode()
+
+	b, err := c.do(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	out := new(struct {
+		Err        int    `json:"err"`
+		SessionKey *int64 `json:"sessionKey"`
+	})
+	if err := json.Unmarshal(b, out); err != nil {
+		return "", err
+	}
+
+	switch out.Err {
+	case 0:
+	case 1:
+		return "", ErrInvalidPassword
+	case 2:
+		return "", errors.New("no more sessions available")
+	default:
+		return "", fmt.Errorf("rr_connect error %d", out.Err)
+	}
+
+	if out.SessionKey == nil {
+		return "", nil
+	}
+
+	return strconv.FormatInt(*out.SessionKey, 10), nil
+}
+
+func (c *Client) connectDSF(ctx context.Context) (string, error) {
+	if c.password == "" {
+		return "", nil
+	}
+
+	u := c.baseUrl.JoinPath("/machine/connect")
+	query := u.Query()
+	query.Set("password", c.password)
+	u.RawQuery = query.Encode()
+
+	b, err := c.do(ctx, "GET", u, nil)
+
+	var nonOkErr ERRRespNotOk
+	if errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == http.StatusForbidden {
+		return "", ErrInvalidPassword
+	} else if err != nil {
+		return "", err
+	}
+
+	out := new(struct {
+		SessionKey string `json:"sessionKey"`
+	})
+	if err := json.Unmarshal(b, out); err != nil {
+		return "", err
+	}
+
+	return out.SessionKey, nil
+}
+
+// GetObjectModel returns the "state" and "job" keys of the object model.
+func (c *Client) GetObjectModel(ctx context.Context) (*ObjectModel, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(ObjectModel)
+	err := c.withSession(ctx, func() error {
+		if c.Mode() == ModeDSF {
+			b, err := c.do(ctx, "GET", c.baseUrl.JoinPath("/machine/status"), nil)
+			if err != nil {
+				return err
+			}
+
+			return json.Unmarshal(b, out)
+		}
+
+		if err := c.getStandaloneKey(ctx, "state", &out.State); err != nil {
+			return err
+		}
+
+		return c.getStandaloneKey(ctx, "job", &out.Job)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *Client) getStandaloneKey(ctx context.Context, key string, out any) error {
+	u := c.baseUrl.JoinPath("/rr_model")
+	query := u.Query()
+	query.Set("key", key)
+	query.Set("flags", "d99vn")
+	u.RawQuery = query.Encode()
+
+	b, err := c.do(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp := new(struct {
+		Result json.RawMessage `json:"result"`
+	})
+	if err := json.Unmarshal(b, resp); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(resp.Result, out)
+}
+
+// SendGCode queues code for execution. In standalone mode the reply is not
+// waited for; DSF returns it once the code has run.
+func (c *Client) SendGCode(ctx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	return c.withSession(ctx, func() error {
+		if c.Mode() == ModeDSF {
+			_, err := c.do(ctx, "POST", c.baseUrl.JoinPath("/machine/code"), []byte(code))
+			return err
+		}
+
+		u := c.baseUrl.JoinPath("/rr_gcode")
+		query := u.Query()
+		query.Set("gcode", code)
+		u.RawQuery = query.Encode()
+
+		_, err := c.do(ctx, "GET", u, nil)
+		return err
+	})
+}
+
+// quoteString quotes s as a RepRapFirmware string parameter.
+func quoteString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,16 @@
+package duet
+
+import (
+	"3dp-controller/internal/printer"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	printer.Register("duet", printer.Backend{
+		NewOptions: func() any { return new(Options) },
+		New: func(cfg printer.BackendConfig, logger *zap.SugaredLogger) (printer.Printer, error) {
+			return NewMonitor(cfg.Name, cfg.Url, *cfg.Options.(*Options), cfg.Monitor, logger)
+		},
+	})
+}
@@ -0,0 +1,384 @@
+package duet
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/util"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+
+// Options are the connection settings specific to a Duet board.
+type Options struct {
+	// Password is the board's M551 password (standalone) or the DSF
+	// password; may be empty.
+	Password string `yaml:"password"`
+	// Mode defaults to ModeAuto.
+	Mode Mode `yaml:"mode"`
+}
+
+func (o *Options) Validate() error {
+	switch o.Mode {
+	case ModeAuto, ModeStandalone, ModeDSF:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode '%s'", o.Mode)
+	}
+}
+
+// trackedJob is a job as observed by the monitor. RepRapFirmware has no job
+// IDs, so jobs are identified by file name and the time they started.
+type trackedJob struct {
+	id        string
+	fileName  string
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+	client      *Client
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	model          *ObjectModel
+	job            *trackedJob
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "duet"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.model == nil {
+		return ""
+	}
+
+	return m.model.State.DisplayMessage
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Name:      m.job.fileName,
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status == "in_progress" && m.model != nil {
+		jobModel := m.model.Job
+
+		if progress, ok := jobModel.Progress(); ok {
+			j.Progress = &progress
+		}
+
+		if jobModel.Duration != nil {
+			printDuration := printer.Seconds(*jobModel.Duration)
+			j.PrintDuration = &printDuration
+			j.TotalDuration = &printDuration
+		}
+
+		if left := jobModel.TimesLeft.File; left != nil {
+			remaining := printer.Seconds(*left)
+			j.EstimatedRemaining = &remaining
+		} else if left := jobModel.TimesLeft.Slicer; left != nil {
+			remaining := printer.Seconds(*left)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.logger = logger
+	m.client = NewClient(u, options.Password, options.Mode)
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		m.update(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	model, err := m.client.GetObjectModel(ctx)
+
+	m.mu.Lock()
+	m.lastUpdateTime = time.Now()
+
+	if err != nil {
+		m.model = nil
+
+		var nonOkErr ERRRespNotOk
+		if util.IsErrNetworkProblem(err) {
+			m.state = printer.Disconnected
+			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) {
+			m.state = printer.InternalError
+			code := nonOkErr.RespStatusCode()
+			m.lastError = &printer.ErrorInfo{Code: &code, Message: err.Error()}
+			m.logger.Warnf("Failed to get object model: %s, status_code: %d\n", err, code)
+		} else {
+			m.state = printer.InternalError
+			m.lastError = &printer.ErrorInfo{Message: err.Error()}
+			m.logger.Errorf("Error getting object model: %s\n", err)
+		}
+
+		m.mu.Unlock()
+		return
+	}
+
+	m.model = model
+
+	var printDuration time.Duration
+	if model.Job.Duration != nil {
+		printDuration = time.Duration(*model.Job.Duration * float64(time.Second))
+	}
+
+	m.state = mapState(model.State.Status, model.Job.FileName() != "", printDuration)
+	m.lastError = nil
+	if m.state == printer.Error {
+		m.lastError = &printer.ErrorInfo{Message: "machine " + model.State.Status}
+	}
+
+	m.trackJob(&model.Job, printDuration)
+
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+
+	progress, _ := model.Job.Progress()
+	obs := printer.Observation{
+		State:          m.state,
+		PrintDuration:  printDuration,
+		Progress:       progress,
+		DisplayMessage: model.State.DisplayMessage,
+	}
+	m.mu.Unlock()
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, obs)
+}
+
+// trackJob starts or finishes m.job from the job model. It must be called
+// with m.mu held.
+func (m *Monitor) trackJob(jobModel *JobModel, printDuration time.Duration) {
+	fileName := jobModel.FileName()
+
+	if fileName != "" {
+		if m.job != nil && m.job.status == "in_progress" && m.job.fileName == fileName {
+			return
+		}
+
+		startTime := time.Now().Add(-printDuration)
+		m.job = &trackedJob{
+			id:        fmt.Sprintf("%s@%d", fileName, startTime.Unix()),
+			fileName:  fileName,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+		return
+	}
+
+	if m.job != nil && m.job.status == "in_progress" {
+		endTime := time.Now()
+		m.job.endTime = &endTime
+
+		switch {
+		case jobModel.LastFileAborted:
+			m.job.status = "error"
+		case jobModel.LastFileCancelled:
+			m.job.status = "cancelled"
+		default:
+			m.job.status = "completed"
+		}
+	}
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	return m.client.SendGCode(ctx, "M25")
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	return m.client.SendGCode(ctx, "M24")
+}
+
+// CancelPrint pauses the print first if needed: RepRapFirmware only cancels
+// a paused print on M0.
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	if m.State() != printer.Pause {
+		if err := m.client.SendGCode(ctx, "M25"); err != nil {
+			return err
+		}
+	}
+
+	return m.client.SendGCode(ctx, "M0")
+}
+
+func (m *Monitor) SetStatusMessage(ctx context.Context, msg string) error {
+	return m.client.SendGCode(ctx, "M117 "+quoteString(msg))
+}
+
+// mapState maps state.status onto printer.PrinterState.
+func mapState(status string, hasJob bool, printDuration time.Duration) printer.PrinterState {
+	switch status {
+	case "idle":
+		return printer.Ready
+	case "processing", "resuming", "busy", "changingTool":
+		if !hasJob {
+			return printer.Unknown
+		}
+		if printDuration > 0 {
+			return printer.Printing
+		}
+		return printer.PrePrint
+	case "pausing", "paused":
+		return printer.Pause
+	case "halted":
+		return printer.Error
+	case "off", "disconnected":
+		return printer.Disconnected
+	default:
+		// starting, updating, cancelling, simulating
+		return printer.Unknown
+	}
+}
@@ -0,0 +1,639 @@
+package elegoo
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+// Options are the connection settings specific to an SDCP printer.
+type Options struct {
+	// MainboardID is discovered over UDP when empty.
+	MainboardID string `yaml:"mainboard_id"`
+}
+
+// ERRCommandRejected is returned when the printer acknowledges a command
+// with a non-zero Ack.
+type ERRCommandRejected struct {
+	cmd int
+	ack int
+}
+
+func (e ERRCommandRejected) Ack() int {
+	return e.ack
+}
+
+func (e ERRCommandRejected) Error() string {
+	return fmt.Sprintf("command %d rejected with ack %d", e.cmd, e.ack)
+}
+
+// trackedJob is a job as observed by the monitor. Jobs are identified by
+// the printer's TaskId, or by file name and start time when it has none.
+type trackedJob struct {
+	id        string
+	fileName  string
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	wsUrl       *url.URL
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+
+	pendingMu sync.Mutex
+	pending   map[string]chan responseData
+
+	mu             sync.RWMutex
+	mainboardId    string
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	report         json.RawMessage
+	status         *Status
+	job            *trackedJob
+
+	reportCh chan struct{}
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "elegoo"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	return ""
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Name:      path.Base(m.job.fileName),
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status == "in_progress" && m.status != nil {
+		info := m.status.PrintInfo
+
+		progress := info.Progress()
+		j.Progress = &progress
+
+		printDuration := printer.Seconds(info.PrintDuration().Seconds())
+		j.PrintDuration = &printDuration
+
+		totalDuration := printer.Seconds(time.Since(m.job.startTime).Seconds())
+		j.TotalDuration = &totalDuration
+
+		if info.TotalTicks > info.CurrentTicks {
+			remaining := printer.Seconds((info.TotalTicks - info.CurrentTicks) / 1000)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the last Status as received.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.report
+}
+
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("printer url '%s' has no host", printerURL)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(WebSocketPort)
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.wsUrl = &url.URL{Scheme: "ws", Host: net.JoinHostPort(u.Hostname(), port), Path: "/websocket"}
+	m.mainboardId = options.MainboardID
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.pending = make(map[string]chan responseData)
+	m.reportCh = make(chan struct{}, 1)
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	go m.connectLoop(ctx)
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-m.reportCh:
+				m.update(ctx)
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+// connectLoop keeps the WebSocket open, reconnecting with backoff.
+func (m *Monitor) connectLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Warnf("Printer connection closed: %s\n", err)
+
+		m.mu.Lock()
+		m.state = printer.Disconnected
+		m.lastError = nil
+		m.lastUpdateTime = time.Now()
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// resolveMainboardId returns the configured or previously discovered
+// MainboardID, asking the printer over UDP otherwise.
+func (m *Monitor) resolveMainboardId(ctx context.Context) (string, error) {
+	m.mu.RLock()
+	id := m.mainboardId
+	m.mu.RUnlock()
+
+	if id != "" {
+		return id, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	found, err := discover(ctx, m.printerUrl.Hostname(), 1)
+	if err != nil {
+		return "", err
+	}
+
+	if len(found) == 0 {
+		return "", errors.New("printer did not answer discovery")
+	}
+
+	m.mu.Lock()
+	m.mainboardId = found[0].MainboardID
+	m.mu.Unlock()
+
+	return found[0].MainboardID, nil
+}
+
+// serve runs one connection until it fails.
+func (m *Monitor) serve(ctx context.Context) error {
+	mainboardId, err := m.resolveMainboardId(ctx)
+	if err != nil {
+		return err
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, m.wsUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	m.writeMu.Lock()
+	m.conn = conn
+	m.writeMu.Unlock()
+
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer func() {
+		cancelConn()
+
+		m.writeMu.Lock()
+		m.conn = nil
+		m.writeMu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	m.mu.Lock()
+	m.state = printer.Unknown
+	m.status = nil
+	m.report = nil
+	m.mu.Unlock()
+
+	// Ask for the current status and for periodic pushes, then keep the
+	// connection alive.
+	if err := m.send(newRequest(mainboardId, CmdStatus, nil)); err != nil {
+		return err
+	}
+
+	refresh := map[string]any{"TimePeriod": 5000}
+	if err := m.send(newRequest(mainboardId, CmdStatusRefresh, refresh)); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-connCtx.Done():
+				return
+			case <-ticker.C:
+				if err := m.sendText("ping"); err != nil {
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		m.handleFrame(b)
+	}
+}
+
+func (m *Monitor) handleFrame(b []byte) {
+	var f frame
+	if err := json.Unmarshal(b, &f); err != nil {
+		// "pong" isn't JSON.
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(f.Topic, "sdcp/status/"):
+		m.handleStatus(f.Status)
+	case strings.HasPrefix(f.Topic, "sdcp/response/"):
+		var data responseData
+		if err := json.Unmarshal(f.Data, &data); err != nil {
+			return
+		}
+
+		m.pendingMu.Lock()
+		ch, ok := m.pending[data.RequestID]
+		delete(m.pending, data.RequestID)
+		m.pendingMu.Unlock()
+
+		if ok {
+			ch <- data
+		}
+	}
+}
+
+func (m *Monitor) handleStatus(raw json.RawMessage) {
+	status := new(Status)
+	if err := json.Unmarshal(raw, status); err != nil {
+		m.logger.Warnf("Failed to decode status: %s\n", err)
+		return
+	}
+
+	m.mu.Lock()
+	m.report = raw
+	m.status = status
+	m.lastUpdateTime = time.Now()
+
+	m.state = mapState(status)
+	m.lastError = nil
+	if m.state == printer.Error {
+		code := status.PrintInfo.ErrorNumber
+		m.lastError = &printer.ErrorInfo{Code: &code, Message: printErrors[code]}
+	}
+
+	m.trackJob(&status.PrintInfo, status.CurrentStatus.Has(MachinePrinting))
+	m.mu.Unlock()
+
+	select {
+	case m.reportCh <- struct{}{}:
+	default:
+	}
+}
+
+// trackJob starts or finishes m.job from the latest status. It must be
+// called with m.mu held.
+func (m *Monitor) trackJob(info *PrintInfo, printing bool) {
+	active := printing && info.Filename != "" &&
+		info.Status != PrintStopped && info.Status != PrintComplete
+
+	if active {
+		id := info.TaskId
+		startTime := time.Now().Add(-info.PrintDuration())
+		if id == "" {
+			if m.job != nil && m.job.status == "in_progress" && m.job.fileName == info.Filename {
+				return
+			}
+			id = fmt.Sprintf("%s@%d", info.Filename, startTime.Unix())
+		}
+
+		if m.job != nil && m.job.status == "in_progress" && m.job.id == id {
+			return
+		}
+
+		m.job = &trackedJob{
+			id:        id,
+			fileName:  info.Filename,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+		return
+	}
+
+	if m.job == nil || m.job.status != "in_progress" {
+		return
+	}
+
+	endTime := time.Now()
+	m.job.endTime = &endTime
+
+	switch {
+	case info.ErrorNumber != 0:
+		m.job.status = "error"
+	case info.Status == PrintComplete:
+		m.job.status = "completed"
+	case info.Status == PrintStopped:
+		m.job.status = "cancelled"
+	default:
+		m.job.status = "interrupted"
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	m.mu.RLock()
+	status := m.status
+	state := m.state
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+	m.mu.RUnlock()
+
+	if status == nil {
+		return
+	}
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, printer.Observation{
+		State:         state,
+		PrintDuration: status.PrintInfo.PrintDuration(),
+		Progress:      status.PrintInfo.Progress(),
+	})
+}
+
+func (m *Monitor) send(v any) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if m.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_ = m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return m.conn.WriteJSON(v)
+}
+
+func (m *Monitor) sendText(s string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if m.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_ = m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return m.conn.WriteMessage(websocket.TextMessage, []byte(s))
+}
+
+// command sends cmd and waits for the printer to acknowledge it.
+func (m *Monitor) command(ctx context.Context, cmd int) error {
+	m.mu.RLock()
+	mainboardId := m.mainboardId
+	m.mu.RUnlock()
+
+	req := newRequest(mainboardId, cmd, nil)
+
+	ch := make(chan responseData, 1)
+	m.pendingMu.Lock()
+	m.pending[req.Data.RequestID] = ch
+	m.pendingMu.Unlock()
+
+	defer func() {
+		m.pendingMu.Lock()
+		delete(m.pending, req.Data.RequestID)
+		m.pendingMu.Unlock()
+	}()
+
+	if err := m.send(req); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case resp := <-ch:
+		if resp.Data.Ack != 0 {
+			return ERRCommandRejected{cmd: cmd, ack: resp.Data.Ack}
+		}
+		return nil
+	}
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	return m.command(ctx, CmdPausePrint)
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	return m.command(ctx, CmdResumePrint)
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	return m.command(ctx, CmdStopPrint)
+}
+
+// SetStatusMessage is a no-op: SDCP has no way to show a message.
+func (m *Monitor) SetStatusMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+// mapState maps CurrentStatus and PrintInfo.Status onto
+// printer.PrinterState.
+func mapState(status *Status) printer.PrinterState {
+	info := status.PrintInfo
+
+	if info.ErrorNumber != 0 {
+		return printer.Error
+	}
+
+	switch {
+	case status.CurrentStatus.Has(MachinePrinting):
+		switch info.Status {
+		case PrintPausing, PrintPaused:
+			return printer.Pause
+		case PrintStopped, PrintComplete, PrintIdle:
+			return printer.Ready
+		case PrintHoming, PrintFileChecking:
+			return printer.PrePrint
+		default:
+			// Exposing, lifting, dropping and stopping, plus the FDM
+			// statuses of the Centauri Carbon.
+			if info.CurrentTicks > 0 {
+				return printer.Printing
+			}
+			return printer.PrePrint
+		}
+	case status.CurrentStatus.Has(MachineFileTransfer),
+		status.CurrentStatus.Has(MachineExposureTest),
+		status.CurrentStatus.Has(MachineDevicesTesting):
+		return printer.Unknown
+	case status.CurrentStatus.Has(MachineIdle):
+		return printer.Ready
+	default:
+		return printer.Unknown
+	}
+}
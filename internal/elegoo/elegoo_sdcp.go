@@ -0,0 +1,264 @@
+package elegoo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Elegoo's resin printers (Saturn 3 Ultra, Saturn 4, Mars 5, ...) and the
+// Centauri Carbon speak SDCP v3. Printers answer the UDP datagram "M99999"
+// on port 3000 with their identity, and serve JSON text frames on a
+// WebSocket at ws://<printer>:3030/websocket. Every frame names an MQTT-like
+// topic: the printer pushes sdcp/status/<MainboardID> whenever something
+// changes, and commands sent to sdcp/request/<MainboardID> are acknowledged
+// on sdcp/response/<MainboardID>.
+
+const (
+	DiscoveryPort = 3000
+	WebSocketPort = 3030
+
+	discoveryMessage = "M99999"
+)
+
+// Machine statuses reported in Status.CurrentStatus.
+const (
+	MachineIdle           = 0
+	MachinePrinting       = 1
+	MachineFileTransfer   = 2
+	MachineExposureTest   = 3
+	MachineDevicesTesting = 4
+)
+
+// Print statuses reported in PrintInfo.Status.
+const (
+	PrintIdle         = 0
+	PrintHoming       = 1
+	PrintDropping     = 2
+	PrintExposuring   = 3
+	PrintLifting      = 4
+	PrintPausing      = 5
+	PrintPaused       = 6
+	PrintStopping     = 7
+	PrintStopped      = 8
+	PrintComplete     = 9
+	PrintFileChecking = 10
+)
+
+// Commands sent in requestData.Cmd.
+const (
+	CmdStatus        = 0
+	CmdAttributes    = 1
+	CmdPausePrint    = 129
+	CmdStopPrint     = 130
+	CmdResumePrint   = 131
+	CmdStatusRefresh = 512
+)
+
+// ---------
+// Discovery
+
+type DiscoveredPrinter struct {
+	Name            string `json:"Name"`
+	MachineName     string `json:"MachineName"`
+	BrandName       string `json:"BrandName"`
+	MainboardIP     string `json:"MainboardIP"`
+	MainboardID     string `json:"MainboardID"`
+	ProtocolVersion string `json:"ProtocolVersion"`
+	FirmwareVersion string `json:"FirmwareVersion"`
+}
+
+type discoveryReply struct {
+	Id   string            `json:"Id"`
+	Data DiscoveredPrinter `json:"Data"`
+}
+
+// Discover sends the discovery datagram to host (a printer's address, or a
+// broadcast address such as 255.255.255.255) and collects the replies that
+// arrive before ctx is done.
+func Discover(ctx context.Context, host string) ([]DiscoveredPrinter, error) {
+	return discover(ctx, host, 0)
+}
+
+// discover is Discover, returning early once limit printers answered if
+// limit is positive.
+func discover(ctx context.Context, host string, limit int) ([]DiscoveredPrinter, error) {
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(DiscoveryPort)))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(3 * time.Second)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if _, err := conn.WriteTo([]byte(discoveryMessage), addr); err != nil {
+		return nil, err
+	}
+
+	var out []DiscoveredPrinter
+	buf := make([]byte, 4096)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return out, nil
+			}
+			return out, err
+		}
+
+		var reply discoveryReply
+		if err := json.Unmarshal(buf[:n], &reply); err != nil || reply.Data.MainboardID == "" {
+			continue
+		}
+
+		out = append(out, reply.Data)
+
+		if len(out) == limit || ctx.Err() != nil {
+			return out, nil
+		}
+	}
+}
+
+// ------
+// Frames
+
+// frame is any message received on the WebSocket.
+type frame struct {
+	Id          string          `json:"Id"`
+	Topic       string          `json:"Topic"`
+	MainboardID string          `json:"MainboardID"`
+	Status      json.RawMessage `json:"Status"`
+	Data        json.RawMessage `json:"Data"`
+}
+
+// machineStatus is CurrentStatus, which firmware sends either as a number
+// or, from SDCP v3, as a list of numbers.
+type machineStatus []int
+
+func (s *machineStatus) UnmarshalJSON(b []byte) error {
+	var list []int
+	if err := json.Unmarshal(b, &list); err == nil {
+		*s = list
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+
+	*s = machineStatus{n}
+	return nil
+}
+
+func (s machineStatus) Has(status int) bool {
+	return slices.Contains(s, status)
+}
+
+type PrintInfo struct {
+	Status       int     `json:"Status"`
+	CurrentLayer int     `json:"CurrentLayer"`
+	TotalLayer   int     `json:"TotalLayer"`
+	CurrentTicks float64 `json:"CurrentTicks"` // milliseconds
+	TotalTicks   float64 `json:"TotalTicks"`   // milliseconds
+	Filename     string  `json:"Filename"`
+	ErrorNumber  int     `json:"ErrorNumber"`
+	TaskId       string  `json:"TaskId"`
+}
+
+// Progress is the share of layers printed.
+func (p PrintInfo) Progress() float32 {
+	if p.TotalLayer <= 0 {
+		return 0
+	}
+	return min(float32(p.CurrentLayer)/float32(p.TotalLayer), 1)
+}
+
+func (p PrintInfo) PrintDuration() time.Duration {
+	return time.Duration(p.CurrentTicks * float64(time.Millisecond))
+}
+
+// Status is the payload of an sdcp/status frame.
+type Status struct {
+	CurrentStatus  machineStatus `json:"CurrentStatus"`
+	PreviousStatus int           `json:"PreviousStatus"`
+	PrintInfo      PrintInfo     `json:"PrintInfo"`
+}
+
+// ErrorNumber values in PrintInfo.
+var printErrors = map[int]string{
+	1: "file MD5 check failed",
+	2: "file read failed",
+	3: "resolution mismatch",
+	4: "format mismatch",
+	5: "machine model mismatch",
+}
+
+// --------
+// Requests
+
+type request struct {
+	Id    string      `json:"Id"`
+	Data  requestData `json:"Data"`
+	Topic string      `json:"Topic"`
+}
+
+type requestData struct {
+	Cmd         int    `json:"Cmd"`
+	Data        any    `json:"Data"`
+	RequestID   string `json:"RequestID"`
+	MainboardID string `json:"MainboardID"`
+	TimeStamp   int64  `json:"TimeStamp"`
+	From        int    `json:"From"`
+}
+
+// responseData is the Data of an sdcp/response frame.
+type responseData struct {
+	Cmd       int    `json:"Cmd"`
+	RequestID string `json:"RequestID"`
+	Data      struct {
+		Ack int `json:"Ack"`
+	} `json:"Data"`
+}
+
+func newRequest(mainboardId string, cmd int, data any) request {
+	if data == nil {
+		data = map[string]any{}
+	}
+
+	return request{
+		Id: newRequestId(),
+		Data: requestData{
+			Cmd:         cmd,
+			Data:        data,
+			RequestID:   newRequestId(),
+			MainboardID: mainboardId,
+			TimeStamp:   time.Now().Unix(),
+			From:        0,
+		},
+		Topic: "sdcp/request/" + mainboardId,
+	}
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,138 @@
+package grbl
+
+import (
+	"3dp-controller/internal/serialport"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GRBL answers the real-time command '?' with a status report such as
+// "<Run|MPos:10.000,0.000,0.000|FS:500,0>" (1.1) or
+// "<Run,MPos:10.000,0.000,0.000,WPos:...>" (0.9). Real-time commands are
+// single bytes that need no newline and are executed even while a sender is
+// streaming G-code, so the monitor can share a telnet bridge (FluidNC,
+// grblHAL, ser2net) with the sender.
+
+const DefaultBaudRate = 115200
+
+// Real-time commands.
+const (
+	cmdStatusReport = '?'
+	cmdFeedHold     = '!'
+	cmdCycleStart   = '~'
+	cmdSoftReset    = 0x18
+)
+
+// Machine states reported in StatusReport.State.
+const (
+	StateIdle  = "Idle"
+	StateRun   = "Run"
+	StateHold  = "Hold"
+	StateJog   = "Jog"
+	StateAlarm = "Alarm"
+	StateDoor  = "Door"
+	StateCheck = "Check"
+	StateHome  = "Home"
+	StateSleep = "Sleep"
+)
+
+// Alarm codes as of GRBL 1.1.
+var alarmMessages = map[int]string{
+	1:  "hard limit triggered",
+	2:  "soft limit: motion target exceeds machine travel",
+	3:  "reset while in motion",
+	4:  "probe fail: probe not in expected initial state",
+	5:  "probe fail: probe did not contact the workpiece",
+	6:  "homing fail: reset during active homing cycle",
+	7:  "homing fail: safety door opened during homing",
+	8:  "homing fail: pull off failed to clear limit switch",
+	9:  "homing fail: could not find limit switch",
+	10: "homing fail: second dual axis limit switch failed",
+}
+
+// StatusReport is a parsed '?' reply.
+type StatusReport struct {
+	State string `json:"state"`
+	// SubState is the number after the state ("Hold:1", "Door:2"), or -1.
+	SubState int `json:"sub_state"`
+	// Fields holds the remaining fields by name, e.g. "MPos", "FS", "Ln".
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// parseStatusReport parses a line of the form "<...>".
+func parseStatusReport(line string) (*StatusReport, bool) {
+	if !strings.HasPrefix(line, "<") || !strings.HasSuffix(line, ">") {
+		return nil, false
+	}
+	body := line[1 : len(line)-1]
+
+	var state string
+	var fields []string
+
+	if strings.Contains(body, "|") {
+		parts := strings.Split(body, "|")
+		state, fields = parts[0], parts[1:]
+	} else {
+		// 0.9 separates everything with commas; only the state is
+		// unambiguous.
+		state, _, _ = strings.Cut(body, ",")
+	}
+
+	r := &StatusReport{SubState: -1, Fields: make(map[string]string)}
+
+	name, sub, ok := strings.Cut(state, ":")
+	r.State = name
+	if ok {
+		if n, err := strconv.Atoi(sub); err == nil {
+			r.SubState = n
+		}
+	}
+
+	for _, f := range fields {
+		if k, v, ok := strings.Cut(f, ":"); ok {
+			r.Fields[k] = v
+		}
+	}
+
+	return r, true
+}
+
+// parseAlarm parses "ALARM:9" (1.1) or "ALARM: Hard limit" (0.9). code is
+// 0 when the firmware only sent text.
+func parseAlarm(line string) (code int, msg string, ok bool) {
+	rest, ok := strings.CutPrefix(line, "ALARM:")
+	if !ok {
+		return 0, "", false
+	}
+	rest = strings.TrimSpace(rest)
+
+	if n, err := strconv.Atoi(rest); err == nil {
+		return n, alarmMessages[n], true
+	}
+
+	return 0, rest, true
+}
+
+// dial opens the transport named by u: serial:///dev/ttyUSB0 (or a plain
+// device path), or telnet://host:23 / tcp://host:port.
+func dial(ctx context.Context, u *url.URL, baudRate int) (io.ReadWriteCloser, error) {
+	switch u.Scheme {
+	case "", "serial":
+		return serialport.Open(u.Path, baudRate)
+	case "telnet", "tcp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "23")
+		}
+
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported printer url scheme '%s'", u.Scheme)
+	}
+}
@@ -0,0 +1,579 @@
+package grbl
+
+import (
+	"3dp-controller/internal/printer"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+// idleGrace is how long the machine must stay idle before a job counts as
+// finished: senders briefly let the planner run dry between moves.
+const idleGrace = 10 * time.Second
+
+// Options are the connection settings specific to a GRBL machine.
+type Options struct {
+	// BaudRate is used for serial connections and defaults to
+	// DefaultBaudRate.
+	BaudRate int `yaml:"baud_rate"`
+}
+
+func (o *Options) Validate() error {
+	if o.BaudRate < 0 {
+		return fmt.Errorf("invalid baud rate %d", o.BaudRate)
+	}
+
+	return nil
+}
+
+// trackedJob is a job as observed by the monitor. GRBL knows nothing about
+// jobs, so one starts when the machine starts running and ends once it has
+// been idle for idleGrace.
+type trackedJob struct {
+	id            string
+	status        string
+	startTime     time.Time
+	endTime       *time.Time
+	runDuration   time.Duration
+	lastRunUpdate time.Time
+	idleSince     time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	baudRate    int
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	writeMu sync.Mutex
+	conn    io.ReadWriteCloser
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	status         *StatusReport
+	alarmCode      int
+	alarmMessage   string
+	job            *trackedJob
+
+	statusCh chan struct{}
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "grbl"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.alarmMessage
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status == "in_progress" {
+		printDuration := printer.Seconds(m.job.runDuration.Seconds())
+		j.PrintDuration = &printDuration
+
+		totalDuration := printer.Seconds(time.Since(m.job.startTime).Seconds())
+		j.TotalDuration = &totalDuration
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the last status report and alarm.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := json.Marshal(map[string]any{
+		"status":        m.status,
+		"alarm_code":    m.alarmCode,
+		"alarm_message": m.alarmMessage,
+	})
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(b)
+}
+
+// NewMonitor creates a monitor for the machine named by printerURL:
+// serial:///dev/ttyUSB0 (or a plain device path) or telnet://host[:port].
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "", "serial":
+		if u.Path == "" {
+			return nil, fmt.Errorf("printer url '%s' has no device path", printerURL)
+		}
+	case "telnet", "tcp":
+		if u.Hostname() == "" {
+			return nil, fmt.Errorf("printer url '%s' has no host", printerURL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported printer url scheme '%s'", u.Scheme)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	if options.BaudRate == 0 {
+		options.BaudRate = DefaultBaudRate
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.baudRate = options.BaudRate
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.statusCh = make(chan struct{}, 1)
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	go m.connectLoop(ctx)
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+// connectLoop keeps the connection open, reconnecting with backoff.
+func (m *Monitor) connectLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Warnf("Machine connection closed: %s\n", err)
+
+		m.mu.Lock()
+		m.state = printer.Disconnected
+		m.lastError = nil
+		m.status = nil
+		m.lastUpdateTime = time.Now()
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// serve runs one connection until it fails.
+func (m *Monitor) serve(ctx context.Context) error {
+	conn, err := dial(ctx, m.printerUrl, m.baudRate)
+	if err != nil {
+		return err
+	}
+
+	m.writeMu.Lock()
+	m.conn = conn
+	m.writeMu.Unlock()
+
+	// Unblock the scanner when the monitor stops.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	defer func() {
+		close(done)
+
+		m.writeMu.Lock()
+		m.conn = nil
+		m.writeMu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		// Telnet bridges may prefix lines with option negotiation bytes.
+		line := strings.TrimFunc(scanner.Text(), func(r rune) bool {
+			return unicode.IsSpace(r) || !unicode.IsPrint(r)
+		})
+
+		m.handleLine(line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (m *Monitor) handleLine(line string) {
+	if report, ok := parseStatusReport(line); ok {
+		m.handleStatus(report)
+		return
+	}
+
+	if code, msg, ok := parseAlarm(line); ok {
+		m.mu.Lock()
+		m.alarmCode = code
+		m.alarmMessage = msg
+		m.mu.Unlock()
+		return
+	}
+
+	if strings.HasPrefix(line, "Grbl ") {
+		// The controller was reset.
+		m.logger.Infof("Machine reset: %s\n", line)
+	}
+}
+
+func (m *Monitor) handleStatus(report *StatusReport) {
+	now := time.Now()
+
+	m.mu.Lock()
+	m.status = report
+	m.lastUpdateTime = now
+	m.state = mapState(report.State)
+
+	if report.State != StateAlarm {
+		m.alarmCode = 0
+		m.alarmMessage = ""
+	}
+
+	m.lastError = nil
+	if m.state == printer.Error {
+		info := &printer.ErrorInfo{Message: m.alarmMessage}
+		if m.alarmCode != 0 {
+			code := m.alarmCode
+			info.Code = &code
+		}
+		m.lastError = info
+	}
+
+	m.trackJob(report.State, now)
+	m.mu.Unlock()
+
+	select {
+	case m.statusCh <- struct{}{}:
+	default:
+	}
+}
+
+// trackJob starts, times or finishes m.job from the latest machine state.
+// It must be called with m.mu held.
+func (m *Monitor) trackJob(state string, now time.Time) {
+	active := m.job != nil && m.job.status == "in_progress"
+
+	switch state {
+	case StateRun:
+		if !active {
+			m.job = &trackedJob{
+				id:            fmt.Sprintf("job@%d", now.Unix()),
+				status:        "in_progress",
+				startTime:     now,
+				lastRunUpdate: now,
+			}
+			return
+		}
+
+		if !m.job.lastRunUpdate.IsZero() {
+			m.job.runDuration += now.Sub(m.job.lastRunUpdate)
+		}
+		m.job.lastRunUpdate = now
+		m.job.idleSince = time.Time{}
+	case StateHold, StateDoor:
+		if active {
+			m.job.lastRunUpdate = time.Time{}
+			m.job.idleSince = time.Time{}
+		}
+	case StateAlarm:
+		if active {
+			m.finishJob("error", now)
+		}
+	case StateIdle:
+		if !active {
+			return
+		}
+
+		m.job.lastRunUpdate = time.Time{}
+		if m.job.idleSince.IsZero() {
+			m.job.idleSince = now
+		} else if now.Sub(m.job.idleSince) >= idleGrace {
+			m.finishJob("completed", m.job.idleSince)
+		}
+	}
+}
+
+// finishJob must be called with m.mu held.
+func (m *Monitor) finishJob(status string, endTime time.Time) {
+	m.job.status = status
+	m.job.endTime = &endTime
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	if err := m.sendRealtime(cmdStatusReport); err != nil {
+		return
+	}
+
+	// Give the report a moment to arrive before enforcing.
+	select {
+	case <-ctx.Done():
+		return
+	case <-m.statusCh:
+	case <-time.After(time.Second):
+	}
+
+	m.mu.RLock()
+	status := m.status
+	state := m.state
+	activeJobId := ""
+	var runDuration time.Duration
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+		runDuration = m.job.runDuration
+	}
+	m.mu.RUnlock()
+
+	if status == nil {
+		return
+	}
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, printer.Observation{
+		State:         state,
+		PrintDuration: runDuration,
+	})
+}
+
+func (m *Monitor) sendRealtime(b byte) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	if m.conn == nil {
+		return errors.New("not connected")
+	}
+
+	_, err := m.conn.Write([]byte{b})
+	return err
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+// PausePrint issues a feed hold.
+func (m *Monitor) PausePrint(_ context.Context) error {
+	return m.sendRealtime(cmdFeedHold)
+}
+
+// ResumePrint issues a cycle start.
+func (m *Monitor) ResumePrint(_ context.Context) error {
+	return m.sendRealtime(cmdCycleStart)
+}
+
+// CancelPrint holds the feed, waits for the machine to come to a stop and
+// then soft-resets it, which discards the rest of the job without losing
+// position.
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	if err := m.sendRealtime(cmdFeedHold); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		m.mu.RLock()
+		status := m.status
+		m.mu.RUnlock()
+
+		// Hold:0 means the hold is complete.
+		if status != nil && status.State == StateHold && status.SubState == 0 {
+			break
+		}
+
+		if err := m.sendRealtime(cmdStatusReport); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("machine did not come to a stop: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	if err := m.sendRealtime(cmdSoftReset); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.job != nil && m.job.status == "in_progress" {
+		m.finishJob("cancelled", time.Now())
+	}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// SetStatusMessage is a no-op: GRBL has no display.
+func (m *Monitor) SetStatusMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+// mapState maps GRBL's machine state onto printer.PrinterState.
+func mapState(state string) printer.PrinterState {
+	switch state {
+	case StateIdle:
+		return printer.Ready
+	case StateRun:
+		return printer.Printing
+	case StateHold, StateDoor:
+		return printer.Pause
+	case StateAlarm:
+		return printer.Error
+	default:
+		// Jog, Home, Check and Sleep aren't jobs.
+		return printer.Unknown
+	}
+}
@@ -0,0 +1,361 @@
+package grbl
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeGRBL answers real-time commands over TCP the way a GRBL 1.1
+// controller behind a telnet bridge does.
+type fakeGRBL struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	state string
+	conn  net.Conn
+	// holding is set by a feed hold until the next status report, which
+	// still shows the machine decelerating (Hold:1).
+	holding bool
+
+	writeMu sync.Mutex
+
+	// commands receives "hold", "resume" and "reset" for '!', '~' and
+	// Ctrl-X, and "status" for '?'.
+	commands chan string
+}
+
+func newFakeGRBL(t *testing.T) *fakeGRBL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := &fakeGRBL{
+		ln:       ln,
+		state:    StateIdle,
+		commands: make(chan string, 1000),
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+
+		g.mu.Lock()
+		if g.conn != nil {
+			_ = g.conn.Close()
+		}
+		g.mu.Unlock()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go g.serve(conn)
+		}
+	}()
+
+	return g
+}
+
+func (g *fakeGRBL) url() string {
+	return "tcp://" + g.ln.Addr().String()
+}
+
+func (g *fakeGRBL) serve(conn net.Conn) {
+	defer conn.Close()
+
+	g.mu.Lock()
+	g.conn = conn
+	g.mu.Unlock()
+
+	g.writeLine("Grbl 1.1h ['$' for help]")
+
+	reader := bufio.NewReader(conn)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch b {
+		case cmdStatusReport:
+			g.commands <- "status"
+
+			g.mu.Lock()
+			state := g.state
+			if g.holding {
+				state = StateHold + ":1"
+				g.holding = false
+			}
+			g.mu.Unlock()
+
+			g.writeLine("<" + state + "|MPos:10.000,20.000,-1.500|FS:500,12000>")
+		case cmdFeedHold:
+			g.commands <- "hold"
+			g.setState(StateHold + ":0")
+			g.mu.Lock()
+			g.holding = true
+			g.mu.Unlock()
+		case cmdCycleStart:
+			g.commands <- "resume"
+			g.setState(StateRun)
+		case cmdSoftReset:
+			g.commands <- "reset"
+			g.setState(StateIdle)
+			g.writeLine("Grbl 1.1h ['$' for help]")
+		}
+	}
+}
+
+func (g *fakeGRBL) setState(state string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.state = state
+}
+
+// writeLine sends a line unasked, as GRBL does with alarms and some bridges
+// with auto-reported status.
+func (g *fakeGRBL) writeLine(line string) {
+	g.mu.Lock()
+	conn := g.conn
+	g.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+
+	_, _ = conn.Write([]byte(line + "\r\n"))
+}
+
+func startMonitor(t *testing.T, g *fakeGRBL) *Monitor {
+	t.Helper()
+
+	m, err := NewMonitor("cnc", g.url(), Options{}, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	testutil.Eventually(t, "the connection", func() bool {
+		m.writeMu.Lock()
+		defer m.writeMu.Unlock()
+		return m.conn != nil
+	})
+
+	return m
+}
+
+func TestParseStatusReport(t *testing.T) {
+	r, ok := parseStatusReport("<Hold:1|MPos:1.000,2.000,3.000|FS:0,0|Ln:42>")
+	if !ok || r.State != StateHold || r.SubState != 1 {
+		t.Fatalf("parseStatusReport = %+v, %t", r, ok)
+	}
+	if r.Fields["MPos"] != "1.000,2.000,3.000" || r.Fields["FS"] != "0,0" || r.Fields["Ln"] != "42" {
+		t.Errorf("fields = %v", r.Fields)
+	}
+
+	// GRBL 0.9
+	r, ok = parseStatusReport("<Run,MPos:10.000,0.000,0.000,WPos:10.000,0.000,0.000>")
+	if !ok || r.State != StateRun || r.SubState != -1 {
+		t.Errorf("parseStatusReport(0.9) = %+v, %t", r, ok)
+	}
+
+	for _, line := range []string{"ok", "error:9", "[MSG:Reset to continue]", "<Idle"} {
+		if _, ok := parseStatusReport(line); ok {
+			t.Errorf("parsed %q as a status report", line)
+		}
+	}
+}
+
+func TestParseAlarm(t *testing.T) {
+	tests := []struct {
+		line string
+		code int
+		msg  string
+	}{
+		{"ALARM:9", 9, "homing fail: could not find limit switch"},
+		{"ALARM:1", 1, "hard limit triggered"},
+		{"ALARM:42", 42, ""},
+		{"ALARM: Hard limit", 0, "Hard limit"},
+	}
+
+	for _, tt := range tests {
+		code, msg, ok := parseAlarm(tt.line)
+		if !ok || code != tt.code || msg != tt.msg {
+			t.Errorf("parseAlarm(%q) = %d, %q, %t, want %d, %q", tt.line, code, msg, ok, tt.code, tt.msg)
+		}
+	}
+
+	if _, _, ok := parseAlarm("<Alarm|MPos:0.000,0.000,0.000>"); ok {
+		t.Error("parsed a status report as an alarm")
+	}
+}
+
+func TestMapState(t *testing.T) {
+	tests := map[string]printer.PrinterState{
+		StateIdle:  printer.Ready,
+		StateRun:   printer.Printing,
+		StateHold:  printer.Pause,
+		StateDoor:  printer.Pause,
+		StateAlarm: printer.Error,
+		StateJog:   printer.Unknown,
+		StateHome:  printer.Unknown,
+		StateCheck: printer.Unknown,
+		StateSleep: printer.Unknown,
+	}
+
+	for state, want := range tests {
+		if got := mapState(state); got != want {
+			t.Errorf("mapState(%s) = %s, want %s", state, got, want)
+		}
+	}
+}
+
+func TestTrackJob(t *testing.T) {
+	m, err := NewMonitor("cnc", "tcp://127.0.0.1:23", Options{}, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	m.trackJob(StateIdle, at(0))
+	if m.job != nil {
+		t.Fatal("job while idle")
+	}
+
+	m.trackJob(StateRun, at(0))
+	m.trackJob(StateRun, at(5*time.Second))
+	// Time on hold does not count as running.
+	m.trackJob(StateHold, at(6*time.Second))
+	m.trackJob(StateRun, at(8*time.Second))
+	m.trackJob(StateRun, at(9*time.Second))
+	if m.job.runDuration != 6*time.Second {
+		t.Errorf("run duration = %s, want 6s", m.job.runDuration)
+	}
+
+	// Senders let the machine idle briefly between moves.
+	m.trackJob(StateIdle, at(10*time.Second))
+	m.trackJob(StateIdle, at(15*time.Second))
+	if m.job.status != "in_progress" {
+		t.Fatalf("job %s after idling 5s", m.job.status)
+	}
+
+	m.trackJob(StateIdle, at(20*time.Second))
+	if m.job.status != "completed" || !m.job.endTime.Equal(at(10*time.Second)) {
+		t.Errorf("job = %+v, want completed when it went idle", m.job)
+	}
+
+	m.trackJob(StateRun, at(30*time.Second))
+	m.trackJob(StateAlarm, at(31*time.Second))
+	if m.job.status != "error" {
+		t.Errorf("job %s after an alarm", m.job.status)
+	}
+}
+
+func TestMonitorFollowsStatusReports(t *testing.T) {
+	g := newFakeGRBL(t)
+	m := startMonitor(t, g)
+
+	tests := []struct {
+		report string
+		want   printer.PrinterState
+	}{
+		{"<Idle|MPos:0.000,0.000,0.000|FS:0,0>", printer.Ready},
+		{"<Run|MPos:1.000,0.000,0.000|FS:500,12000>", printer.Printing},
+		{"<Hold:0|MPos:1.000,0.000,0.000|FS:0,0>", printer.Pause},
+		{"<Door:1|MPos:1.000,0.000,0.000|FS:0,0>", printer.Pause},
+		{"<Run|MPos:2.000,0.000,0.000|FS:500,12000>", printer.Printing},
+		{"<Jog|MPos:2.000,0.000,0.000|FS:500,0>", printer.Unknown},
+	}
+
+	for _, tt := range tests {
+		g.writeLine(tt.report)
+		testutil.Eventually(t, tt.report, func() bool { return m.State() == tt.want })
+	}
+
+	j := m.Job()
+	if j == nil || j.Status != "in_progress" {
+		t.Fatalf("job = %+v", j)
+	}
+	jobId := j.JobId
+
+	g.writeLine("ALARM:1")
+	g.writeLine("<Alarm|MPos:2.000,0.000,0.000|FS:0,0>")
+	testutil.Eventually(t, "Error", func() bool { return m.State() == printer.Error })
+
+	detail := m.ErrorDetail()
+	if detail == nil || detail.Code == nil || *detail.Code != 1 || detail.Message != "hard limit triggered" {
+		t.Errorf("ErrorDetail() = %+v, want code 1", detail)
+	}
+	if m.Message() != "hard limit triggered" {
+		t.Errorf("Message() = %q", m.Message())
+	}
+	if j := m.Job(); j.JobId != jobId || j.Status != "error" {
+		t.Errorf("job = %+v after the alarm", j)
+	}
+
+	// Unlocking ($X) clears the alarm.
+	g.writeLine("<Idle|MPos:2.000,0.000,0.000|FS:0,0>")
+	testutil.Eventually(t, "Ready", func() bool { return m.State() == printer.Ready })
+	if m.ErrorDetail() != nil || m.Message() != "" {
+		t.Errorf("alarm kept after unlocking: %+v, %q", m.ErrorDetail(), m.Message())
+	}
+}
+
+func TestJobCommands(t *testing.T) {
+	g := newFakeGRBL(t)
+	g.setState(StateRun)
+	m := startMonitor(t, g)
+	ctx := context.Background()
+
+	if err := m.PausePrint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, g.commands, "hold")
+	testutil.Eventually(t, "Pause", func() bool { return m.State() == printer.Pause })
+
+	if err := m.ResumePrint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, g.commands, "resume")
+	testutil.Eventually(t, "Printing", func() bool { return m.State() == printer.Printing })
+}
+
+func TestCancelPrintWaitsForHold(t *testing.T) {
+	g := newFakeGRBL(t)
+	g.setState(StateRun)
+	m := startMonitor(t, g)
+
+	testutil.WaitFor(t, g.commands, "status")
+	testutil.Eventually(t, "the job", func() bool { return m.Job() != nil })
+
+	if err := m.CancelPrint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reset only follows once a report showed the hold complete.
+	testutil.WaitFor(t, g.commands, "hold")
+	testutil.WaitFor(t, g.commands, "status")
+	testutil.WaitFor(t, g.commands, "reset")
+
+	if j := m.Job(); j.Status != "cancelled" || j.EndTime == nil {
+		t.Errorf("job = %+v, want cancelled", j)
+	}
+}
@@ -0,0 +1,15 @@
+package klippy
+
+import (
+	"3dp-controller/internal/printer"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	printer.Register("klippy", printer.Backend{
+		New: func(cfg printer.BackendConfig, logger *zap.SugaredLogger) (printer.Printer, error) {
+			return NewMonitor(cfg.Name, cfg.Url, cfg.Monitor, logger)
+		},
+	})
+}
@@ -0,0 +1,509 @@
+package klippy
+
+import (
+	"3dp-controller/internal/moonraker"
+	"3dp-controller/internal/printer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+// subscribedObjects are the printer objects the monitor subscribes to: the
+// ones moonraker.MapState reads.
+var subscribedObjects = []string{"webhooks", "print_stats", "virtual_sdcard", "display_status"}
+
+// trackedJob is a job as observed by the monitor. Without Moonraker there is
+// no job history, so jobs are identified by file name and the time they
+// started.
+type trackedJob struct {
+	id        string
+	fileName  string
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	socketPath  string
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	clientMu sync.Mutex
+	client   *Client
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	report         map[string]map[string]any
+	objects        *moonraker.MonitorPrinterObjects
+	job            *trackedJob
+
+	reportCh chan struct{}
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "klippy"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.objects == nil {
+		return ""
+	}
+
+	if m.objects.Webhooks.State != "ready" {
+		return m.objects.Webhooks.StateMessage
+	}
+
+	return m.objects.PrintStats.Message
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Name:      m.job.fileName,
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status == "in_progress" && m.objects != nil {
+		progress := m.objects.VirtualSDCard.Progress
+		j.Progress = &progress
+
+		printDurationSec := m.objects.PrintStats.GetPrintDuration().Seconds()
+		printDuration := printer.Seconds(printDurationSec)
+		j.PrintDuration = &printDuration
+
+		totalDuration := printer.Seconds(m.objects.PrintStats.GetTotalDuration().Seconds())
+		j.TotalDuration = &totalDuration
+
+		if progress > 0 {
+			remaining := printer.Seconds(max(printDurationSec/float64(progress)-printDurationSec, 0))
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the subscribed objects as last received.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := json.Marshal(m.report)
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(b)
+}
+
+// NewMonitor creates a monitor for the Klippy API socket named by printerURL,
+// either as a plain path or as unix:///tmp/klippy_uds. An empty path means
+// DefaultSocketPath.
+func NewMonitor(name string, printerURL string, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "" && u.Scheme != "unix" {
+		return nil, fmt.Errorf("unsupported printer url scheme '%s'", u.Scheme)
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.socketPath = u.Path
+	if m.socketPath == "" {
+		m.socketPath = DefaultSocketPath
+	}
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+	m.reportCh = make(chan struct{}, 1)
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	go m.connectLoop(ctx)
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-m.reportCh:
+				m.update(ctx)
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+// connectLoop keeps the socket open, reconnecting with backoff. Klippy
+// closes it whenever it restarts.
+func (m *Monitor) connectLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		connectedAt := time.Now()
+
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Warnf("Klippy connection closed: %s\n", err)
+
+		if time.Since(connectedAt) > 30*time.Second {
+			backoff = time.Second
+		}
+
+		m.mu.Lock()
+		m.state = printer.Disconnected
+		m.lastError = nil
+		m.objects = nil
+		m.lastUpdateTime = time.Now()
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// serve runs one connection until it fails.
+func (m *Monitor) serve(ctx context.Context) error {
+	m.mu.Lock()
+	m.report = make(map[string]map[string]any)
+	m.mu.Unlock()
+
+	client, err := Dial(ctx, m.socketPath, m.handleStatusUpdate)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		m.clientMu.Lock()
+		m.client = nil
+		m.clientMu.Unlock()
+
+		_ = client.Close()
+	}()
+
+	result, err := client.Subscribe(ctx, subscribedObjects...)
+	if err != nil {
+		return err
+	}
+
+	m.clientMu.Lock()
+	m.client = client
+	m.clientMu.Unlock()
+
+	m.mergeStatus(result.Status)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-client.Done():
+		return client.Err()
+	}
+}
+
+func (m *Monitor) handleStatusUpdate(params json.RawMessage) {
+	var update StatusUpdate
+	if err := json.Unmarshal(params, &update); err != nil {
+		m.logger.Warnf("Failed to decode status update: %s\n", err)
+		return
+	}
+
+	m.mergeStatus(update.Status)
+}
+
+// mergeStatus merges changed fields into the report and recomputes the
+// state from it.
+func (m *Monitor) mergeStatus(status map[string]map[string]any) {
+	m.mu.Lock()
+
+	for name, fields := range status {
+		obj, ok := m.report[name]
+		if !ok {
+			obj = make(map[string]any)
+			m.report[name] = obj
+		}
+		for k, v := range fields {
+			obj[k] = v
+		}
+	}
+
+	objects, err := decodeObjects(m.report)
+	if err != nil {
+		m.mu.Unlock()
+		m.logger.Warnf("Failed to decode printer objects: %s\n", err)
+		return
+	}
+
+	m.objects = objects
+	m.lastUpdateTime = time.Now()
+	m.state = moonraker.MapState(objects)
+
+	m.lastError = nil
+	if m.state == printer.Error {
+		msg := objects.Webhooks.StateMessage
+		if objects.Webhooks.State == "ready" {
+			msg = objects.PrintStats.Message
+		}
+		m.lastError = &printer.ErrorInfo{Message: msg}
+	}
+
+	m.trackJob(objects)
+	m.mu.Unlock()
+
+	select {
+	case m.reportCh <- struct{}{}:
+	default:
+	}
+}
+
+// trackJob starts or finishes m.job from print_stats. It must be called with
+// m.mu held.
+func (m *Monitor) trackJob(objects *moonraker.MonitorPrinterObjects) {
+	if objects.Webhooks.State != "ready" {
+		return
+	}
+
+	stats := objects.PrintStats
+
+	switch stats.State {
+	case "printing", "paused":
+		if stats.FileName == "" {
+			return
+		}
+
+		if m.job != nil && m.job.status == "in_progress" && m.job.fileName == stats.FileName {
+			return
+		}
+
+		startTime := time.Now().Add(-stats.GetTotalDuration())
+		m.job = &trackedJob{
+			id:        fmt.Sprintf("%s@%d", stats.FileName, startTime.Unix()),
+			fileName:  stats.FileName,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+	default:
+		if m.job == nil || m.job.status != "in_progress" {
+			return
+		}
+
+		endTime := time.Now()
+		m.job.endTime = &endTime
+
+		switch stats.State {
+		case "complete":
+			m.job.status = "completed"
+		case "cancelled":
+			m.job.status = "cancelled"
+		case "error":
+			m.job.status = "error"
+		default:
+			m.job.status = "interrupted"
+		}
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	m.mu.RLock()
+	objects := m.objects
+	state := m.state
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+	m.mu.RUnlock()
+
+	if objects == nil || objects.Webhooks.State != "ready" {
+		return
+	}
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, objects.Observation(state, nil))
+}
+
+func (m *Monitor) currentClient() (*Client, error) {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+
+	if m.client == nil {
+		return nil, errors.New("not connected")
+	}
+
+	return m.client, nil
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	client, err := m.currentClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Pause(ctx)
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	client, err := m.currentClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Resume(ctx)
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	client, err := m.currentClient()
+	if err != nil {
+		return err
+	}
+
+	return client.Cancel(ctx)
+}
+
+func (m *Monitor) SetStatusMessage(ctx context.Context, msg string) error {
+	client, err := m.currentClient()
+	if err != nil {
+		return err
+	}
+
+	return client.RunGCode(ctx, "M117 "+msg)
+}
+
+func decodeObjects(report map[string]map[string]any) (*moonraker.MonitorPrinterObjects, error) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(moonraker.MonitorPrinterObjects)
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
@@ -0,0 +1,382 @@
+package klippy
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeKlippy serves Klippy's API socket from a status the test sets.
+type fakeKlippy struct {
+	path string
+	ln   net.Listener
+
+	mu     sync.Mutex
+	status map[string]map[string]any
+	conn   net.Conn
+
+	writeMu sync.Mutex
+
+	// calls receives the methods called, with a pause_resume/ or gcode/
+	// command shortened to "pause", "resume", "cancel" or "message:<text>".
+	calls chan string
+	// subscribed receives the objects of every objects/subscribe.
+	subscribed chan []string
+}
+
+func newFakeKlippy(t *testing.T) *fakeKlippy {
+	f := &fakeKlippy{
+		path: filepath.Join(t.TempDir(), "klippy_uds"),
+		status: map[string]map[string]any{
+			"webhooks":    {"state": "ready", "state_message": "Printer is ready"},
+			"print_stats": {"state": "standby", "filename": "", "print_duration": 0.0, "total_duration": 0.0},
+		},
+		calls:      make(chan string, 100),
+		subscribed: make(chan []string, 10),
+	}
+
+	ln, err := net.Listen("unix", f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ln = ln
+	t.Cleanup(func() {
+		_ = ln.Close()
+		f.drop()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeKlippy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	for {
+		b, err := reader.ReadBytes(messageTerminator)
+		if err != nil {
+			return
+		}
+
+		var req struct {
+			Id     int    `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Objects map[string]any `json:"objects"`
+				Script  string         `json:"script"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(bytes.TrimSuffix(b, []byte{messageTerminator}), &req); err != nil {
+			return
+		}
+
+		result := any(map[string]any{})
+
+		switch req.Method {
+		case "objects/subscribe":
+			var objects []string
+			for name := range req.Params.Objects {
+				objects = append(objects, name)
+			}
+			sort.Strings(objects)
+			f.subscribed <- objects
+
+			result = map[string]any{"eventtime": 1.0, "status": f.snapshot()}
+		case "gcode/script":
+			f.calls <- "message:" + strings.TrimPrefix(req.Params.Script, "M117 ")
+		default:
+			f.calls <- strings.TrimPrefix(req.Method, "pause_resume/")
+		}
+
+		f.send(conn, map[string]any{"id": req.Id, "result": result})
+	}
+}
+
+func (f *fakeKlippy) snapshot() map[string]map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make(map[string]map[string]any, len(f.status))
+	for name, fields := range f.status {
+		out[name] = make(map[string]any, len(fields))
+		for k, v := range fields {
+			out[name][k] = v
+		}
+	}
+
+	return out
+}
+
+func (f *fakeKlippy) send(conn net.Conn, msg any) {
+	b, _ := json.Marshal(msg)
+
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	_, _ = conn.Write(append(b, messageTerminator))
+}
+
+// update changes the status and sends the change to the subscriber, the way
+// the response_template of objects/subscribe shapes it.
+func (f *fakeKlippy) update(status map[string]map[string]any) {
+	f.mu.Lock()
+	for name, fields := range status {
+		if f.status[name] == nil {
+			f.status[name] = make(map[string]any)
+		}
+		for k, v := range fields {
+			f.status[name][k] = v
+		}
+	}
+	conn := f.conn
+	f.mu.Unlock()
+
+	if conn != nil {
+		f.send(conn, map[string]any{
+			"method": "status_update",
+			"params": map[string]any{"eventtime": 2.0, "status": status},
+		})
+	}
+}
+
+// drop closes the connection, as Klippy does when it restarts.
+func (f *fakeKlippy) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		_ = f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func waitSubscribe(t *testing.T, f *fakeKlippy) []string {
+	t.Helper()
+
+	select {
+	case objects := <-f.subscribed:
+		return objects
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for objects/subscribe")
+		return nil
+	}
+}
+
+func newTestMonitor(t *testing.T, f *fakeKlippy) *Monitor {
+	t.Helper()
+
+	m, err := NewMonitor("voron", "unix://"+f.path, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestNewMonitorSocketPath(t *testing.T) {
+	tests := map[string]string{
+		"":                        DefaultSocketPath,
+		"/tmp/printer_uds":        "/tmp/printer_uds",
+		"unix:///run/klippy_uds":  "/run/klippy_uds",
+		"unix:/home/pi/klippy.sk": "/home/pi/klippy.sk",
+	}
+
+	for printerURL, want := range tests {
+		m, err := NewMonitor("voron", printerURL, testutil.MonitorConfig(), zap.NewNop().Sugar())
+		if err != nil {
+			t.Fatalf("NewMonitor(%q): %s", printerURL, err)
+		}
+		if m.socketPath != want {
+			t.Errorf("NewMonitor(%q) socket = %q, want %q", printerURL, m.socketPath, want)
+		}
+	}
+
+	if _, err := NewMonitor("voron", "http://voron.local", testutil.MonitorConfig(), zap.NewNop().Sugar()); err == nil {
+		t.Error("accepted an http URL")
+	}
+}
+
+func TestMonitorSubscribesAndMergesStatus(t *testing.T) {
+	f := newFakeKlippy(t)
+	m := newTestMonitor(t, f)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	want := slices.Clone(subscribedObjects)
+	sort.Strings(want)
+	if got := waitSubscribe(t, f); !slices.Equal(got, want) {
+		t.Fatalf("subscribed to %v, want %v", got, want)
+	}
+	testutil.Eventually(t, "Ready", func() bool { return m.State() == printer.Ready })
+
+	f.update(map[string]map[string]any{"print_stats": {"state": "printing", "filename": "benchy.gcode"}})
+	testutil.Eventually(t, "PrePrint", func() bool { return m.State() == printer.PrePrint })
+
+	// Updates only carry the fields that changed.
+	f.update(map[string]map[string]any{
+		"print_stats":    {"print_duration": 30.0, "total_duration": 40.0},
+		"virtual_sdcard": {"progress": 0.25},
+	})
+	testutil.Eventually(t, "Printing", func() bool { return m.State() == printer.Printing })
+
+	j := m.Job()
+	if j == nil || j.Name != "benchy.gcode" || j.Status != "in_progress" {
+		t.Fatalf("job = %+v", j)
+	}
+	// print_stats' durations are float32 seconds.
+	near := func(got *printer.Seconds, want float64) bool {
+		return got != nil && math.Abs(float64(*got)-want) < 1e-3
+	}
+	if j.Progress == nil || *j.Progress != 0.25 {
+		t.Errorf("progress = %v, want 0.25", j.Progress)
+	}
+	if !near(j.PrintDuration, 30) || !near(j.EstimatedRemaining, 90) {
+		t.Errorf("print duration = %v, estimated remaining = %v, want 30 and 90", j.PrintDuration, j.EstimatedRemaining)
+	}
+
+	var report map[string]map[string]any
+	if err := json.Unmarshal(m.RawReport().(json.RawMessage), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report["print_stats"]["filename"] != "benchy.gcode" || report["webhooks"]["state"] != "ready" {
+		t.Errorf("report = %v", report)
+	}
+
+	f.update(map[string]map[string]any{"webhooks": {"state": "shutdown", "state_message": "MCU 'mcu' shutdown"}})
+	testutil.Eventually(t, "Error", func() bool { return m.State() == printer.Error })
+	if detail := m.ErrorDetail(); detail == nil || detail.Message != "MCU 'mcu' shutdown" || m.Message() != detail.Message {
+		t.Errorf("ErrorDetail() = %+v, Message() = %q", detail, m.Message())
+	}
+}
+
+func TestTrackJob(t *testing.T) {
+	m := newTestMonitor(t, newFakeKlippy(t))
+	m.report = make(map[string]map[string]any)
+
+	m.mergeStatus(map[string]map[string]any{
+		"webhooks":    {"state": "ready"},
+		"print_stats": {"state": "standby"},
+	})
+	if m.Job() != nil {
+		t.Fatal("job while standing by")
+	}
+
+	tests := []struct {
+		end    map[string]any
+		status string
+	}{
+		{map[string]any{"state": "complete"}, "completed"},
+		{map[string]any{"state": "cancelled"}, "cancelled"},
+		{map[string]any{"state": "error", "message": "Move out of range"}, "error"},
+		{map[string]any{"state": "standby"}, "interrupted"},
+	}
+
+	for _, tt := range tests {
+		m.mergeStatus(map[string]map[string]any{
+			"print_stats": {"state": "printing", "filename": "benchy.gcode", "total_duration": 60.0},
+		})
+
+		j := m.Job()
+		if j == nil || j.Status != "in_progress" || !strings.HasPrefix(j.JobId, "benchy.gcode@") {
+			t.Fatalf("job = %+v after the print started", j)
+		}
+		if time.Since(*j.StartTime) < time.Minute {
+			t.Errorf("start time %v, want a minute ago", j.StartTime)
+		}
+		jobId := j.JobId
+
+		m.mergeStatus(map[string]map[string]any{"print_stats": {"state": "paused"}})
+		if j := m.Job(); j.JobId != jobId || j.Status != "in_progress" {
+			t.Fatalf("job = %+v after pausing", j)
+		}
+
+		// Klippy going down does not end the job by itself.
+		m.mergeStatus(map[string]map[string]any{"webhooks": {"state": "shutdown"}})
+		m.mergeStatus(map[string]map[string]any{"webhooks": {"state": "ready"}})
+
+		m.mergeStatus(map[string]map[string]any{"print_stats": tt.end})
+		if j := m.Job(); j.JobId != jobId || j.Status != tt.status || j.EndTime == nil {
+			t.Errorf("job = %+v, want %s", j, tt.status)
+		}
+	}
+}
+
+func TestConnectLoopReconnects(t *testing.T) {
+	f := newFakeKlippy(t)
+	m := newTestMonitor(t, f)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	waitSubscribe(t, f)
+	testutil.Eventually(t, "Ready", func() bool { return m.State() == printer.Ready })
+
+	f.update(map[string]map[string]any{"print_stats": {"state": "printing", "filename": "benchy.gcode"}})
+	f.drop()
+	testutil.Eventually(t, "Disconnected", func() bool { return m.State() == printer.Disconnected })
+	if _, err := m.currentClient(); err == nil {
+		t.Error("client kept after the connection closed")
+	}
+
+	// The subscription is renewed from scratch on the new connection.
+	waitSubscribe(t, f)
+	testutil.Eventually(t, "PrePrint", func() bool { return m.State() == printer.PrePrint })
+	if _, err := m.currentClient(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobCommands(t *testing.T) {
+	f := newFakeKlippy(t)
+	m := newTestMonitor(t, f)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	waitSubscribe(t, f)
+	testutil.Eventually(t, "Ready", func() bool { return m.State() == printer.Ready })
+	ctx := context.Background()
+
+	tests := []struct {
+		send func(context.Context) error
+		want string
+	}{
+		{m.PausePrint, "pause"},
+		{m.ResumePrint, "resume"},
+		{m.CancelPrint, "cancel"},
+		{func(ctx context.Context) error { return m.SetStatusMessage(ctx, "hello") }, "message:hello"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.send(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.WaitFor(t, f.calls, tt.want)
+	}
+}
@@ -0,0 +1,251 @@
+package klippy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Klippy's API server (klippy.py -a <path>) speaks JSON over a Unix domain
+// socket. Every message, in both directions, is a JSON object followed by an
+// ETX (0x03) byte. Requests carry an "id" that is echoed in the response;
+// messages without an "id" are subscription updates shaped by the
+// "response_template" given to objects/subscribe.
+
+const DefaultSocketPath = "/tmp/klippy_uds"
+
+const messageTerminator = 0x03
+
+var ErrClosed = errors.New("klippy connection closed")
+
+// APIError is the error member of a failed request.
+type APIError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+type ERRRequestFailed struct {
+	method string
+	apiErr APIError
+}
+
+func (e ERRRequestFailed) Method() string {
+	return e.method
+}
+
+func (e ERRRequestFailed) Error() string {
+	if e.apiErr.Message == "" {
+		return fmt.Sprintf("%s: %s", e.method, e.apiErr.Error)
+	}
+	return fmt.Sprintf("%s: %s", e.method, e.apiErr.Message)
+}
+
+type request struct {
+	Id     int    `json:"id"`
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type response struct {
+	Id     *int            `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *APIError       `json:"error"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Client is a connection to Klippy's API socket.
+type Client struct {
+	conn   net.Conn
+	notify func(params json.RawMessage)
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextId  int
+	pending map[int]chan response
+
+	done chan struct{}
+	err  error
+}
+
+// Dial connects to the socket at path. notify is called from the reader
+// goroutine with the params of every subscription update.
+func Dial(ctx context.Context, path string, notify func(params json.RawMessage)) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		notify:  notify,
+		pending: make(map[int]chan response),
+		done:    make(chan struct{}),
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// Done is closed once the connection is lost.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was lost, once Done is closed.
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		b, err := reader.ReadBytes(messageTerminator)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				err = ErrClosed
+			}
+			c.err = err
+			return
+		}
+
+		var resp response
+		if err := json.Unmarshal(bytes.TrimSuffix(b, []byte{messageTerminator}), &resp); err != nil {
+			continue
+		}
+
+		if resp.Id == nil {
+			if c.notify != nil && resp.Params != nil {
+				c.notify(resp.Params)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[*resp.Id]
+		delete(c.pending, *resp.Id)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// Call sends a request and decodes its result into out, which may be nil.
+func (c *Client) Call(ctx context.Context, method string, params any, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if params == nil {
+		params = map[string]any{}
+	}
+
+	c.mu.Lock()
+	c.nextId++
+	id := c.nextId
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	b, err := json.Marshal(request{Id: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err = c.conn.Write(append(b, messageTerminator))
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	case resp := <-ch:
+		if resp.Error != nil {
+			return ERRRequestFailed{method: method, apiErr: *resp.Error}
+		}
+
+		if out == nil || resp.Result == nil {
+			return nil
+		}
+
+		return json.Unmarshal(resp.Result, out)
+	}
+}
+
+// ------------------
+// Object subscription
+
+// StatusUpdate is both the result of objects/subscribe and the params of the
+// updates that follow it. Updates only carry the fields that changed.
+type StatusUpdate struct {
+	EventTime float64                   `json:"eventtime"`
+	Status    map[string]map[string]any `json:"status"`
+}
+
+// Subscribe subscribes to all fields of the named objects and returns their
+// current status. Later changes are delivered to the Client's notify
+// function.
+func (c *Client) Subscribe(ctx context.Context, objects ...string) (*StatusUpdate, error) {
+	objs := make(map[string]any, len(objects))
+	for _, o := range objects {
+		objs[o] = nil
+	}
+
+	params := map[string]any{
+		"objects":           objs,
+		"response_template": map[string]any{"method": "status_update"},
+	}
+
+	out := new(StatusUpdate)
+	if err := c.Call(ctx, "objects/subscribe", params, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *Client) Pause(ctx context.Context) error {
+	return c.Call(ctx, "pause_resume/pause", nil, nil)
+}
+
+func (c *Client) Resume(ctx context.Context) error {
+	return c.Call(ctx, "pause_resume/resume", nil, nil)
+}
+
+func (c *Client) Cancel(ctx context.Context) error {
+	return c.Call(ctx, "pause_resume/cancel", nil, nil)
+}
+
+// RunGCode runs script and waits for it to finish.
+func (c *Client) RunGCode(ctx context.Context, script string) error {
+	return c.Call(ctx, "gcode/script", map[string]any{"script": script}, nil)
+}
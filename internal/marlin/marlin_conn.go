@@ -0,0 +1,233 @@
+package marlin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marlin answers every command with a line starting with "ok", possibly
+// preceded by response lines. Commands are sent as "N<line> <cmd>*<checksum>"
+// so the firmware can detect corrupted lines and ask for them again with
+// "Resend: <line>". Lines that arrive while no command is outstanding
+// (e.g. "//action:pause" or "Error:Printer halted") are handed to the
+// connection's line handler as well.
+
+// commandTimeout is how long to wait for "ok". Marlin sends "echo:busy"
+// every couple of seconds while it is processing a long command (G28, M109,
+// ...), which restarts the timer.
+const commandTimeout = 30 * time.Second
+
+var ErrClosed = errors.New("serial connection closed")
+
+// FirmwareError is an "Error:" line Marlin sent in reply to a command.
+type FirmwareError struct {
+	Message string
+}
+
+func (e FirmwareError) Error() string {
+	return "firmware error: " + e.Message
+}
+
+type conn struct {
+	port   io.ReadWriteCloser
+	lines  chan string
+	done   chan struct{}
+	err    error
+	onLine func(line string)
+
+	mu         sync.Mutex
+	lineNumber int
+}
+
+// newConn starts reading from port. onLine is called from the reader
+// goroutine for every line received.
+func newConn(port io.ReadWriteCloser, onLine func(line string)) *conn {
+	c := &conn{
+		port:   port,
+		lines:  make(chan string, 256),
+		done:   make(chan struct{}),
+		onLine: onLine,
+	}
+
+	go c.readLoop()
+
+	return c
+}
+
+func (c *conn) readLoop() {
+	defer close(c.done)
+
+	scanner := bufio.NewScanner(c.port)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if c.onLine != nil {
+			c.onLine(line)
+		}
+
+		select {
+		case c.lines <- line:
+		default:
+			// Nobody is waiting for a reply and the buffer is full; drop
+			// the oldest line so the reader never blocks.
+			select {
+			case <-c.lines:
+			default:
+			}
+			c.lines <- line
+		}
+	}
+
+	c.err = scanner.Err()
+	if c.err == nil {
+		c.err = io.EOF
+	}
+}
+
+func (c *conn) Close() error {
+	return c.port.Close()
+}
+
+// waitForStart waits for the firmware to finish booting after the port was
+// opened (which resets most boards), then resets the line numbers.
+func (c *conn) waitForStart(ctx context.Context, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return fmt.Errorf("%w: %s", ErrClosed, c.err)
+		case <-timer.C:
+			// Boards without auto-reset never print "start".
+			break wait
+		case line := <-c.lines:
+			if line == "start" {
+				break wait
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.drain()
+	c.lineNumber = -1
+
+	_, err := c.sendLocked(ctx, "M110 N0")
+	return err
+}
+
+// Command sends cmd and returns the response lines received before "ok",
+// including any text after the "ok" itself (e.g. "ok T:210.0 /210.0").
+func (c *conn) Command(ctx context.Context, cmd string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sendLocked(ctx, cmd)
+}
+
+func (c *conn) sendLocked(ctx context.Context, cmd string) ([]string, error) {
+	c.drain()
+
+	c.lineNumber++
+	n := c.lineNumber
+	if err := c.write(n, cmd); err != nil {
+		return nil, err
+	}
+
+	var resp []string
+	var fwErr *FirmwareError
+
+	timer := time.NewTimer(commandTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.done:
+			return nil, fmt.Errorf("%w: %s", ErrClosed, c.err)
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout waiting for reply to '%s'", cmd)
+		case line := <-c.lines:
+			switch {
+			case strings.HasPrefix(line, "ok"):
+				if rest := strings.TrimSpace(strings.TrimPrefix(line, "ok")); rest != "" {
+					resp = append(resp, rest)
+				}
+
+				if fwErr != nil {
+					return resp, *fwErr
+				}
+				return resp, nil
+			case strings.HasPrefix(line, "Resend:") || strings.HasPrefix(line, "rs "):
+				// Only the line just sent can be outstanding.
+				fwErr = nil
+				if err := c.write(n, cmd); err != nil {
+					return nil, err
+				}
+			case strings.HasPrefix(line, "echo:busy"):
+				timer.Reset(commandTimeout)
+			case strings.HasPrefix(line, "Error:"):
+				msg := strings.TrimPrefix(line, "Error:")
+				// Checksum and line number errors are followed by a resend
+				// request; they don't mean the command failed.
+				if isResendError(msg) {
+					continue
+				}
+				fwErr = &FirmwareError{Message: msg}
+			default:
+				resp = append(resp, line)
+			}
+		}
+	}
+}
+
+func (c *conn) write(n int, cmd string) error {
+	line := fmt.Sprintf("N%d %s", n, cmd)
+	line = line + "*" + strconv.Itoa(checksum(line)) + "\n"
+
+	_, err := io.WriteString(c.port, line)
+	return err
+}
+
+// drain discards lines nobody asked for. They have already been seen by
+// onLine.
+func (c *conn) drain() {
+	for {
+		select {
+		case <-c.lines:
+		default:
+			return
+		}
+	}
+}
+
+func checksum(line string) int {
+	cs := 0
+	for i := 0; i < len(line); i++ {
+		cs ^= int(line[i])
+	}
+	return cs & 0xff
+}
+
+func isResendError(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "checksum") ||
+		strings.Contains(msg, "line number") ||
+		strings.Contains(msg, "no line number")
+}
@@ -0,0 +1,255 @@
+package marlin
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Print files are either on the printer's SD card, printed by the firmware,
+// or in Options.FilesDir, streamed by the monitor. Their paths start with
+// sdRoot or localRoot respectively.
+const (
+	sdRoot    = "sd"
+	localRoot = "local"
+)
+
+var _ printer.FileManager = (*Monitor)(nil)
+
+// Files lists the SD card's files, then the local ones.
+func (m *Monitor) Files(ctx context.Context) ([]printer.File, error) {
+	sdFiles, err := m.sdFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]printer.File, 0, len(sdFiles))
+	for _, f := range sdFiles {
+		files = append(files, printer.File{
+			Path: sdRoot + "/" + f.Path(),
+			Size: f.Size,
+		})
+	}
+
+	if m.filesDir == "" {
+		return files, nil
+	}
+
+	err = filepath.WalkDir(m.filesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(m.filesDir, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, printer.File{
+			Path:     localRoot + "/" + filepath.ToSlash(rel),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing was uploaded yet.
+		err = nil
+	}
+
+	return files, err
+}
+
+// UploadFile stores r in Options.FilesDir; the SD card can't be written to
+// over the serial port in any reasonable time. Paths without a root are
+// taken to be local.
+func (m *Monitor) UploadFile(_ context.Context, p string, r io.Reader) (*printer.File, error) {
+	root, rel := splitRoot(p)
+	if root == sdRoot {
+		return nil, errors.New("uploading to the SD card is not supported")
+	}
+
+	if m.filesDir == "" {
+		return nil, errors.New("no files_dir configured for local files")
+	}
+
+	dst := m.localPath(rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, err
+	}
+
+	// Write next to dst and rename, so a failed upload doesn't leave half
+	// a file to be printed.
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	size, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("Uploaded %s on request\n", rel)
+
+	return &printer.File{
+		Path:     localRoot + "/" + rel,
+		Size:     size,
+		Modified: info.ModTime(),
+	}, nil
+}
+
+func (m *Monitor) DeleteFile(ctx context.Context, p string) error {
+	root, rel := splitRoot(p)
+	switch root {
+	case sdRoot:
+		f, err := m.sdFile(ctx, rel)
+		if err != nil {
+			return err
+		}
+
+		c, err := m.currentConn()
+		if err != nil {
+			return err
+		}
+
+		lines, err := c.Command(ctx, "M30 "+f.ShortPath)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if strings.HasPrefix(line, "Deletion failed") {
+				return errors.New(line)
+			}
+		}
+	case localRoot:
+		if m.filesDir == "" {
+			return printer.ErrFileNotFound
+		}
+
+		if err := os.Remove(m.localPath(rel)); errors.Is(err, fs.ErrNotExist) {
+			return printer.ErrFileNotFound
+		} else if err != nil {
+			return err
+		}
+	default:
+		return printer.ErrFileNotFound
+	}
+
+	m.logger.Infof("Deleted %s on request\n", p)
+	return nil
+}
+
+// StartPrint prints an SD card file with StartSDPrint or streams a local
+// one with StartLocalPrint, and registers the job.
+func (m *Monitor) StartPrint(ctx context.Context, p string) (string, error) {
+	var jobId string
+
+	root, rel := splitRoot(p)
+	switch root {
+	case sdRoot:
+		f, err := m.sdFile(ctx, rel)
+		if err != nil {
+			return "", err
+		}
+
+		if jobId, err = m.StartSDPrint(ctx, f); err != nil {
+			return "", err
+		}
+	case localRoot:
+		if m.filesDir == "" {
+			return "", printer.ErrFileNotFound
+		}
+
+		var err error
+		jobId, err = m.StartLocalPrint(ctx, m.localPath(rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", printer.ErrFileNotFound
+		} else if err != nil {
+			return "", err
+		}
+	default:
+		return "", printer.ErrFileNotFound
+	}
+
+	m.logger.Infof("Started printing %s on request\n", p)
+
+	m.enforcer.SetRegisteredJobId(ctx, jobId)
+	return jobId, nil
+}
+
+func (m *Monitor) sdFiles(ctx context.Context) ([]SDFile, error) {
+	c, err := m.currentConn()
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := c.Command(ctx, "M20 L")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFileList(lines), nil
+}
+
+// sdFile looks up the SD card file at p, a path as listed by Files or its
+// 8.3 path.
+func (m *Monitor) sdFile(ctx context.Context, p string) (SDFile, error) {
+	files, err := m.sdFiles(ctx)
+	if err != nil {
+		return SDFile{}, err
+	}
+
+	for _, f := range files {
+		if f.Path() == p || strings.EqualFold(f.ShortPath, p) {
+			return f, nil
+		}
+	}
+
+	return SDFile{}, printer.ErrFileNotFound
+}
+
+// localPath is where the local file at rel is stored. rel is expected not
+// to climb out of the directory, see printer.FileManager.
+func (m *Monitor) localPath(rel string) string {
+	return filepath.Join(m.filesDir, filepath.FromSlash(rel))
+}
+
+// splitRoot splits p into its root, sdRoot or localRoot, and the path
+// below it. root is "" for any other p.
+func splitRoot(p string) (root string, rel string) {
+	root, rel, ok := strings.Cut(p, "/")
+	if !ok || rel == "" || (root != sdRoot && root != localRoot) {
+		return "", p
+	}
+
+	return root, rel
+}
@@ -0,0 +1,206 @@
+package marlin
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SDStatus is the reply to M27.
+type SDStatus struct {
+	Printing bool
+	Position int64
+	Size     int64
+}
+
+func (s SDStatus) Progress() float32 {
+	if s.Size <= 0 {
+		return 0
+	}
+	return float32(s.Position) / float32(s.Size)
+}
+
+var sdPrintingRe = regexp.MustCompile(`SD printing byte (\d+)/(\d+)`)
+
+// parseSDStatus parses "SD printing byte 1234/56789" or "Not SD printing".
+func parseSDStatus(lines []string) (SDStatus, bool) {
+	for _, line := range lines {
+		if strings.Contains(line, "Not SD printing") {
+			return SDStatus{}, true
+		}
+
+		if m := sdPrintingRe.FindStringSubmatch(line); m != nil {
+			pos, _ := strconv.ParseInt(m[1], 10, 64)
+			size, _ := strconv.ParseInt(m[2], 10, 64)
+			return SDStatus{Printing: true, Position: pos, Size: size}, true
+		}
+	}
+
+	return SDStatus{}, false
+}
+
+// SDFile is an entry of the reply to M20 L.
+type SDFile struct {
+	// ShortPath is the 8.3 path M23 and M30 take.
+	ShortPath string
+	// LongName is the file's long name, "" if the firmware doesn't report
+	// it.
+	LongName string
+	Size     int64
+}
+
+// Path is ShortPath with the file's long name, if known.
+func (f SDFile) Path() string {
+	if f.LongName == "" {
+		return f.ShortPath
+	}
+
+	if dir, _ := path.Split(f.ShortPath); dir != "" {
+		return dir + f.LongName
+	}
+	return f.LongName
+}
+
+// parseFileList parses the reply to M20 L, the lines between "Begin file
+// list" and "End file list", each "BENCHY~1.GCO 1234 Benchy.gcode". Older
+// firmware leaves out the size or the long name.
+func parseFileList(lines []string) []SDFile {
+	var files []SDFile
+
+	inList := false
+	for _, line := range lines {
+		switch {
+		case line == "Begin file list":
+			inList = true
+			continue
+		case line == "End file list":
+			inList = false
+			continue
+		case !inList:
+			continue
+		}
+
+		short, rest, _ := strings.Cut(line, " ")
+		f := SDFile{ShortPath: short}
+
+		sizeStr, long, _ := strings.Cut(rest, " ")
+		if size, err := strconv.ParseInt(sizeStr, 10, 64); err == nil {
+			f.Size = size
+			f.LongName = strings.TrimSpace(long)
+		} else {
+			f.LongName = strings.TrimSpace(rest)
+		}
+
+		files = append(files, f)
+	}
+
+	return files
+}
+
+// parseCurrentFile parses the reply to M27 C, "Current file: NAME~1.GCO
+// Long Name.gcode" or "Current file: (no file)", and returns the long name
+// if the firmware reports one.
+func parseCurrentFile(lines []string) string {
+	for _, line := range lines {
+		rest, ok := strings.CutPrefix(line, "Current file:")
+		if !ok {
+			continue
+		}
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" || rest == "(no file)" {
+			return ""
+		}
+
+		short, long, _ := strings.Cut(rest, " ")
+		if long = strings.TrimSpace(long); long != "" {
+			return long
+		}
+		return short
+	}
+
+	return ""
+}
+
+var durationPartRe = regexp.MustCompile(`(\d+)\s*([a-z]+)`)
+
+// parsePrintTime parses the reply to M31, "echo:Print time: 1h 2m 3s" on
+// Marlin 2 or "echo:62 min, 3 sec" on Marlin 1.
+func parsePrintTime(lines []string) (time.Duration, bool) {
+	for _, line := range lines {
+		text, ok := strings.CutPrefix(line, "echo:")
+		if !ok {
+			continue
+		}
+		text = strings.TrimPrefix(strings.TrimSpace(text), "Print time:")
+
+		parts := durationPartRe.FindAllStringSubmatch(strings.ToLower(text), -1)
+		if parts == nil {
+			continue
+		}
+
+		var d time.Duration
+		for _, p := range parts {
+			n, _ := strconv.Atoi(p[1])
+			switch p[2][0] {
+			case 'd':
+				d += time.Duration(n) * 24 * time.Hour
+			case 'h':
+				d += time.Duration(n) * time.Hour
+			case 'm':
+				d += time.Duration(n) * time.Minute
+			case 's':
+				d += time.Duration(n) * time.Second
+			}
+		}
+
+		return d, true
+	}
+
+	return 0, false
+}
+
+// Temperature is one heater reading from M105.
+type Temperature struct {
+	Actual float64  `json:"actual"`
+	Target *float64 `json:"target,omitempty"`
+}
+
+var temperatureRe = regexp.MustCompile(`\b([TB]\d*|C|P|A):\s*(-?[\d.]+)(?:\s*/\s*(-?[\d.]+))?`)
+
+// parseTemperatures parses the reply to M105, "T:210.0 /210.0 B:60.0 /60.0
+// @:64 B@:127".
+func parseTemperatures(lines []string) map[string]Temperature {
+	out := make(map[string]Temperature)
+
+	for _, line := range lines {
+		for _, m := range temperatureRe.FindAllStringSubmatch(line, -1) {
+			actual, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				continue
+			}
+
+			t := Temperature{Actual: actual}
+			if m[3] != "" {
+				if target, err := strconv.ParseFloat(m[3], 64); err == nil {
+					t.Target = &target
+				}
+			}
+
+			out[m[1]] = t
+		}
+	}
+
+	return out
+}
+
+// stripGCodeLine removes comments and surrounding whitespace from a line of
+// a G-code file.
+func stripGCodeLine(line string) string {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
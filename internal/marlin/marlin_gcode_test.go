@@ -0,0 +1,157 @@
+package marlin
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSDStatus(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  SDStatus
+		ok    bool
+	}{
+		{[]string{"SD printing byte 1234/56789"}, SDStatus{Printing: true, Position: 1234, Size: 56789}, true},
+		{[]string{"Not SD printing"}, SDStatus{}, true},
+		{[]string{"echo:busy: processing"}, SDStatus{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSDStatus(tt.lines)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSDStatus(%q) = %+v, %t, want %+v, %t", tt.lines, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseCurrentFile(t *testing.T) {
+	tests := map[string]string{
+		"Current file: BENCHY~1.GCO Benchy.gcode": "Benchy.gcode",
+		"Current file: CUBE.GCO":                  "CUBE.GCO",
+		"Current file: (no file)":                 "",
+	}
+
+	for line, want := range tests {
+		if got := parseCurrentFile([]string{line}); got != want {
+			t.Errorf("parseCurrentFile(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestParsePrintTime(t *testing.T) {
+	tests := map[string]time.Duration{
+		"echo:Print time: 1h 2m 3s":  time.Hour + 2*time.Minute + 3*time.Second,
+		"echo:Print time: 1d 0h 5m":  24*time.Hour + 5*time.Minute,
+		"echo:62 min, 3 sec":         62*time.Minute + 3*time.Second,
+		"echo:Print time: 0s":        0,
+		"echo:Print time: 45s":       45 * time.Second,
+		"echo:Print time: 1h 30m 0s": 90 * time.Minute,
+	}
+
+	for line, want := range tests {
+		got, ok := parsePrintTime([]string{line})
+		if !ok || got != want {
+			t.Errorf("parsePrintTime(%q) = %s, %t, want %s", line, got, ok, want)
+		}
+	}
+
+	if _, ok := parsePrintTime([]string{"T:210.0 /210.0"}); ok {
+		t.Error("parsed a print time out of an M105 reply")
+	}
+}
+
+func TestParseTemperatures(t *testing.T) {
+	got := parseTemperatures([]string{"T:210.5 /215.0 B:60.0 /60.0 T0:210.5 /215.0 T1:25.0 /0.0 @:64 B@:127"})
+
+	check := func(name string, actual float64, target float64) {
+		t.Helper()
+
+		temp, ok := got[name]
+		if !ok {
+			t.Fatalf("no %s in %v", name, got)
+		}
+		if temp.Actual != actual || temp.Target == nil || *temp.Target != target {
+			t.Errorf("%s = %+v, want %v/%v", name, temp, actual, target)
+		}
+	}
+
+	check("T", 210.5, 215)
+	check("B", 60, 60)
+	check("T0", 210.5, 215)
+	check("T1", 25, 0)
+
+	if _, ok := got["@"]; ok {
+		t.Error("heater power parsed as a temperature")
+	}
+}
+
+func TestParseFileList(t *testing.T) {
+	got := parseFileList([]string{
+		"echo:SD card ok",
+		"Begin file list",
+		"BENCHY~1.GCO 1234 Benchy.gcode",
+		"MODELS/BRACKE~1.GCO 99 Bracket v2.gcode",
+		"CUBE.GCO 200",
+		"OLD.GCO",
+		"End file list",
+	})
+
+	want := []SDFile{
+		{ShortPath: "BENCHY~1.GCO", LongName: "Benchy.gcode", Size: 1234},
+		{ShortPath: "MODELS/BRACKE~1.GCO", LongName: "Bracket v2.gcode", Size: 99},
+		{ShortPath: "CUBE.GCO", Size: 200},
+		{ShortPath: "OLD.GCO"},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("parseFileList = %+v, want %+v", got, want)
+	}
+
+	paths := []string{"Benchy.gcode", "MODELS/Bracket v2.gcode", "CUBE.GCO", "OLD.GCO"}
+	for i, f := range got {
+		if f.Path() != paths[i] {
+			t.Errorf("Path() = %q, want %q", f.Path(), paths[i])
+		}
+	}
+}
+
+func TestStripGCodeLine(t *testing.T) {
+	tests := map[string]string{
+		"G1 X10 Y20 ; move\n": "G1 X10 Y20",
+		"; comment only\n":    "",
+		"  M104 S210  \r\n":   "M104 S210",
+		"\n":                  "",
+	}
+
+	for line, want := range tests {
+		if got := stripGCodeLine(line); got != want {
+			t.Errorf("stripGCodeLine(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// The XOR of all bytes: 'N'^'1'^' '^'M'^'1'^'0'^'5'.
+	if got := checksum("N1 M105"); got != 38 {
+		t.Errorf("checksum = %d, want 38", got)
+	}
+}
+
+func TestSplitRoot(t *testing.T) {
+	tests := []struct {
+		path, root, rel string
+	}{
+		{"sd/Benchy.gcode", sdRoot, "Benchy.gcode"},
+		{"local/parts/bracket.gcode", localRoot, "parts/bracket.gcode"},
+		{"bracket.gcode", "", "bracket.gcode"},
+		{"other/bracket.gcode", "", "other/bracket.gcode"},
+		{"sd/", "", "sd/"},
+	}
+
+	for _, tt := range tests {
+		root, rel := splitRoot(tt.path)
+		if root != tt.root || rel != tt.rel {
+			t.Errorf("splitRoot(%q) = %q, %q, want %q, %q", tt.path, root, rel, tt.root, tt.rel)
+		}
+	}
+}
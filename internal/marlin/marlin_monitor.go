@@ -0,0 +1,966 @@
+package marlin
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/serialport"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.RawReporter = (*Monitor)(nil)
+
+const DefaultBaudRate = 115200
+
+// Options are the connection settings specific to a serial Marlin printer.
+type Options struct {
+	// BaudRate defaults to DefaultBaudRate.
+	BaudRate int `yaml:"baud_rate"`
+	// FilesDir is the directory on this host that uploaded files are stored
+	// in and streamed from. Without it only the SD card's files are listed.
+	FilesDir string `yaml:"files_dir"`
+}
+
+func (o *Options) Validate() error {
+	if o.BaudRate < 0 {
+		return fmt.Errorf("invalid baud rate %d", o.BaudRate)
+	}
+
+	return nil
+}
+
+// trackedJob is a job as observed by the monitor. Marlin has no job IDs, so
+// jobs are identified by file name and the time they started.
+type trackedJob struct {
+	id        string
+	fileName  string
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+	// local is set for files streamed by the monitor rather than printed
+	// from the SD card.
+	local bool
+	// sdName is the 8.3 path a job started by StartSDPrint was selected
+	// by, which M27 C may report instead of fileName.
+	sdName string
+}
+
+// isFile tells whether fileName, as M27 C reports it, names the job's file.
+func (j *trackedJob) isFile(fileName string) bool {
+	return fileName == "" || fileName == j.fileName ||
+		(j.sdName != "" && (strings.EqualFold(fileName, j.sdName) || strings.EqualFold(fileName, path.Base(j.sdName))))
+}
+
+// hostStream is a local file being fed to the printer line by line.
+type hostStream struct {
+	size int64
+	sent atomic.Int64
+
+	mu          sync.Mutex
+	resumeCh    chan struct{} // non-nil while paused
+	pausedAt    time.Time
+	pausedTotal time.Duration
+	startTime   time.Time
+	cancel      context.CancelFunc
+}
+
+func (s *hostStream) pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resumeCh == nil {
+		s.resumeCh = make(chan struct{})
+		s.pausedAt = time.Now()
+	}
+}
+
+func (s *hostStream) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.resumeCh != nil {
+		close(s.resumeCh)
+		s.resumeCh = nil
+		s.pausedTotal += time.Since(s.pausedAt)
+	}
+}
+
+func (s *hostStream) paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resumeCh != nil
+}
+
+// printDuration is the time spent streaming, excluding pauses.
+func (s *hostStream) printDuration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := time.Since(s.startTime) - s.pausedTotal
+	if s.resumeCh != nil {
+		d -= time.Since(s.pausedAt)
+	}
+	return d
+}
+
+func (s *hostStream) progress() float32 {
+	if s.size <= 0 {
+		return 0
+	}
+	return float32(s.sent.Load()) / float32(s.size)
+}
+
+// waitResumed blocks while the stream is paused.
+func (s *hostStream) waitResumed(ctx context.Context) error {
+	s.mu.Lock()
+	ch := s.resumeCh
+	s.mu.Unlock()
+
+	if ch == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	devicePath  string
+	baudRate    int
+	filesDir    string
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+
+	connMu sync.Mutex
+	conn   *conn
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	temperatures   map[string]Temperature
+	sd             *SDStatus
+	sdPaused       bool
+	printTime      time.Duration
+	lastErrorLine  string
+	halted         string
+	displayMessage string
+	stream         *hostStream
+	job            *trackedJob
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "marlin"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.displayMessage
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     m.job.id,
+		Name:      m.job.fileName,
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if m.job.status != "in_progress" {
+		return j
+	}
+
+	var progress float32
+	var printDuration time.Duration
+
+	switch {
+	case m.job.local && m.stream != nil:
+		progress = m.stream.progress()
+		printDuration = m.stream.printDuration()
+	case !m.job.local && m.sd != nil:
+		progress = m.sd.Progress()
+		printDuration = m.printTime
+	default:
+		return j
+	}
+
+	j.Progress = &progress
+
+	printSeconds := printer.Seconds(printDuration.Seconds())
+	j.PrintDuration = &printSeconds
+
+	totalDuration := printer.Seconds(time.Since(m.job.startTime).Seconds())
+	j.TotalDuration = &totalDuration
+
+	if progress > 0 && printDuration > 0 {
+		remaining := printer.Seconds(printDuration.Seconds() / float64(progress) * float64(1-progress))
+		j.EstimatedRemaining = &remaining
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+// RawReport returns the last temperatures and SD card status read from the
+// printer.
+func (m *Monitor) RawReport() any {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	b, err := json.Marshal(map[string]any{
+		"temperatures": m.temperatures,
+		"sd":           m.sd,
+		"sd_paused":    m.sdPaused,
+		"print_time":   m.printTime.Seconds(),
+		"halted":       m.halted,
+	})
+	if err != nil {
+		return nil
+	}
+
+	return json.RawMessage(b)
+}
+
+// NewMonitor creates a monitor for the printer on the serial device named by
+// printerURL, either as a plain path or as serial:///dev/ttyUSB0.
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "" && u.Scheme != "serial" {
+		return nil, fmt.Errorf("unsupported printer url scheme '%s'", u.Scheme)
+	}
+
+	if u.Path == "" {
+		return nil, fmt.Errorf("printer url '%s' has no device path", printerURL)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	if options.BaudRate == 0 {
+		options.BaudRate = DefaultBaudRate
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.devicePath = u.Path
+	m.baudRate = options.BaudRate
+	m.filesDir = options.FilesDir
+	m.logger = logger
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		m.update(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				m.closeConn(nil)
+				return
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+// connect returns the open connection, opening the port if needed.
+func (m *Monitor) connect(ctx context.Context) (*conn, error) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.conn != nil {
+		return m.conn, nil
+	}
+
+	port, err := serialport.Open(m.devicePath, m.baudRate)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(port, m.handleLine)
+	if err := c.waitForStart(ctx, 5*time.Second); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.halted = ""
+	m.lastErrorLine = ""
+	m.sdPaused = false
+	m.mu.Unlock()
+
+	m.conn = c
+	return c, nil
+}
+
+// currentConn returns the open connection without trying to open one.
+func (m *Monitor) currentConn() (*conn, error) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.conn == nil {
+		return nil, errors.New("printer not connected")
+	}
+
+	return m.conn, nil
+}
+
+// closeConn closes c, or the current connection if c is nil.
+func (m *Monitor) closeConn(c *conn) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+
+	if m.conn == nil || (c != nil && m.conn != c) {
+		return
+	}
+
+	_ = m.conn.Close()
+	m.conn = nil
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	c, err := m.connect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Debugf("Failed to open serial port: %s\n", err)
+
+		m.mu.Lock()
+		m.state = printer.Disconnected
+		m.lastError = nil
+		m.lastUpdateTime = time.Now()
+		m.mu.Unlock()
+		return
+	}
+
+	lines, err := c.Command(ctx, "M105")
+	if err != nil {
+		m.handleCommandError(c, "M105", err)
+		return
+	}
+	temperatures := parseTemperatures(lines)
+
+	m.mu.RLock()
+	stream := m.stream
+	m.mu.RUnlock()
+
+	var sd *SDStatus
+	var printTime time.Duration
+	var fileName string
+	polledAt := time.Now()
+
+	if stream == nil {
+		lines, err = c.Command(ctx, "M27")
+		if err != nil {
+			m.handleCommandError(c, "M27", err)
+			return
+		}
+
+		if status, ok := parseSDStatus(lines); ok && status.Printing {
+			sd = &status
+
+			if lines, err := c.Command(ctx, "M31"); err == nil {
+				printTime, _ = parsePrintTime(lines)
+			}
+
+			if lines, err := c.Command(ctx, "M27 C"); err == nil {
+				fileName = parseCurrentFile(lines)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.lastUpdateTime = time.Now()
+	m.temperatures = temperatures
+
+	if stream == nil {
+		m.trackSDJob(sd, fileName, printTime, polledAt)
+		m.sd = sd
+		m.printTime = printTime
+		if sd == nil {
+			m.sdPaused = false
+		}
+	}
+
+	var printDuration time.Duration
+	var progress float32
+
+	switch {
+	case m.halted != "":
+		m.state = printer.Error
+		m.lastError = &printer.ErrorInfo{Message: m.halted}
+	case stream != nil:
+		printDuration = stream.printDuration()
+		progress = stream.progress()
+		m.state = mapState(true, stream.paused(), stream.sent.Load() > 0)
+		m.lastError = nil
+	default:
+		if sd != nil {
+			printDuration = printTime
+			progress = sd.Progress()
+		}
+		m.state = mapState(sd != nil, m.sdPaused, printTime > 0)
+		m.lastError = nil
+	}
+
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+
+	obs := printer.Observation{
+		State:          m.state,
+		PrintDuration:  printDuration,
+		Progress:       progress,
+		DisplayMessage: m.displayMessage,
+	}
+	m.mu.Unlock()
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, obs)
+}
+
+func (m *Monitor) handleCommandError(c *conn, cmd string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastUpdateTime = time.Now()
+
+	switch {
+	case errors.Is(err, ErrClosed):
+		m.logger.Warnf("Serial connection lost: %s\n", err)
+		m.state = printer.Disconnected
+		m.lastError = nil
+
+		go m.closeConn(c)
+	case m.halted != "":
+		// A halted firmware stops answering until it's reset.
+		m.state = printer.Error
+		m.lastError = &printer.ErrorInfo{Message: m.halted}
+	default:
+		m.logger.Errorf("Error sending %s: %s\n", cmd, err)
+		m.state = printer.InternalError
+		m.lastError = &printer.ErrorInfo{Message: err.Error()}
+	}
+}
+
+// trackSDJob starts or finishes m.job from the SD card status polled at
+// polledAt. It must be called with m.mu held, before m.sd is replaced.
+func (m *Monitor) trackSDJob(sd *SDStatus, fileName string, printTime time.Duration, polledAt time.Time) {
+	if sd != nil {
+		if m.job != nil && m.job.status == "in_progress" && !m.job.local && m.job.isFile(fileName) {
+			return
+		}
+
+		startTime := time.Now().Add(-printTime)
+		m.job = &trackedJob{
+			id:        fmt.Sprintf("%s@%d", fileName, startTime.Unix()),
+			fileName:  fileName,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+		return
+	}
+
+	// A job StartSDPrint started after the poll isn't over.
+	if m.job != nil && m.job.status == "in_progress" && !m.job.local && m.job.startTime.Before(polledAt) {
+		endTime := time.Now()
+		m.job.endTime = &endTime
+
+		if m.sd != nil && m.sd.Size > 0 && m.sd.Position >= m.sd.Size {
+			m.job.status = "completed"
+		} else {
+			m.job.status = "cancelled"
+		}
+	}
+}
+
+// handleLine is called by the connection for every line it reads.
+func (m *Monitor) handleLine(line string) {
+	switch {
+	case strings.HasPrefix(line, "Error:"):
+		msg := strings.TrimPrefix(line, "Error:")
+		lower := strings.ToLower(msg)
+
+		m.mu.Lock()
+		if strings.Contains(lower, "printer halted") || strings.Contains(lower, "printer stopped") {
+			// The line before the halt usually says why (e.g. thermal
+			// runaway).
+			if m.lastErrorLine != "" {
+				msg = m.lastErrorLine + "; " + msg
+			}
+			m.halted = msg
+			m.state = printer.Error
+			m.lastError = &printer.ErrorInfo{Message: msg}
+		} else if !isResendError(msg) {
+			m.lastErrorLine = msg
+		}
+		m.mu.Unlock()
+	case line == "Done printing file":
+		m.mu.Lock()
+		if m.job != nil && m.job.status == "in_progress" && !m.job.local {
+			endTime := time.Now()
+			m.job.endTime = &endTime
+			m.job.status = "completed"
+		}
+		m.mu.Unlock()
+	case strings.HasPrefix(line, "//action:"):
+		m.handleHostAction(strings.TrimSpace(strings.TrimPrefix(line, "//action:")))
+	}
+}
+
+// handleHostAction reacts to "//action:" lines, which the firmware sends
+// when a print is paused, resumed or cancelled from the printer's own
+// display.
+func (m *Monitor) handleHostAction(action string) {
+	action, _, _ = strings.Cut(action, " ")
+
+	m.mu.Lock()
+	stream := m.stream
+	switch action {
+	case "pause", "paused":
+		m.sdPaused = m.sd != nil
+	case "resume", "resumed":
+		m.sdPaused = false
+	}
+	m.mu.Unlock()
+
+	if stream == nil {
+		return
+	}
+
+	switch action {
+	case "pause", "paused":
+		stream.pause()
+	case "resume", "resumed":
+		stream.resume()
+	case "cancel":
+		// Not from the reader goroutine: cancelling sends commands.
+		go func() {
+			if err := m.cancelStream(context.Background(), stream); err != nil {
+				m.logger.Errorf("Failed to cancel streamed print: %s\n", err)
+			}
+		}()
+	}
+}
+
+// StartSDPrint selects and starts file on the printer's SD card, and
+// returns the ID of the resulting job.
+func (m *Monitor) StartSDPrint(ctx context.Context, file SDFile) (string, error) {
+	if m.State() != printer.Ready {
+		return "", errors.New("printer is not ready")
+	}
+
+	c, err := m.currentConn()
+	if err != nil {
+		return "", err
+	}
+
+	lines, err := c.Command(ctx, "M23 "+file.ShortPath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "open failed") {
+			return "", fmt.Errorf("failed to open '%s' on the SD card", file.ShortPath)
+		}
+	}
+
+	if _, err := c.Command(ctx, "M24"); err != nil {
+		return "", err
+	}
+
+	// Track the job now rather than at the next M27, so that it can be
+	// registered before the Enforcer sees it.
+	startTime := time.Now()
+
+	fileName := file.LongName
+	if fileName == "" {
+		fileName = path.Base(file.ShortPath)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.job = &trackedJob{
+		id:        fmt.Sprintf("%s@%d", fileName, startTime.Unix()),
+		fileName:  fileName,
+		status:    "in_progress",
+		startTime: startTime,
+		sdName:    file.ShortPath,
+	}
+
+	return m.job.id, nil
+}
+
+// StartLocalPrint streams the G-code file at filePath to the printer and
+// returns the ID of the resulting job.
+func (m *Monitor) StartLocalPrint(ctx context.Context, filePath string) (string, error) {
+	c, err := m.currentConn()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	m.mu.Lock()
+	if m.state != printer.Ready || m.stream != nil {
+		m.mu.Unlock()
+		_ = f.Close()
+		return "", errors.New("printer is not ready")
+	}
+
+	streamCtx, cancel := context.WithCancel(m.ctx)
+	s := &hostStream{
+		size:      fi.Size(),
+		startTime: time.Now(),
+		cancel:    cancel,
+	}
+
+	fileName := filepath.Base(filePath)
+	m.job = &trackedJob{
+		id:        fmt.Sprintf("%s@%d", fileName, s.startTime.Unix()),
+		fileName:  fileName,
+		status:    "in_progress",
+		startTime: s.startTime,
+		local:     true,
+	}
+	jobId := m.job.id
+	m.stream = s
+	m.state = printer.PrePrint
+	m.mu.Unlock()
+
+	go m.runStream(streamCtx, c, s, f)
+
+	return jobId, nil
+}
+
+func (m *Monitor) runStream(ctx context.Context, c *conn, s *hostStream, f *os.File) {
+	defer func() { _ = f.Close() }()
+
+	err := streamFile(ctx, c, s, f)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stream = nil
+
+	if m.job == nil || !m.job.local || m.job.status != "in_progress" {
+		return
+	}
+
+	endTime := time.Now()
+	m.job.endTime = &endTime
+
+	switch {
+	case err == nil:
+		m.job.status = "completed"
+	case errors.Is(err, context.Canceled):
+		m.job.status = "cancelled"
+	default:
+		m.logger.Errorf("Streaming %s failed: %s\n", m.job.fileName, err)
+		m.job.status = "error"
+	}
+}
+
+// streamFile sends the file line by line, waiting for each "ok".
+func streamFile(ctx context.Context, c *conn, s *hostStream, r io.Reader) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+
+		if cmd := stripGCodeLine(line); cmd != "" {
+			if err := s.waitResumed(ctx); err != nil {
+				return err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// A command already on the wire is always waited for, so a
+			// late "ok" isn't mistaken for the reply to the next one.
+			if _, err := c.Command(context.WithoutCancel(ctx), cmd); err != nil {
+				var fwErr FirmwareError
+				if !errors.As(err, &fwErr) {
+					return err
+				}
+			}
+		}
+
+		s.sent.Add(int64(len(line)))
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// cancelStream stops feeding the file and turns the heaters and part fan
+// off.
+func (m *Monitor) cancelStream(ctx context.Context, s *hostStream) error {
+	s.cancel()
+	s.resume()
+
+	c, err := m.currentConn()
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range []string{"M104 S0", "M140 S0", "M107"} {
+		if _, err := c.Command(ctx, cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	m.mu.RLock()
+	stream := m.stream
+	m.mu.RUnlock()
+
+	if stream != nil {
+		stream.pause()
+		return nil
+	}
+
+	c, err := m.currentConn()
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.Command(ctx, "M25"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.sdPaused = true
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	m.mu.RLock()
+	stream := m.stream
+	m.mu.RUnlock()
+
+	if stream != nil {
+		stream.resume()
+		return nil
+	}
+
+	c, err := m.currentConn()
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.Command(ctx, "M24"); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.sdPaused = false
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	m.mu.RLock()
+	stream := m.stream
+	m.mu.RUnlock()
+
+	if stream != nil {
+		return m.cancelStream(ctx, stream)
+	}
+
+	c, err := m.currentConn()
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Command(ctx, "M524")
+	return err
+}
+
+func (m *Monitor) SetStatusMessage(ctx context.Context, msg string) error {
+	c, err := m.currentConn()
+	if err != nil {
+		return err
+	}
+
+	// '*' and ';' would be read as a checksum or a comment.
+	text := strings.NewReplacer("*", "", ";", "").Replace(msg)
+	if _, err := c.Command(ctx, strings.TrimSpace("M117 "+text)); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.displayMessage = msg
+	m.mu.Unlock()
+
+	return nil
+}
+
+// mapState maps what the monitor knows about the current print onto
+// printer.PrinterState.
+func mapState(printing bool, paused bool, started bool) printer.PrinterState {
+	switch {
+	case !printing:
+		return printer.Ready
+	case paused:
+		return printer.Pause
+	case started:
+		return printer.Printing
+	default:
+		return printer.PrePrint
+	}
+}
@@ -0,0 +1,553 @@
+//go:build linux
+
+package marlin
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+type fakeSDFile struct {
+	short string
+	long  string
+	size  int64
+}
+
+// fakeMarlin answers the monitor like Marlin 2 would, over a pty the
+// monitor opens as its serial port.
+type fakeMarlin struct {
+	t         *testing.T
+	master    *os.File
+	slavePath string
+
+	commands chan string
+
+	mu         sync.Mutex
+	started    bool
+	lastLine   int
+	files      []fakeSDFile
+	selected   *fakeSDFile
+	sdPrinting bool
+	sdPos      int64
+	printTime  string
+	// hold, when set, delays the "ok" to every G1 until it receives.
+	hold chan struct{}
+}
+
+func newFakeMarlin(t *testing.T) *fakeMarlin {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pty: %s", err)
+	}
+
+	// Not master.Fd(), which would take the file out of the poller, so
+	// that Close could no longer interrupt a Read.
+	raw, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	var ioctlErr error
+	err = raw.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+			return
+		}
+		n, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	})
+	if err != nil || ioctlErr != nil {
+		t.Fatalf("pty setup: %v %v", err, ioctlErr)
+	}
+
+	f := &fakeMarlin{
+		t:         t,
+		master:    master,
+		slavePath: fmt.Sprintf("/dev/pts/%d", n),
+		commands:  make(chan string, 1000),
+		printTime: "0s",
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	go f.boot()
+	go f.serve()
+
+	return f
+}
+
+// boot says "start" until the host talks, as the port may not be open yet.
+func (f *fakeMarlin) boot() {
+	for {
+		f.mu.Lock()
+		started := f.started
+		f.mu.Unlock()
+
+		if started {
+			return
+		}
+
+		if _, err := f.master.WriteString("start\n"); err != nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (f *fakeMarlin) serve() {
+	reader := bufio.NewReader(f.master)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// EIO until the monitor opens the port, or after it closed it.
+			if strings.Contains(err.Error(), "file already closed") {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "N") {
+			// Our own "start", echoed before the port was made raw.
+			continue
+		}
+
+		f.mu.Lock()
+		f.started = true
+		f.mu.Unlock()
+
+		cmd, ok := f.parse(line)
+		if !ok {
+			continue
+		}
+
+		f.commands <- cmd
+		reply := f.handle(cmd)
+		if len(reply) == 0 || !strings.HasPrefix(reply[len(reply)-1], "ok") {
+			reply = append(reply, "ok")
+		}
+
+		if _, err := f.master.WriteString(strings.Join(reply, "\n") + "\n"); err != nil {
+			return
+		}
+	}
+}
+
+// parse checks the line number and checksum of "N<n> <cmd>*<checksum>".
+func (f *fakeMarlin) parse(line string) (string, bool) {
+	body, cs, ok := strings.Cut(line, "*")
+	if !ok {
+		f.t.Errorf("line without checksum: %q", line)
+		return "", false
+	}
+
+	if want, _ := strconv.Atoi(cs); checksum(body) != want {
+		f.t.Errorf("bad checksum in %q", line)
+		return "", false
+	}
+
+	num, cmd, _ := strings.Cut(body, " ")
+	n, err := strconv.Atoi(strings.TrimPrefix(num, "N"))
+	if err != nil {
+		f.t.Errorf("bad line number in %q", line)
+		return "", false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cmd != "M110 N0" && n != f.lastLine+1 {
+		f.t.Errorf("line %d after %d", n, f.lastLine)
+	}
+	f.lastLine = n
+
+	return cmd, true
+}
+
+func (f *fakeMarlin) handle(cmd string) []string {
+	f.mu.Lock()
+	hold := f.hold
+	defer f.mu.Unlock()
+
+	name, arg, _ := strings.Cut(cmd, " ")
+
+	switch name {
+	case "M110":
+		f.lastLine = 0
+	case "M105":
+		return []string{"ok T:210.0 /210.0 B:60.0 /60.0 @:64 B@:127"}
+	case "M27":
+		if arg == "C" {
+			if f.selected == nil {
+				return []string{"Current file: (no file)"}
+			}
+			return []string{fmt.Sprintf("Current file: %s %s", f.selected.short, f.selected.long)}
+		}
+
+		if !f.sdPrinting {
+			return []string{"Not SD printing"}
+		}
+		return []string{fmt.Sprintf("SD printing byte %d/%d", f.sdPos, f.selected.size)}
+	case "M31":
+		return []string{"echo:Print time: " + f.printTime}
+	case "M20":
+		reply := []string{"Begin file list"}
+		for _, file := range f.files {
+			reply = append(reply, fmt.Sprintf("%s %d %s", file.short, file.size, file.long))
+		}
+		return append(reply, "End file list")
+	case "M23":
+		for i := range f.files {
+			if strings.EqualFold(f.files[i].short, arg) {
+				f.selected = &f.files[i]
+				f.sdPos = 0
+				return []string{fmt.Sprintf("File opened: %s Size: %d", arg, f.files[i].size), "File selected"}
+			}
+		}
+		return []string{fmt.Sprintf("open failed, File: %s.", arg)}
+	case "M24":
+		if f.selected != nil {
+			f.sdPrinting = true
+		}
+	case "M524":
+		f.sdPrinting = false
+		f.selected = nil
+	case "M30":
+		for i := range f.files {
+			if strings.EqualFold(f.files[i].short, arg) {
+				f.files = slices.Delete(f.files, i, i+1)
+				return []string{"File deleted:" + arg}
+			}
+		}
+		return []string{fmt.Sprintf("Deletion failed, File: %s.", arg)}
+	case "G1":
+		if hold != nil {
+			f.mu.Unlock()
+			<-hold
+			f.mu.Lock()
+		}
+	}
+
+	return nil
+}
+
+// setSD puts the fake in the middle of printing the SD card file short.
+func (f *fakeMarlin) setSD(short string, pos int64, printTime string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.files {
+		if f.files[i].short == short {
+			f.selected = &f.files[i]
+		}
+	}
+	f.sdPrinting = true
+	f.sdPos = pos
+	f.printTime = printTime
+}
+
+func startMonitor(t *testing.T, f *fakeMarlin, options Options) *Monitor {
+	t.Helper()
+
+	m, err := NewMonitor("MK3", "serial://"+f.slavePath, options, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	testutil.Eventually(t, "connected", func() bool {
+		s := m.State()
+		return s != printer.Disconnected && s != printer.Unknown
+	})
+
+	return m
+}
+
+func TestMonitorPollsSDPrint(t *testing.T) {
+	f := newFakeMarlin(t)
+	f.files = []fakeSDFile{{short: "BENCHY~1.GCO", long: "Benchy.gcode", size: 1000}}
+	f.setSD("BENCHY~1.GCO", 500, "1h 2m 3s")
+
+	m := startMonitor(t, f, Options{})
+	testutil.WaitFor(t, f.commands, "M105")
+	testutil.WaitFor(t, f.commands, "M27")
+	testutil.WaitFor(t, f.commands, "M31")
+
+	testutil.Eventually(t, "printing", func() bool { return m.State() == printer.Printing })
+
+	j := m.Job()
+	if j == nil || j.Name != "Benchy.gcode" || j.Status != "in_progress" {
+		t.Fatalf("job = %+v", j)
+	}
+	if j.Progress == nil || *j.Progress != 0.5 {
+		t.Errorf("progress = %v", j.Progress)
+	}
+	if j.PrintDuration == nil || *j.PrintDuration != 3723 {
+		t.Errorf("print duration = %v", j.PrintDuration)
+	}
+	if j.EstimatedRemaining == nil || *j.EstimatedRemaining != 3723 {
+		t.Errorf("estimated remaining = %v", j.EstimatedRemaining)
+	}
+
+	raw := string(m.RawReport().(json.RawMessage))
+	if !strings.Contains(raw, `"T":{"actual":210,"target":210}`) {
+		t.Errorf("raw report = %s", raw)
+	}
+
+	// The print ends on the printer.
+	f.mu.Lock()
+	f.sdPos = 1000
+	f.mu.Unlock()
+	testutil.Eventually(t, "progress 100%", func() bool { return *m.Job().Progress == 1 })
+
+	f.mu.Lock()
+	f.sdPrinting = false
+	f.mu.Unlock()
+	testutil.Eventually(t, "ready", func() bool { return m.State() == printer.Ready })
+
+	if j := m.Job(); j.Status != "completed" || j.EndTime == nil {
+		t.Errorf("job after the print = %+v", j)
+	}
+}
+
+func TestSDJobCommands(t *testing.T) {
+	f := newFakeMarlin(t)
+	f.files = []fakeSDFile{{short: "BENCHY~1.GCO", long: "Benchy.gcode", size: 1000}}
+	f.setSD("BENCHY~1.GCO", 100, "5m 0s")
+
+	m := startMonitor(t, f, Options{})
+	testutil.Eventually(t, "printing", func() bool { return m.State() == printer.Printing })
+	ctx := context.Background()
+
+	tests := []struct {
+		send func(context.Context) error
+		want string
+	}{
+		{m.PausePrint, "M25"},
+		{m.ResumePrint, "M24"},
+		{func(ctx context.Context) error { return m.SetStatusMessage(ctx, "50% *done*; ok") }, "M117 50% done ok"},
+		{m.CancelPrint, "M524"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.send(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.WaitFor(t, f.commands, tt.want)
+	}
+
+	testutil.Eventually(t, "ready", func() bool { return m.State() == printer.Ready })
+	if j := m.Job(); j == nil || j.Status != "cancelled" {
+		t.Errorf("job = %+v", j)
+	}
+}
+
+func TestMonitorHaltedFirmware(t *testing.T) {
+	f := newFakeMarlin(t)
+	m := startMonitor(t, f, Options{})
+
+	if _, err := f.master.WriteString("Error:Thermal Runaway, system stopped! Heater_ID: 0\nError:Printer halted. kill() called!\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.Eventually(t, "error", func() bool { return m.State() == printer.Error })
+
+	info := m.ErrorDetail()
+	if info == nil || !strings.HasPrefix(info.Message, "Thermal Runaway") || !strings.Contains(info.Message, "Printer halted") {
+		t.Errorf("ErrorDetail() = %+v", info)
+	}
+}
+
+func TestFileManagerSDCard(t *testing.T) {
+	f := newFakeMarlin(t)
+	f.files = []fakeSDFile{
+		{short: "BENCHY~1.GCO", long: "Benchy.gcode", size: 1000},
+		{short: "CUBE.GCO", long: "cube.gcode", size: 200},
+	}
+
+	m := startMonitor(t, f, Options{})
+
+	files, err := m.Files(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != "sd/Benchy.gcode" || files[0].Size != 1000 || files[1].Path != "sd/cube.gcode" {
+		t.Fatalf("files = %+v", files)
+	}
+
+	if _, err := m.StartPrint(context.Background(), "sd/missing.gcode"); err != printer.ErrFileNotFound {
+		t.Errorf("StartPrint(missing) = %v", err)
+	}
+
+	jobId, err := m.StartPrint(context.Background(), "sd/Benchy.gcode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, f.commands, "M23 BENCHY~1.GCO")
+	testutil.WaitFor(t, f.commands, "M24")
+
+	if got := m.RegisteredJobId(); got != jobId {
+		t.Fatalf("registered %q, want %q", got, jobId)
+	}
+
+	// M27 C reports the long name; it is still the registered job.
+	f.mu.Lock()
+	f.sdPos = 10
+	f.printTime = "2m 0s"
+	f.mu.Unlock()
+	testutil.WaitFor(t, f.commands, "M27 C")
+	testutil.WaitFor(t, f.commands, "M27 C")
+
+	if j := m.Job(); j == nil || j.JobId != jobId || j.Name != "Benchy.gcode" {
+		t.Errorf("job = %+v, want ID %s", j, jobId)
+	}
+	if got := m.RegisteredJobId(); got != jobId {
+		t.Errorf("registration dropped, got %q", got)
+	}
+
+	if err := m.DeleteFile(context.Background(), "sd/cube.gcode"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, f.commands, "M30 CUBE.GCO")
+
+	if err := m.DeleteFile(context.Background(), "sd/cube.gcode"); err != printer.ErrFileNotFound {
+		t.Errorf("deleting twice = %v", err)
+	}
+}
+
+const testGCode = `; generated by a slicer
+G28 ; home
+G1 X10
+G1 X20
+
+G1 X30
+`
+
+func TestFileManagerStreamsLocalFile(t *testing.T) {
+	f := newFakeMarlin(t)
+	hold := make(chan struct{})
+	f.hold = hold
+
+	m := startMonitor(t, f, Options{FilesDir: t.TempDir()})
+
+	uploaded, err := m.UploadFile(context.Background(), "parts/bracket.gcode", strings.NewReader(testGCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Path != "local/parts/bracket.gcode" || uploaded.Size != int64(len(testGCode)) {
+		t.Fatalf("uploaded = %+v", uploaded)
+	}
+
+	if _, err := m.UploadFile(context.Background(), "sd/bracket.gcode", strings.NewReader(testGCode)); err == nil {
+		t.Error("uploading to the SD card should fail")
+	}
+
+	files, err := m.Files(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "local/parts/bracket.gcode" {
+		t.Fatalf("files = %+v", files)
+	}
+
+	jobId, err := m.StartPrint(context.Background(), uploaded.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.RegisteredJobId() != jobId {
+		t.Errorf("job %s not registered", jobId)
+	}
+
+	testutil.WaitFor(t, f.commands, "G28")
+	testutil.WaitFor(t, f.commands, "G1 X10")
+
+	// Pausing holds back the lines still to send.
+	if err := m.PausePrint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hold <- struct{}{}
+	testutil.Eventually(t, "paused", func() bool { return m.State() == printer.Pause })
+	testutil.ExpectNone(t, f.commands, "G1 X20", 300*time.Millisecond)
+
+	if err := m.ResumePrint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, f.commands, "G1 X20")
+	hold <- struct{}{}
+	testutil.WaitFor(t, f.commands, "G1 X30")
+	hold <- struct{}{}
+
+	testutil.Eventually(t, "completed", func() bool {
+		j := m.Job()
+		return j != nil && j.Status == "completed"
+	})
+	if j := m.Job(); j.JobId != jobId || j.Name != "bracket.gcode" {
+		t.Errorf("job = %+v", j)
+	}
+
+	if err := m.DeleteFile(context.Background(), uploaded.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(m.filesDir, "parts", "bracket.gcode")); !os.IsNotExist(err) {
+		t.Errorf("file still there: %v", err)
+	}
+}
+
+func TestCancelStreamedPrint(t *testing.T) {
+	f := newFakeMarlin(t)
+	hold := make(chan struct{})
+	f.hold = hold
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "part.gcode"), []byte(testGCode), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := startMonitor(t, f, Options{FilesDir: dir})
+
+	if _, err := m.StartPrint(context.Background(), "local/part.gcode"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, f.commands, "G1 X10")
+
+	// Cancelling a paused print, so that the stream is known to be
+	// waiting rather than sending the next line.
+	if err := m.PausePrint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	hold <- struct{}{}
+	testutil.Eventually(t, "paused", func() bool { return m.State() == printer.Pause })
+
+	if err := m.CancelPrint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WaitFor(t, f.commands, "M104 S0")
+	testutil.WaitFor(t, f.commands, "M140 S0")
+	testutil.WaitFor(t, f.commands, "M107")
+
+	testutil.Eventually(t, "cancelled", func() bool {
+		j := m.Job()
+		return j != nil && j.Status == "cancelled"
+	})
+	testutil.ExpectNone(t, f.commands, "G1 X20", 300*time.Millisecond)
+}
@@ -0,0 +1,159 @@
+package mjpeg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ErrStreamEnded is Err after the upstream server ended the stream.
+var ErrStreamEnded = errors.New("stream ended")
+
+// Open opens the upstream stream, returning its body and Content-Type. The
+// stream must end once ctx is done.
+type Open func(ctx context.Context) (io.ReadCloser, string, error)
+
+// Broadcaster relays an upstream stream to any number of viewers. It opens
+// the stream for the first viewer and closes it after the last one left.
+type Broadcaster struct {
+	ctx    context.Context
+	open   Open
+	logger *zap.SugaredLogger
+
+	mu      sync.Mutex
+	current *session
+	err     error
+}
+
+// session is one run of the upstream stream, along with its viewers.
+type session struct {
+	cancel  context.CancelFunc
+	viewers map[chan []byte]struct{}
+}
+
+// NewBroadcaster relays the streams open opens until ctx is done.
+func NewBroadcaster(ctx context.Context, open Open, logger *zap.SugaredLogger) *Broadcaster {
+	return &Broadcaster{
+		ctx:    ctx,
+		open:   open,
+		logger: logger,
+	}
+}
+
+// Subscribe adds a viewer. Frames arrive on the returned channel, which is
+// closed when the upstream stream ends; Err tells why. A viewer that falls
+// behind skips frames rather than holding up the others. Call leave once
+// done viewing.
+func (b *Broadcaster) Subscribe() (frames <-chan []byte, leave func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.current
+	if s == nil {
+		ctx, cancel := context.WithCancel(b.ctx)
+		s = &session{
+			cancel:  cancel,
+			viewers: make(map[chan []byte]struct{}),
+		}
+		b.current = s
+
+		go b.run(ctx, s)
+	}
+
+	ch := make(chan []byte, 1)
+	s.viewers[ch] = struct{}{}
+
+	return ch, func() { b.leave(s, ch) }
+}
+
+// Err returns the error that ended the last upstream stream.
+func (b *Broadcaster) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
+}
+
+func (b *Broadcaster) leave(s *session, ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := s.viewers[ch]; !ok {
+		return
+	}
+
+	delete(s.viewers, ch)
+
+	if len(s.viewers) == 0 && b.current == s {
+		s.cancel()
+		b.current = nil
+	}
+}
+
+func (b *Broadcaster) run(ctx context.Context, s *session) {
+	err := b.relay(ctx, s)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ctx.Err() != nil {
+		// The last viewer left.
+		err = nil
+	} else {
+		b.logger.Warnf("MJPEG stream ended: %s\n", err)
+	}
+	b.err = err
+
+	for ch := range s.viewers {
+		close(ch)
+	}
+	s.viewers = nil
+
+	if b.current == s {
+		b.current = nil
+	}
+	s.cancel()
+}
+
+func (b *Broadcaster) relay(ctx context.Context, s *session) error {
+	body, contentType, err := b.open(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	r, err := NewReader(body, contentType)
+	if err != nil {
+		return err
+	}
+
+	for {
+		frame, err := r.NextFrame()
+		if errors.Is(err, io.EOF) {
+			return ErrStreamEnded
+		} else if err != nil {
+			return err
+		}
+
+		b.broadcast(s, frame)
+	}
+}
+
+func (b *Broadcaster) broadcast(s *session, frame []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range s.viewers {
+		// Replace the frame a slow viewer hasn't taken yet. Nobody else
+		// sends on ch, so there is room after draining it.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- frame
+	}
+}
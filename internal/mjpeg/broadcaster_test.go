@@ -0,0 +1,277 @@
+package mjpeg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fakeCamera serves a multipart MJPEG stream of the frames the test sends.
+type fakeCamera struct {
+	srv *httptest.Server
+
+	frames chan []byte
+	// opened and closed receive once per stream served.
+	opened chan struct{}
+	closed chan struct{}
+}
+
+func newFakeCamera(t *testing.T) *fakeCamera {
+	c := &fakeCamera{
+		frames: make(chan []byte),
+		opened: make(chan struct{}, 10),
+		closed: make(chan struct{}, 10),
+	}
+
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.opened <- struct{}{}
+		defer func() { c.closed <- struct{}{} }()
+
+		mw := NewWriter(w)
+		w.Header().Set("Content-Type", mw.ContentType())
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case frame, ok := <-c.frames:
+				if !ok {
+					return
+				}
+				if err := mw.WriteFrame(frame); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	t.Cleanup(c.srv.Close)
+
+	return c
+}
+
+func (c *fakeCamera) open(ctx context.Context) (io.ReadCloser, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.srv.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func (c *fakeCamera) send(t *testing.T, frame string) {
+	t.Helper()
+
+	select {
+	case c.frames <- []byte(frame):
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nobody read frame %s", frame)
+	}
+}
+
+func wait(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func receive(t *testing.T, frames <-chan []byte) string {
+	t.Helper()
+
+	select {
+	case frame, ok := <-frames:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return string(frame)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+		return ""
+	}
+}
+
+func newTestBroadcaster(t *testing.T, open Open) *Broadcaster {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return NewBroadcaster(ctx, open, zap.NewNop().Sugar())
+}
+
+func TestReaderReadsWriterFrames(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, frame := range []string{"one", "two"} {
+		if err := w.WriteFrame([]byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReader(&buf, w.ContentType())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"one", "two"} {
+		frame, err := r.NextFrame()
+		if err != nil || string(frame) != want {
+			t.Fatalf("NextFrame() = %q, %v, want %q", frame, err, want)
+		}
+	}
+
+	if _, err := NewReader(&buf, "image/jpeg"); err == nil {
+		t.Error("read a stream that is not multipart")
+	}
+}
+
+func TestBroadcasterFansOut(t *testing.T) {
+	c := newFakeCamera(t)
+	b := newTestBroadcaster(t, c.open)
+
+	frames1, leave1 := b.Subscribe()
+	defer leave1()
+	frames2, leave2 := b.Subscribe()
+	defer leave2()
+
+	wait(t, c.opened, "the stream to open")
+
+	c.send(t, "frame 1")
+	if f1, f2 := receive(t, frames1), receive(t, frames2); f1 != "frame 1" || f2 != "frame 1" {
+		t.Fatalf("viewers got %q and %q", f1, f2)
+	}
+
+	select {
+	case <-c.opened:
+		t.Fatal("the stream was opened once per viewer")
+	default:
+	}
+}
+
+func TestBroadcasterDropsFramesForSlowViewer(t *testing.T) {
+	c := newFakeCamera(t)
+	b := newTestBroadcaster(t, c.open)
+
+	fast, leaveFast := b.Subscribe()
+	defer leaveFast()
+	slow, leaveSlow := b.Subscribe()
+	defer leaveSlow()
+
+	wait(t, c.opened, "the stream to open")
+
+	for _, frame := range []string{"1", "2", "3"} {
+		c.send(t, frame)
+		if got := receive(t, fast); got != frame {
+			t.Fatalf("fast viewer got %q, want %q", got, frame)
+		}
+	}
+
+	// Let the broadcast of the last frame finish.
+	b.mu.Lock()
+	b.mu.Unlock()
+
+	if got := receive(t, slow); got != "3" {
+		t.Fatalf("slow viewer got %q, want the latest frame", got)
+	}
+	select {
+	case frame := <-slow:
+		t.Fatalf("slow viewer got the stale frame %q", frame)
+	default:
+	}
+}
+
+func TestBroadcasterClosesUpstreamAfterLastLeave(t *testing.T) {
+	c := newFakeCamera(t)
+	b := newTestBroadcaster(t, c.open)
+
+	_, leave1 := b.Subscribe()
+	_, leave2 := b.Subscribe()
+	wait(t, c.opened, "the stream to open")
+
+	leave1()
+	select {
+	case <-c.closed:
+		t.Fatal("closed while a viewer was left")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	leave2()
+	leave2()
+	wait(t, c.closed, "the stream to close")
+
+	// The next viewer opens the stream again.
+	frames, leave := b.Subscribe()
+	defer leave()
+	wait(t, c.opened, "the stream to reopen")
+
+	c.send(t, "again")
+	if got := receive(t, frames); got != "again" {
+		t.Fatalf("got %q", got)
+	}
+	if err := b.Err(); err != nil {
+		t.Errorf("Err() = %v after viewers left", err)
+	}
+}
+
+func TestBroadcasterReportsUpstreamEnd(t *testing.T) {
+	c := newFakeCamera(t)
+	b := newTestBroadcaster(t, c.open)
+
+	frames, leave := b.Subscribe()
+	defer leave()
+	wait(t, c.opened, "the stream to open")
+
+	c.send(t, "last")
+	receive(t, frames)
+	close(c.frames)
+
+	select {
+	case _, ok := <-frames:
+		if ok {
+			t.Fatal("frame after the stream ended")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("viewer not told the stream ended")
+	}
+	if !errors.Is(b.Err(), ErrStreamEnded) {
+		t.Errorf("Err() = %v, want ErrStreamEnded", b.Err())
+	}
+}
+
+func TestBroadcasterReportsOpenError(t *testing.T) {
+	errOffline := errors.New("camera offline")
+	b := newTestBroadcaster(t, func(ctx context.Context) (io.ReadCloser, string, error) {
+		return nil, "", errOffline
+	})
+
+	frames, leave := b.Subscribe()
+	defer leave()
+
+	select {
+	case _, ok := <-frames:
+		if ok {
+			t.Fatal("frame from a stream that failed to open")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("viewer not told the stream failed")
+	}
+	if !errors.Is(b.Err(), errOffline) {
+		t.Errorf("Err() = %v, want %v", b.Err(), errOffline)
+	}
+}
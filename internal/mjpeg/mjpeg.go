@@ -0,0 +1,100 @@
+// Package mjpeg reads and writes multipart MJPEG streams, what most
+// printer webcam servers (mjpg-streamer, ustreamer, crowsnest) serve, and
+// relays one upstream stream to many viewers.
+package mjpeg
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxFrameSize bounds a single frame, far above any webcam's JPEG.
+const maxFrameSize = 16 << 20
+
+// Reader reads the frames of a multipart MJPEG stream.
+type Reader struct {
+	mr *multipart.Reader
+}
+
+// NewReader reads the stream from r, given the Content-Type it was served
+// with, which carries the boundary between frames.
+func NewReader(r io.Reader, contentType string) (*Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, fmt.Errorf("not a multipart stream: %s", contentType)
+	}
+
+	return &Reader{mr: multipart.NewReader(r, params["boundary"])}, nil
+}
+
+// NextFrame returns the next frame, usually a JPEG.
+func (r *Reader) NextFrame() ([]byte, error) {
+	part, err := r.mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	// A part only ends at the next boundary, which the server sends along
+	// with the next frame. Reading up to the Content-Length most servers
+	// send returns a frame as soon as it is complete.
+	if n, err := strconv.Atoi(part.Header.Get("Content-Length")); err == nil && n >= 0 {
+		if n > maxFrameSize {
+			return nil, fmt.Errorf("frame larger than %d bytes", maxFrameSize)
+		}
+
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(part, frame); err != nil {
+			return nil, err
+		}
+
+		return frame, nil
+	}
+
+	frame, err := io.ReadAll(io.LimitReader(part, maxFrameSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(frame) > maxFrameSize {
+		return nil, fmt.Errorf("frame larger than %d bytes", maxFrameSize)
+	}
+
+	return frame, nil
+}
+
+// Writer writes JPEG frames as a multipart MJPEG stream.
+type Writer struct {
+	mw *multipart.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{mw: multipart.NewWriter(w)}
+}
+
+// ContentType is the Content-Type to serve the stream with.
+func (w *Writer) ContentType() string {
+	return "multipart/x-mixed-replace; boundary=" + w.mw.Boundary()
+}
+
+func (w *Writer) WriteFrame(frame []byte) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", "image/jpeg")
+	header.Set("Content-Length", strconv.Itoa(len(frame)))
+
+	part, err := w.mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = part.Write(frame)
+	return err
+}
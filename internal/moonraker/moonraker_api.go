@@ -7,367 +7,719 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// APIError is the error member Moonraker answers failed requests with,
+// over HTTP as well as over the websocket.
 type APIError struct {
 	Code      int    `json:"code"`
 	Message   string `json:"message"`
 	Traceback string `json:"traceback"`
 }
 
-// ---------------------------
-// Query Printer Object Status
-
-type PrinterObjectDisplayStatus struct {
-	Message  string  `json:"message"`
-	Progress float32 `json:"progress"`
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api response %d %s", e.Code, e.Message)
 }
 
-type PrinterObjectIdleTimeout struct {
-	State        string  `json:"state"`
-	PrintingTime float32 `json:"printing_time"`
+// ERRRespNotOk is returned when Moonraker answers with a non-200 HTTP
+// status code. It wraps the *APIError from the body when there is one.
+type ERRRespNotOk struct {
+	error
+
+	statusCode int
+	respBody   []byte
 }
 
-type PrinterObjectPrintStats struct {
-	FileName      string  `json:"filename"`
-	TotalDuration float32 `json:"total_duration"`
-	PrintDuration float32 `json:"print_duration"`
-	FilamentUsed  float32 `json:"filament_used"`
-	State         string  `json:"state"`
-	Message       string  `json:"message"`
+func (e ERRRespNotOk) RespStatusCode() int {
+	return e.statusCode
 }
 
-func (p *PrinterObjectPrintStats) GetPrintDuration() time.Duration {
-	return time.Duration(p.PrintDuration * float32(time.Second))
+func (e ERRRespNotOk) RespBody() []byte {
+	return e.respBody
 }
 
-func (p *PrinterObjectPrintStats) GetTotalDuration() time.Duration {
-	return time.Duration(p.TotalDuration * float32(time.Second))
+func (e ERRRespNotOk) Error() string {
+	return e.error.Error()
 }
 
-type PrinterObjectToolhead struct {
-	PrintTime          float32 `json:"print_time"`
-	EstimatedPrintTime float32 `json:"estimated_print_time"`
+func (e ERRRespNotOk) Unwrap() error {
+	return e.error
 }
 
-type PrinterObjectVirtualSDCard struct {
-	Progress float32 `json:"progress"`
-	IsActive bool    `json:"is_active"`
+// Timeouts bounds each kind of call. A shorter deadline on the caller's ctx
+// still applies.
+type Timeouts struct {
+	// Query covers status, metadata and history queries.
+	Query time.Duration
+	// Print covers pause, resume and cancel, which wait for Klipper's
+	// macros to finish, and switching power devices.
+	Print time.Duration
+	// GCode covers G-code scripts.
+	GCode time.Duration
+	// Upload covers file uploads.
+	Upload time.Duration
 }
 
-type PrinterObjectWebhooks struct {
-	State        string `json:"state"`
-	StateMessage string `json:"state_message"`
+var DefaultTimeouts = Timeouts{
+	Query:  5 * time.Second,
+	Print:  30 * time.Second,
+	GCode:  10 * time.Second,
+	Upload: 10 * time.Minute,
 }
 
-//goland:noinspection SpellCheckingInspection
-type PrinterObjectsResponse struct {
-	Result struct {
-		EventTime float32 `json:"eventtime"`
-		Status    *struct {
-			DisplayStatus PrinterObjectDisplayStatus `json:"display_status"`
-			IdleTimeout   PrinterObjectIdleTimeout   `json:"idle_timeout"`
-			PrintStats    PrinterObjectPrintStats    `json:"print_stats"`
-			Toolhead      PrinterObjectToolhead      `json:"toolhead"`
-			VirtualSDCard PrinterObjectVirtualSDCard `json:"virtual_sdcard"`
-			Webhooks      PrinterObjectWebhooks      `json:"webhooks"`
-		} `json:"status"`
-	} `json:"result"`
-	Error *APIError `json:"error"`
+// Client is a Moonraker HTTP API client. Behind Moonraker's
+// [authorization] it authenticates with an API key or, given a user's
+// credentials, with the JWT /access/login returns, which it refreshes
+// whenever Moonraker answers 401.
+type Client struct {
+	baseUrl    *url.URL
+	httpClient *http.Client
+	timeouts   Timeouts
+
+	apiKey   string
+	username string
+	password string
+
+	authMu       sync.Mutex
+	accessToken  string
+	refreshToken string
 }
 
-type ERRRespNotOk struct {
-	error
+type ClientOption func(c *Client)
 
-	statusCode int
-	respBody   []byte
+// WithHTTPClient makes the client send its requests through httpClient
+// instead of http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
 }
 
-func (e ERRRespNotOk) RespStatusCode() int {
-	return e.statusCode
+// WithTimeouts replaces DefaultTimeouts. Zero fields keep their default.
+func WithTimeouts(timeouts Timeouts) ClientOption {
+	return func(c *Client) {
+		if timeouts.Query > 0 {
+			c.timeouts.Query = timeouts.Query
+		}
+		if timeouts.Print > 0 {
+			c.timeouts.Print = timeouts.Print
+		}
+		if timeouts.GCode > 0 {
+			c.timeouts.GCode = timeouts.GCode
+		}
+		if timeouts.Upload > 0 {
+			c.timeouts.Upload = timeouts.Upload
+		}
+	}
 }
 
-func (e ERRRespNotOk) RespBody() []byte {
-	return e.respBody
+// WithAPIKey authenticates every request with a Moonraker API key.
+func WithAPIKey(apiKey string) ClientOption {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
 }
 
-func (e ERRRespNotOk) Error() string {
-	return e.error.Error()
+// WithLogin authenticates every request as a Moonraker user. The client
+// logs in on its first request.
+func WithLogin(username string, password string) ClientOption {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
 }
 
-func GetPrinterObjects(ctx context.Context) (*PrinterObjectsResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
+func NewClient(baseUrl *url.URL, opts ...ClientOption) *Client {
+	c := &Client{
+		baseUrl:    baseUrl,
+		httpClient: http.DefaultClient,
+		timeouts:   DefaultTimeouts,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
 
-	// build URL
-	u := moonrakerAPIUrl.JoinPath("/printer/objects/query")
+	return c
+}
 
-	query := u.Query()
-	query.Set("webhooks", "")
-	query.Set("print_stats", "")
-	query.Set("idle_timeout", "")
-	query.Set("display_status", "")
-	query.Set("toolhead", "")
-	query.Set("virtual_sdcard", "")
-	u.RawQuery = query.Encode()
+func (c *Client) BaseUrl() *url.URL {
+	return c.baseUrl
+}
 
-	// build request
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
-	if err != nil {
-		return nil, err
-	}
+// requestBody returns a fresh reader over a request body along with its
+// content type. send calls it once per attempt, as it retries a request
+// that failed authentication.
+type requestBody func() (io.Reader, string, error)
 
-	// do request
-	resp, err := http.DefaultClient.Do(req)
+func jsonBody(v any) (requestBody, error) {
+	b, err := json.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	return func() (io.Reader, string, error) {
+		return bytes.NewReader(b), "application/json", nil
+	}, nil
+}
 
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalln(err)
+// send sends an authenticated request and returns the response for the
+// caller to read and close. A non-200 response is turned into ERRRespNotOk
+// instead. body is sent as JSON unless it is a requestBody.
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, body any) (*http.Response, error) {
+	var b requestBody
+	switch body := body.(type) {
+	case nil:
+	case requestBody:
+		b = body
+	default:
+		var err error
+		if b, err = jsonBody(body); err != nil {
+			return nil, err
+		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, ERRRespNotOk{
-			error:      errors.New("non-200 http response"),
-			statusCode: resp.StatusCode,
-			respBody:   b,
-		}
+	if c.username == "" {
+		return c.sendOnce(ctx, method, path, query, b, c.authHeader(""))
 	}
 
-	out := new(PrinterObjectsResponse)
-	err = json.NewDecoder(bytes.NewReader(b)).Decode(out)
+	token, err := c.login(ctx, "")
 	if err != nil {
 		return nil, err
 	}
 
-	return out, nil
-}
+	resp, err := c.sendOnce(ctx, method, path, query, b, c.authHeader(token))
 
-// ---------------------------
-// Get Klippy host information
+	var notOk ERRRespNotOk
+	if errors.As(err, &notOk) && notOk.RespStatusCode() == http.StatusUnauthorized {
+		// The access token expired (they last an hour) or Moonraker
+		// restarted with a new secret.
+		if token, err = c.login(ctx, token); err != nil {
+			return nil, err
+		}
 
-type KlipperInfo struct {
-	State        string `json:"state"`
-	StateMessage string `json:"state_message"`
-	HostName     string `json:"hostname"`
-	SWVersion    string `json:"software_version"`
-	CPUInfo      string `json:"cpu_info"`
-	KlipperPath  string `json:"klipper_path"`
-	PythonPath   string `json:"python_path"`
-	LogFile      string `json:"log_file"`
-	ConfigFile   string `json:"config_file"`
+		return c.sendOnce(ctx, method, path, query, b, c.authHeader(token))
+	}
+
+	return resp, err
 }
 
-type GetKlippyHostInfoResponse struct {
-	Result KlipperInfo `json:"result"`
+func (c *Client) authHeader(token string) http.Header {
+	h := make(http.Header)
+
+	if token != "" {
+		h.Set("Authorization", "Bearer "+token)
+	} else if c.apiKey != "" {
+		h.Set("X-Api-Key", c.apiKey)
+	}
+
+	return h
 }
 
-func GetKlippyHostInfo(ctx context.Context) (*GetKlippyHostInfoResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
+func (c *Client) sendOnce(ctx context.Context, method string, path string, query url.Values, body requestBody, header http.Header) (*http.Response, error) {
+	u := c.baseUrl.JoinPath(path)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
 
-	u := moonrakerAPIUrl.JoinPath("/printer/info")
+	var r io.Reader
+	var contentType string
+	if body != nil {
+		var err error
+		if r, contentType, err = body(); err != nil {
+			return nil, err
+		}
+	}
 
-	// build request
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
 	if err != nil {
 		return nil, err
 	}
 
-	// do request
-	resp, err := http.DefaultClient.Do(req)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
 	defer resp.Body.Close()
 
-	out := new(GetKlippyHostInfoResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	return out, nil
-}
+	notOk := ERRRespNotOk{
+		error:      fmt.Errorf("non-200 http response: %d", resp.StatusCode),
+		statusCode: resp.StatusCode,
+		respBody:   b,
+	}
 
-// -------------
-// Pause a Print
+	var envelope struct {
+		Error *APIError `json:"error"`
+	}
+	if json.Unmarshal(b, &envelope) == nil && envelope.Error != nil {
+		notOk.error = envelope.Error
+	}
 
-type PausePrintResponse struct {
-	Result string `json:"result"`
+	return nil, notOk
 }
 
-func PausePrint(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+// do sends a request and decodes the result member of the response into
+// out, which may be nil.
+func (c *Client) do(ctx context.Context, timeout time.Duration, method string, path string, query url.Values, body any, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
-
-	// build URL
-	u := moonrakerAPIUrl.JoinPath("/printer/print/pause")
 
-	// build request
-	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), nil)
+	resp, err := c.send(ctx, method, path, query, body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// do request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	return decodeResult(resp, out)
+}
+
+// decodeResult decodes the result member of resp into out, which may be
+// nil, and closes resp.
+func decodeResult(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  *APIError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
+	if envelope.Error != nil {
+		return envelope.Error
+	}
 
-	out := new(PausePrintResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
-	if err != nil {
+	if out == nil || envelope.Result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(envelope.Result, out)
+}
+
+// doOk is do for commands whose result is the string "ok".
+func (c *Client) doOk(ctx context.Context, timeout time.Duration, method string, path string, query url.Values, body any) error {
+	var result string
+	if err := c.do(ctx, timeout, method, path, query, body, &result); err != nil {
 		return err
 	}
 
-	if out.Result != "ok" {
-		return errors.New(out.Result)
+	if result != "ok" {
+		return fmt.Errorf("unexpected result '%s'", result)
 	}
 
 	return nil
 }
 
-func CancelPrint(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+// --------------
+// Authorization
+
+type loginResult struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// login returns the access token to use. Once a request failed with stale,
+// it refreshes the token, falling back to logging in again, unless another
+// request already did.
+func (c *Client) login(ctx context.Context, stale string) (string, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.accessToken != "" && c.accessToken != stale {
+		return c.accessToken, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Query)
 	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
 
-	// build URL
-	u := moonrakerAPIUrl.JoinPath("/printer/print/cancel")
+	if c.refreshToken != "" {
+		var out loginResult
+		err := c.post(ctx, "/access/refresh_jwt", map[string]any{"refresh_token": c.refreshToken}, &out)
+		if err == nil {
+			c.accessToken = out.Token
+			return c.accessToken, nil
+		}
+
+		c.refreshToken = ""
+	}
 
-	// build request
-	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), nil)
+	var out loginResult
+	err := c.post(ctx, "/access/login", map[string]any{
+		"username": c.username,
+		"password": c.password,
+		"source":   "moonraker",
+	}, &out)
+	if err != nil {
+		c.accessToken = ""
+		return "", fmt.Errorf("login as %s: %w", c.username, err)
+	}
+
+	c.accessToken = out.Token
+	c.refreshToken = out.RefreshToken
+
+	return c.accessToken, nil
+}
+
+// post sends an unauthenticated POST, as the login endpoints expect.
+func (c *Client) post(ctx context.Context, path string, body any, out any) error {
+	b, err := jsonBody(body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	// do request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.sendOnce(ctx, "POST", path, nil, b, nil)
 	if err != nil {
 		return err
 	}
 
-	defer resp.Body.Close()
+	return decodeResult(resp, out)
+}
+
+// OneshotToken returns a token that authenticates a single request when
+// passed as its "token" query parameter, for clients that cannot send
+// headers. It returns "" if the client has no credentials.
+func (c *Client) OneshotToken(ctx context.Context) (string, error) {
+	if c.apiKey == "" && c.username == "" {
+		return "", nil
+	}
+
+	var token string
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/access/oneshot_token", nil, nil, &token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
 
-	out := new(PausePrintResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
+// withOneshotToken adds a oneshot token to u if the client has credentials.
+func (c *Client) withOneshotToken(ctx context.Context, u *url.URL) (*url.URL, error) {
+	token, err := c.OneshotToken(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if out.Result != "ok" {
-		return errors.New(out.Result)
+	if token != "" {
+		query := u.Query()
+		query.Set("token", token)
+		u.RawQuery = query.Encode()
 	}
 
-	return nil
+	return u, nil
 }
 
-// --------------
-// Resume a Print
+// WebSocketURL returns the URL of Moonraker's websocket, authenticated with
+// a oneshot token if needed.
+func (c *Client) WebSocketURL(ctx context.Context) (*url.URL, error) {
+	return c.withOneshotToken(ctx, WebSocketURL(c.baseUrl))
+}
 
-type ResumePrintResponse struct {
-	Result string `json:"result"`
+// FileURL returns the URL of a file in one of Moonraker's roots (e.g.
+// "gcodes"), authenticated with a oneshot token if needed.
+func (c *Client) FileURL(ctx context.Context, root string, path string) (*url.URL, error) {
+	return c.withOneshotToken(ctx, c.baseUrl.JoinPath("/server/files", root, path))
 }
 
-func ResumePrint(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
+// ---------------------------
+// Query Printer Object Status
 
-	// build URL
-	u := moonrakerAPIUrl.JoinPath("/printer/print/resume")
+type PrinterObjectDisplayStatus struct {
+	Message  string  `json:"message"`
+	Progress float32 `json:"progress"`
+}
 
-	// build request
-	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+type PrinterObjectIdleTimeout struct {
+	State        string  `json:"state"`
+	PrintingTime float32 `json:"printing_time"`
+}
 
-	// do request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
+// PrintStatsInfo is what SET_PRINT_STATS_INFO set; nil if the slicer
+// didn't emit it.
+type PrintStatsInfo struct {
+	TotalLayer   *int `json:"total_layer"`
+	CurrentLayer *int `json:"current_layer"`
+}
 
-	defer resp.Body.Close()
+type PrinterObjectPrintStats struct {
+	FileName      string         `json:"filename"`
+	TotalDuration float32        `json:"total_duration"`
+	PrintDuration float32        `json:"print_duration"`
+	FilamentUsed  float32        `json:"filament_used"`
+	State         string         `json:"state"`
+	Message       string         `json:"message"`
+	Info          PrintStatsInfo `json:"info"`
+}
 
-	out := new(ResumePrintResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
-	if err != nil {
-		return err
+func (p *PrinterObjectPrintStats) GetPrintDuration() time.Duration {
+	return time.Duration(p.PrintDuration * float32(time.Second))
+}
+
+func (p *PrinterObjectPrintStats) GetTotalDuration() time.Duration {
+	return time.Duration(p.TotalDuration * float32(time.Second))
+}
+
+// PrinterObjectGCodeMove is the G-code state. GCodePosition is [X, Y, Z, E]
+// as the G-code sees it; HomingOrigin holds the SET_GCODE_OFFSET offsets.
+// SpeedFactor and ExtrudeFactor are M220/M221's percentages as a fraction.
+type PrinterObjectGCodeMove struct {
+	GCodePosition []float64 `json:"gcode_position"`
+	HomingOrigin  []float64 `json:"homing_origin"`
+	SpeedFactor   float64   `json:"speed_factor"`
+	ExtrudeFactor float64   `json:"extrude_factor"`
+}
+
+// PrinterObjectFan is the part cooling fan, speed 0..1.
+type PrinterObjectFan struct {
+	Speed float64 `json:"speed"`
+}
+
+type PrinterObjectVirtualSDCard struct {
+	Progress float32 `json:"progress"`
+	IsActive bool    `json:"is_active"`
+}
+
+// ExcludeObjectDefinition is a part defined by EXCLUDE_OBJECT_DEFINE.
+type ExcludeObjectDefinition struct {
+	Name    string      `json:"name"`
+	Center  []float64   `json:"center"`
+	Polygon [][]float64 `json:"polygon"`
+}
+
+type PrinterObjectExcludeObject struct {
+	Objects         []ExcludeObjectDefinition `json:"objects"`
+	ExcludedObjects []string                  `json:"excluded_objects"`
+	CurrentObject   *string                   `json:"current_object"`
+}
+
+type PrinterObjectWebhooks struct {
+	State        string `json:"state"`
+	StateMessage string `json:"state_message"`
+}
+
+// QueryObjects returns all fields of the named printer objects, keyed by
+// object name. MonitorPrinterObjects decodes the ones the monitor uses.
+func (c *Client) QueryObjects(ctx context.Context, objects ...string) (map[string]map[string]any, error) {
+	query := url.Values{}
+	for _, o := range objects {
+		query.Set(o, "")
 	}
 
-	if out.Result != "ok" {
-		return errors.New(out.Result)
+	var out struct {
+		Status map[string]map[string]any `json:"status"`
+	}
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/printer/objects/query", query, nil, &out); err != nil {
+		return nil, err
 	}
 
-	return nil
+	if out.Status == nil {
+		return nil, errors.New("no status in response")
+	}
+
+	return out.Status, nil
 }
 
-// -----------
-// Run a GCode
+// ---------------------------
+// Get Klippy host information
 
-type RunGCodeResponse struct {
-	Result string    `json:"result"`
-	Error  *APIError `json:"error"`
+type KlipperInfo struct {
+	State        string `json:"state"`
+	StateMessage string `json:"state_message"`
+	HostName     string `json:"hostname"`
+	SWVersion    string `json:"software_version"`
+	CPUInfo      string `json:"cpu_info"`
+	KlipperPath  string `json:"klipper_path"`
+	PythonPath   string `json:"python_path"`
+	LogFile      string `json:"log_file"`
+	ConfigFile   string `json:"config_file"`
 }
 
-func RunGCode(ctx context.Context, script string) error {
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
+func (c *Client) GetKlippyHostInfo(ctx context.Context) (*KlipperInfo, error) {
+	out := new(KlipperInfo)
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/printer/info", nil, nil, out); err != nil {
+		return nil, err
+	}
 
-	u := moonrakerAPIUrl.JoinPath("/printer/gcode/script")
-	query := u.Query()
-	query.Set("script", script)
-	u.RawQuery = query.Encode()
+	return out, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
-	if err != nil {
-		return err
+// --------------------------------
+// Get Moonraker server information
+
+type ServerInfo struct {
+	KlippyConnected  bool     `json:"klippy_connected"`
+	KlippyState      string   `json:"klippy_state"`
+	Components       []string `json:"components"`
+	Warnings         []string `json:"warnings"`
+	MoonrakerVersion string   `json:"moonraker_version"`
+	APIVersionString string   `json:"api_version_string"`
+}
+
+func (c *Client) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	out := new(ServerInfo)
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/info", nil, nil, out); err != nil {
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	return out, nil
+}
+
+// ---------------
+// Get System Info
+
+type MachineCPUInfo struct {
+	CPUCount     int    `json:"cpu_count"`
+	Bits         string `json:"bits"`
+	Processor    string `json:"processor"`
+	CPUDesc      string `json:"cpu_desc"`
+	HardwareDesc string `json:"hardware_desc"`
+	Model        string `json:"model"`
+	TotalMemory  int    `json:"total_memory"`
+	MemoryUnits  string `json:"memory_units"`
+}
+
+type MachineDistribution struct {
+	Name     string `json:"name"`
+	Id       string `json:"id"`
+	Version  string `json:"version"`
+	Codename string `json:"codename"`
+}
+
+type MachineSystemInfo struct {
+	CPUInfo      MachineCPUInfo      `json:"cpu_info"`
+	Distribution MachineDistribution `json:"distribution"`
+}
+
+func (c *Client) GetMachineSystemInfo(ctx context.Context) (*MachineSystemInfo, error) {
+	var out struct {
+		SystemInfo MachineSystemInfo `json:"system_info"`
+	}
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/machine/system_info", nil, nil, &out); err != nil {
+		return nil, err
 	}
 
-	defer resp.Body.Close()
+	return &out.SystemInfo, nil
+}
 
-	out := new(RunGCodeResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
-	if err != nil {
-		return err
+// --------------------
+// Power device control
+
+// PowerDevice is a device configured in a [power] section of moonraker.conf.
+// Status is "on", "off", "init" or "error".
+type PowerDevice struct {
+	Device              string `json:"device"`
+	Status              string `json:"status"`
+	LockedWhilePrinting bool   `json:"locked_while_printing"`
+	Type                string `json:"type"`
+}
+
+func (c *Client) ListPowerDevices(ctx context.Context) ([]PowerDevice, error) {
+	var out struct {
+		Devices []PowerDevice `json:"devices"`
+	}
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/machine/device_power/devices", nil, nil, &out); err != nil {
+		return nil, err
 	}
 
-	if out.Result != "ok" {
-		return fmt.Errorf("api response %d %s", out.Error.Code, out.Error.Message)
+	return out.Devices, nil
+}
+
+// SetPowerDevice runs action ("on", "off" or "toggle") on device and
+// returns the device's status after it.
+func (c *Client) SetPowerDevice(ctx context.Context, device string, action string) (string, error) {
+	query := url.Values{}
+	query.Set("device", device)
+	query.Set("action", action)
+
+	var out map[string]string
+	if err := c.do(ctx, c.timeouts.Print, "POST", "/machine/device_power/device", query, nil, &out); err != nil {
+		return "", err
 	}
 
-	return nil
+	return out[device], nil
+}
+
+// ---------------------------
+// Pause, Resume, Cancel a Print, Emergency Stop
+
+func (c *Client) PausePrint(ctx context.Context) error {
+	return c.doOk(ctx, c.timeouts.Print, "POST", "/printer/print/pause", nil, nil)
 }
 
-func SetStatusMessage(ctx context.Context, msg string) error {
-	return RunGCode(ctx, "M117 "+msg)
+func (c *Client) ResumePrint(ctx context.Context) error {
+	return c.doOk(ctx, c.timeouts.Print, "POST", "/printer/print/resume", nil, nil)
+}
+
+func (c *Client) CancelPrint(ctx context.Context) error {
+	return c.doOk(ctx, c.timeouts.Print, "POST", "/printer/print/cancel", nil, nil)
+}
+
+func (c *Client) EmergencyStop(ctx context.Context) error {
+	return c.doOk(ctx, c.timeouts.Print, "POST", "/printer/emergency_stop", nil, nil)
+}
+
+// -----------
+// Run a GCode
+
+func (c *Client) RunGCode(ctx context.Context, script string) error {
+	query := url.Values{}
+	query.Set("script", script)
+
+	return c.doOk(ctx, c.timeouts.GCode, "GET", "/printer/gcode/script", query, nil)
+}
+
+func (c *Client) SetStatusMessage(ctx context.Context, msg string) error {
+	return c.RunGCode(ctx, "M117 "+msg)
+}
+
+// -----------------
+// Get G-code Store
+
+// GCodeStoreEntry is a line of the console history. Type is "command" or
+// "response".
+type GCodeStoreEntry struct {
+	Message string  `json:"message"`
+	Time    float64 `json:"time"`
+	Type    string  `json:"type"`
+}
+
+// GCodeStore returns up to count of the latest console lines, oldest first.
+func (c *Client) GCodeStore(ctx context.Context, count int) ([]GCodeStoreEntry, error) {
+	query := url.Values{}
+	query.Set("count", fmt.Sprintf("%d", count))
+
+	var out struct {
+		GCodeStore []GCodeStoreEntry `json:"gcode_store"`
+	}
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/gcode_store", query, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return out.GCodeStore, nil
 }
 
 // ------------------
@@ -397,41 +749,203 @@ type GCodeMetadata struct {
 	Filename       string  `json:"filename"`
 }
 
-type GetGCodeMetaResponse struct {
-	Result *GCodeMetadata `json:"result"`
-	Error  *APIError      `json:"error"`
-}
+func (c *Client) GetGcodeMetadata(ctx context.Context, fileName string) (*GCodeMetadata, error) {
+	query := url.Values{}
+	query.Set("filename", fileName)
 
-func GetGcodeMetadata(ctx context.Context, fileName string) (*GetGCodeMetaResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
+	out := new(GCodeMetadata)
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/files/metadata", query, nil, out); err != nil {
+		return nil, err
+	}
 
-	u := moonrakerAPIUrl.JoinPath("/server/files/metadata")
+	return out, nil
+}
 
-	query := u.Query()
-	query.Set("filename", fileName)
-	u.RawQuery = query.Encode()
+// --------------
+// Download files
+
+// DownloadFile copies a file from one of Moonraker's roots (e.g. "gcodes")
+// to w and returns its content type.
+func (c *Client) DownloadFile(ctx context.Context, root string, path string, w io.Writer) (string, error) {
+	u, err := c.FileURL(ctx, root, path)
+	if err != nil {
+		return "", err
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", ERRRespNotOk{
+			error:      fmt.Errorf("non-200 http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+		}
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("Content-Type"), nil
+}
+
+// ------------
+// Manage files
+
+// FileInfo describes a file in one of Moonraker's roots. Path is relative
+// to the root.
+type FileInfo struct {
+	Path        string  `json:"path"`
+	Modified    float64 `json:"modified"`
+	Size        int64   `json:"size"`
+	Permissions string  `json:"permissions"`
+}
+
+// ListFiles lists every file in root (e.g. "gcodes"), subdirectories
+// included.
+func (c *Client) ListFiles(ctx context.Context, root string) ([]FileInfo, error) {
+	query := url.Values{}
+	query.Set("root", root)
+
+	var out []FileInfo
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/files/list", query, nil, &out); err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	return out, nil
+}
+
+type uploadResult struct {
+	Item   FileInfo `json:"item"`
+	Action string   `json:"action"`
+}
+
+// UploadFile uploads r to path in root, replacing any file already there.
+// If r is an io.Seeker the upload can be retried after logging in again.
+func (c *Client) UploadFile(ctx context.Context, root string, filePath string, r io.Reader) (*FileInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeouts.Upload)
+	defer cancel()
+
+	dir, name := path.Split(filePath)
+
+	var written chan struct{}
+	body := requestBody(func() (io.Reader, string, error) {
+		if written != nil {
+			// The previous attempt's writer must be done with r.
+			<-written
+
+			seeker, ok := r.(io.Seeker)
+			if !ok {
+				return nil, "", errors.New("cannot rewind upload to retry it")
+			}
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, "", err
+			}
+		}
+
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+
+		written = make(chan struct{})
+		go func(written chan struct{}) {
+			defer close(written)
+
+			err := mw.WriteField("root", root)
+			if err == nil && dir != "" {
+				err = mw.WriteField("path", strings.TrimSuffix(dir, "/"))
+			}
+			if err == nil {
+				var part io.Writer
+				if part, err = mw.CreateFormFile("file", name); err == nil {
+					_, err = io.Copy(part, r)
+				}
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}(written)
+
+		return pr, mw.FormDataContentType(), nil
+	})
+
+	// Don't return while a writer still reads r.
+	defer func() {
+		if written != nil {
+			<-written
+		}
+	}()
+
+	resp, err := c.send(ctx, "POST", "/server/files/upload", nil, body)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
 
-	out := new(GetGCodeMetaResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
-	if err != nil {
+	// Moonraker answers uploads without the result envelope other
+	// endpoints use; accept both.
+	var out struct {
+		uploadResult
+		Result *uploadResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
 		return nil, err
 	}
 
-	return out, nil
+	if out.Result != nil {
+		return &out.Result.Item, nil
+	}
+
+	return &out.Item, nil
+}
+
+// DeleteFile deletes path from root.
+func (c *Client) DeleteFile(ctx context.Context, root string, filePath string) error {
+	return c.do(ctx, c.timeouts.Query, "DELETE", path.Join("/server/files", root, filePath), nil, nil, nil)
+}
+
+// --------------
+// Start a Print
+
+func (c *Client) StartPrint(ctx context.Context, fileName string) error {
+	query := url.Values{}
+	query.Set("filename", fileName)
+
+	return c.doOk(ctx, c.timeouts.Print, "POST", "/printer/print/start", query, nil)
+}
+
+// ------------
+// List Webcams
+
+type WebcamInfo struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Service string `json:"service"`
+	// StreamUrl and SnapshotUrl are often relative to the host Mainsail
+	// or Fluidd are served from.
+	StreamUrl   string `json:"stream_url"`
+	SnapshotUrl string `json:"snapshot_url"`
+}
+
+func (c *Client) ListWebcams(ctx context.Context) ([]WebcamInfo, error) {
+	var out struct {
+		Webcams []WebcamInfo `json:"webcams"`
+	}
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/webcams/list", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Webcams, nil
 }
 
 // ------------
@@ -440,22 +954,19 @@ func GetGcodeMetadata(ctx context.Context, fileName string) (*GetGCodeMetaRespon
 type Job struct {
 	JobId         string         `json:"job_id"`
 	Exists        bool           `json:"exists"`
-	StartTime     float32        `json:"start_time"`
-	EndTime       float32        `json:"end_time"`
-	TotalDuration float32        `json:"total_duration"`
-	PrintDuration float32        `json:"print_duration"`
-	FilamentUsed  float32        `json:"filament_used"`
+	StartTime     float64        `json:"start_time"`
+	EndTime       float64        `json:"end_time"`
+	TotalDuration float64        `json:"total_duration"`
+	PrintDuration float64        `json:"print_duration"`
+	FilamentUsed  float64        `json:"filament_used"`
 	Filename      string         `json:"filename"`
 	Metadata      *GCodeMetadata `json:"metadata"`
 	Status        string         `json:"status"`
 }
 
-type GetJobListResponse struct {
-	Result *struct {
-		Count int   `json:"count"`
-		Jobs  []Job `json:"jobs"`
-	} `json:"result"`
-	Error *APIError `json:"error"`
+type JobList struct {
+	Count int   `json:"count"`
+	Jobs  []Job `json:"jobs"`
 }
 
 type GetJobListOrder string
@@ -473,14 +984,8 @@ type GetJobListParams struct {
 	Order  GetJobListOrder
 }
 
-func GetJobList(ctx context.Context, params GetJobListParams) (*GetJobListResponse, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	moonrakerAPIUrl := ctx.Value("moonrakerAPIUrl").(*url.URL)
-
-	u := moonrakerAPIUrl.JoinPath("/server/history/list")
-
-	query := u.Query()
+func (c *Client) GetJobList(ctx context.Context, params GetJobListParams) (*JobList, error) {
+	query := url.Values{}
 	if params.Limit != nil {
 		query.Set("limit", fmt.Sprintf("%d", *params.Limit))
 	}
@@ -488,37 +993,40 @@ func GetJobList(ctx context.Context, params GetJobListParams) (*GetJobListRespon
 		query.Set("start", fmt.Sprintf("%d", *params.Start))
 	}
 	if params.Since != nil {
-		query.Set("since", fmt.Sprintf("%d", (*params.Since).UnixMilli()*1000))
+		query.Set("since", unixSeconds(*params.Since))
 	}
 	if params.Before != nil {
-		query.Set("before", fmt.Sprintf("%d", (*params.Before).UnixMilli()*1000))
+		query.Set("before", unixSeconds(*params.Before))
 	}
 	if params.Order == OrderAsc {
 		query.Set("order", "asc")
 	}
-	u.RawQuery = query.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
-	if err != nil {
+	out := new(JobList)
+	if err := c.do(ctx, c.timeouts.Query, "GET", "/server/history/list", query, nil, out); err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
+	return out, nil
+}
 
-	defer resp.Body.Close()
-	out := new(GetJobListResponse)
-	err = json.NewDecoder(resp.Body).Decode(out)
+// unixSeconds formats t the way Moonraker takes timestamps, in seconds.
+func unixSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+// GetLatestJob returns the most recent job in the history, or nil if it is
+// empty.
+func (c *Client) GetLatestJob(ctx context.Context) (*Job, error) {
+	limit := 1
+	list, err := c.GetJobList(ctx, GetJobListParams{Limit: &limit})
 	if err != nil {
 		return nil, err
 	}
 
-	return out, nil
-}
+	if len(list.Jobs) == 0 {
+		return nil, nil
+	}
 
-func GetLatestJob(ctx context.Context) (*GetJobListResponse, error) {
-	limit := 1
-	return GetJobList(ctx, GetJobListParams{Limit: &limit})
+	return &list.Jobs[0], nil
 }
@@ -0,0 +1,147 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var _ printer.Console = (*Monitor)(nil)
+
+// consoleBuffer is how far a console subscriber may fall behind before it
+// misses lines.
+const consoleBuffer = 64
+
+// gcodeStoreCount is how much of the console history RunGCode looks through
+// for the answer to its script.
+const gcodeStoreCount = 500
+
+// RunGCode runs script and picks what the printer answered out of the
+// console history. Moonraker answers the script itself with just "ok", but
+// it stores the script as a command ahead of Klipper's responses, which run
+// up to the next command. That is all that ties the responses to the script:
+// output Klipper prints unprompted in between, like a macro's RESPOND, is
+// returned with them, a command another client sends while the script runs
+// cuts them short, and nothing is returned once more than gcodeStoreCount
+// lines have followed the script.
+func (m *Monitor) RunGCode(ctx context.Context, script string) ([]string, error) {
+	before, err := m.client.GCodeStore(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	since := 0.0
+	if len(before) > 0 {
+		since = before[len(before)-1].Time
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(script), "\n") {
+		m.publishConsole(printer.ConsoleLine{
+			Time:    time.Now(),
+			Type:    printer.ConsoleCommand,
+			Message: strings.TrimSpace(line),
+		})
+	}
+
+	runErr := gcodeError(m.client.RunGCode(ctx, script))
+
+	var gcodeErr *printer.GCodeError
+	if runErr != nil && !errors.As(runErr, &gcodeErr) {
+		return nil, runErr
+	}
+
+	after, err := m.client.GCodeStore(ctx, gcodeStoreCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return scriptResponses(after, since, script), runErr
+}
+
+// scriptResponses picks the responses to the first run of script after since
+// out of entries.
+func scriptResponses(entries []GCodeStoreEntry, since float64, script string) []string {
+	script = strings.TrimSpace(script)
+
+	start := slices.IndexFunc(entries, func(entry GCodeStoreEntry) bool {
+		return entry.Time > since &&
+			entry.Type == string(printer.ConsoleCommand) &&
+			strings.TrimSpace(entry.Message) == script
+	})
+	if start < 0 {
+		return nil
+	}
+
+	var responses []string
+	for _, entry := range entries[start+1:] {
+		if entry.Type == string(printer.ConsoleCommand) {
+			break
+		}
+		if entry.Type == string(printer.ConsoleResponse) {
+			responses = append(responses, entry.Message)
+		}
+	}
+
+	return responses
+}
+
+// gcodeError turns Moonraker's answer to a script Klipper rejected into a
+// *printer.GCodeError.
+func gcodeError(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+		return &printer.GCodeError{Message: apiErr.Message}
+	}
+
+	return err
+}
+
+func (m *Monitor) ConsoleHistory(ctx context.Context, count int) ([]printer.ConsoleLine, error) {
+	entries, err := m.client.GCodeStore(ctx, count)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]printer.ConsoleLine, len(entries))
+	for i, entry := range entries {
+		lines[i] = printer.ConsoleLine{
+			Time:    unixTime(entry.Time),
+			Type:    printer.ConsoleLineType(entry.Type),
+			Message: entry.Message,
+		}
+	}
+
+	return lines, nil
+}
+
+// SubscribeConsole delivers the printer's responses while the websocket is
+// up, and the scripts RunGCode runs.
+func (m *Monitor) SubscribeConsole() (<-chan printer.ConsoleLine, func()) {
+	ch := make(chan printer.ConsoleLine, consoleBuffer)
+
+	m.consoleMu.Lock()
+	m.consoleSubs[ch] = struct{}{}
+	m.consoleMu.Unlock()
+
+	return ch, func() {
+		m.consoleMu.Lock()
+		delete(m.consoleSubs, ch)
+		m.consoleMu.Unlock()
+	}
+}
+
+func (m *Monitor) publishConsole(line printer.ConsoleLine) {
+	m.consoleMu.Lock()
+	defer m.consoleMu.Unlock()
+
+	for ch := range m.consoleSubs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
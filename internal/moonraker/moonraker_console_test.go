@@ -0,0 +1,65 @@
+package moonraker
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRunGCodeSkipsOtherClients(t *testing.T) {
+	f := newFakeMoonraker(t)
+	f.addGCode(
+		GCodeStoreEntry{Message: "M115", Type: "command"},
+		GCodeStoreEntry{Message: "FIRMWARE_NAME:Klipper", Type: "response"},
+	)
+	f.scriptOutput = func(script string) []GCodeStoreEntry {
+		return []GCodeStoreEntry{
+			{Message: "X:0.000 Y:0.000 Z:0.000", Type: "response"},
+			{Message: "M105", Type: "command"},
+			{Message: "ok T:21.0 /0.0 B:20.0 /0.0", Type: "response"},
+		}
+	}
+	m := newTestMonitor(t, f)
+
+	got, err := m.RunGCode(context.Background(), "M114")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"X:0.000 Y:0.000 Z:0.000"}; !slices.Equal(got, want) {
+		t.Errorf("RunGCode() = %q, want %q", got, want)
+	}
+}
+
+func TestScriptResponses(t *testing.T) {
+	entries := []GCodeStoreEntry{
+		{Message: "G28", Time: 1, Type: "command"},
+		{Message: "// homed", Time: 2, Type: "response"},
+		{Message: "M105", Time: 3, Type: "command"},
+		{Message: "ok T:21.0", Time: 4, Type: "response"},
+		{Message: "G28", Time: 5, Type: "command"},
+		{Message: "// homed again", Time: 6, Type: "response"},
+		{Message: "// probing", Time: 7, Type: "response"},
+		{Message: "M105", Time: 8, Type: "command"},
+		{Message: "ok T:22.0", Time: 9, Type: "response"},
+	}
+
+	tests := []struct {
+		name   string
+		since  float64
+		script string
+		want   []string
+	}{
+		{"first run", 0, "G28", []string{"// homed"}},
+		{"run after since", 2, "G28\n", []string{"// homed again", "// probing"}},
+		{"last entry", 7, "M105", []string{"ok T:22.0"}},
+		{"no longer stored", 8, "G28", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scriptResponses(entries, tt.since, tt.script); !slices.Equal(got, tt.want) {
+				t.Errorf("scriptResponses() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
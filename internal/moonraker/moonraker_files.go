@@ -0,0 +1,191 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// gcodesRoot is the Moonraker root print files are stored in.
+const gcodesRoot = "gcodes"
+
+// metadataRequests bounds the metadata requests Files sends at once.
+const metadataRequests = 4
+
+// cachedMetadata is the metadata of a file as it was last modified.
+type cachedMetadata struct {
+	modified float64
+	metadata *printer.FileMetadata
+}
+
+var _ printer.FileManager = (*Monitor)(nil)
+
+// Files lists the print files along with their metadata, which Moonraker
+// serves one file at a time; it is cached until a file changes.
+func (m *Monitor) Files(ctx context.Context) ([]printer.File, error) {
+	infos, err := m.client.ListFiles(ctx, gcodesRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	m.filesMu.Lock()
+	cache := m.fileMetadata
+	m.filesMu.Unlock()
+
+	files := make([]printer.File, len(infos))
+	fresh := make(map[string]cachedMetadata, len(infos))
+	var freshMu sync.Mutex
+
+	sem := make(chan struct{}, metadataRequests)
+	var wg sync.WaitGroup
+
+	for i, info := range infos {
+		files[i] = fileFrom(info)
+
+		if cached, ok := cache[info.Path]; ok && cached.modified == info.Modified {
+			files[i].Metadata = cached.metadata
+
+			freshMu.Lock()
+			fresh[info.Path] = cached
+			freshMu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			metadata, err := m.client.GetGcodeMetadata(ctx, info.Path)
+			if err != nil {
+				// Moonraker answers 404 until it has analysed the file.
+				if !errors.Is(fileError(err), printer.ErrFileNotFound) {
+					m.logger.Warnf("Failed to get metadata of %s: %s\n", info.Path, err)
+				}
+				return
+			}
+
+			files[i].Metadata = fileMetadataFrom(metadata)
+
+			freshMu.Lock()
+			fresh[info.Path] = cachedMetadata{modified: info.Modified, metadata: files[i].Metadata}
+			freshMu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	m.filesMu.Lock()
+	m.fileMetadata = fresh
+	m.filesMu.Unlock()
+
+	return files, nil
+}
+
+func (m *Monitor) UploadFile(ctx context.Context, path string, r io.Reader) (*printer.File, error) {
+	info, err := m.client.UploadFile(ctx, gcodesRoot, path, r)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("Uploaded %s on request\n", info.Path)
+
+	file := fileFrom(*info)
+	return &file, nil
+}
+
+func (m *Monitor) DeleteFile(ctx context.Context, path string) error {
+	if err := m.client.DeleteFile(ctx, gcodesRoot, path); err != nil {
+		return fileError(err)
+	}
+
+	m.logger.Infof("Deleted %s on request\n", path)
+	return nil
+}
+
+// StartPrint starts printing path, then waits for Moonraker to add the job
+// to its history, which it does as soon as Klipper starts printing, to
+// register it.
+func (m *Monitor) StartPrint(ctx context.Context, path string) (string, error) {
+	previous, err := m.client.GetLatestJob(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.client.StartPrint(ctx, path); err != nil {
+		return "", fileError(err)
+	}
+
+	m.logger.Infof("Started printing %s on request\n", path)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		job, err := m.client.GetLatestJob(ctx)
+		if err != nil {
+			m.logger.Errorf("Failed to get latest job: %s\n", err)
+		} else if job != nil && job.Filename == path && (previous == nil || job.JobId != previous.JobId) {
+			// Registered along with storing the job, so that a refresh
+			// or history change can't clear it in between.
+			m.mu.Lock()
+			m.setLatestJob(job)
+			m.enforcer.SetRegisteredJobId(nil, job.JobId)
+			m.mu.Unlock()
+
+			if err := m.enforcer.ClearMessage(ctx); err != nil {
+				m.logger.Errorf("Error clearing message: %s\n", err)
+			}
+			return job.JobId, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("print of %s started, but its job did not show up: %w", path, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// fileError turns Moonraker's 404 into printer.ErrFileNotFound.
+func fileError(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return printer.ErrFileNotFound
+	}
+
+	return err
+}
+
+func fileFrom(info FileInfo) printer.File {
+	return printer.File{
+		Path:     info.Path,
+		Size:     info.Size,
+		Modified: unixTime(info.Modified),
+	}
+}
+
+func fileMetadataFrom(metadata *GCodeMetadata) *printer.FileMetadata {
+	md := &printer.FileMetadata{
+		Slicer:        metadata.Slicer,
+		SlicerVersion: metadata.SlicerVersion,
+		LayerHeight:   float64(metadata.LayerHeight),
+		ObjectHeight:  float64(metadata.ObjectHeight),
+		FilamentTotal: float64(metadata.FilamentTotal),
+		HasThumbnail:  len(metadata.Thumbnails) > 0,
+	}
+
+	if metadata.EstimatedTime != nil {
+		estimated := printer.Seconds(*metadata.EstimatedTime)
+		md.EstimatedTime = &estimated
+	}
+
+	return md
+}
@@ -0,0 +1,45 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"context"
+	"testing"
+)
+
+func TestStartPrintDuringSlowHistoryRefresh(t *testing.T) {
+	f := newFakeMoonraker(t)
+	f.jobs = []Job{{JobId: "000001", Filename: "cube.gcode", Status: "completed"}}
+	hold := make(chan struct{})
+	f.holdHistory = hold
+
+	m := newTestMonitor(t, f)
+	m.state = printer.Ready
+	ctx := context.Background()
+
+	// The refresh gets the history from before the print.
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		m.refreshLatestJob(ctx)
+	}()
+	testutil.WaitFor(t, f.calls, "/server/history/list")
+
+	jobId, err := m.StartPrint(ctx, "benchy.gcode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobId != "000002" {
+		t.Fatalf("StartPrint() = %s, want the new job", jobId)
+	}
+
+	close(hold)
+	<-refreshed
+
+	if got := m.RegisteredJobId(); got != jobId {
+		t.Errorf("registration %q after the refresh, want %s", got, jobId)
+	}
+	if j := m.Job(); j == nil || j.JobId != jobId {
+		t.Errorf("job = %+v after the refresh, want %s", j, jobId)
+	}
+}
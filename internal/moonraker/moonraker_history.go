@@ -0,0 +1,91 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"strconv"
+)
+
+var _ printer.JobHistory = (*Monitor)(nil)
+
+// Jobs lists Moonraker's job history, newest first. A cursor is the start
+// time of the last job of the page before, so that jobs added since don't
+// shift the pages that follow.
+func (m *Monitor) Jobs(ctx context.Context, query printer.JobQuery) (*printer.JobPage, error) {
+	params := GetJobListParams{
+		Since:  query.Since,
+		Before: query.Before,
+		Order:  OrderDesc,
+	}
+
+	var cursor float64
+	if query.Cursor != "" {
+		var err error
+		cursor, err = strconv.ParseFloat(query.Cursor, 64)
+		if err != nil || cursor <= 0 {
+			return nil, printer.ErrInvalidCursor
+		}
+
+		if t := unixTime(cursor); params.Before == nil || t.Before(*params.Before) {
+			params.Before = &t
+		}
+	}
+
+	// Ask for one more job than the page holds, to tell whether there is a
+	// next page, and one more again for the job at the cursor, which before
+	// may let through (see below).
+	if query.Limit > 0 {
+		limit := query.Limit + 1
+		if cursor > 0 {
+			limit++
+		}
+		params.Limit = &limit
+	}
+
+	list, err := m.client.GetJobList(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &printer.JobPage{
+		Jobs: make([]printer.Job, 0, len(list.Jobs)),
+	}
+
+	var lastStart float64
+	for i := range list.Jobs {
+		job := &list.Jobs[i]
+
+		// before only has the precision of its query string, so it may
+		// let the job at the cursor through again.
+		if cursor > 0 && job.StartTime >= cursor {
+			continue
+		}
+
+		if query.Limit > 0 && len(page.Jobs) == query.Limit {
+			page.NextCursor = strconv.FormatFloat(lastStart, 'f', -1, 64)
+			break
+		}
+
+		page.Jobs = append(page.Jobs, *historyJobFrom(job))
+		lastStart = job.StartTime
+	}
+
+	return page, nil
+}
+
+// historyJobFrom is jobFrom along with the final durations and filament use
+// that Moonraker records for a job.
+func historyJobFrom(job *Job) *printer.Job {
+	j := jobFrom(job)
+
+	printDuration := printer.Seconds(job.PrintDuration)
+	j.PrintDuration = &printDuration
+
+	totalDuration := printer.Seconds(job.TotalDuration)
+	j.TotalDuration = &totalDuration
+
+	filamentUsed := job.FilamentUsed
+	j.FilamentUsed = &filamentUsed
+
+	return j
+}
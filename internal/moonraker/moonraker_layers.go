@@ -0,0 +1,78 @@
+package moonraker
+
+import "math"
+
+// Slicers that emit SET_PRINT_STATS_INFO report the layers in
+// print_stats.info. For files without it the layers are estimated from the
+// Z position and the layer and object heights in the file's metadata, the
+// way Mainsail does.
+
+// layersFrom returns the current and total layer, and the Z position, of
+// the job being printed. file is its metadata, if known.
+func layersFrom(objects *MonitorPrinterObjects, file *GCodeMetadata) (current, total *int, z *float64) {
+	if pos := objects.GCodeMove.GCodePosition; len(pos) >= 3 {
+		zPos := pos[2]
+		z = &zPos
+	}
+
+	current = objects.PrintStats.Info.CurrentLayer
+	total = objects.PrintStats.Info.TotalLayer
+
+	if file == nil || file.LayerHeight <= 0 {
+		return current, total, z
+	}
+
+	layerHeight := float64(file.LayerHeight)
+	firstLayerHeight := float64(file.FirstLayerHeight)
+	if firstLayerHeight <= 0 {
+		firstLayerHeight = layerHeight
+	}
+
+	if total == nil && file.ObjectHeight > 0 {
+		n := layerAt(float64(file.ObjectHeight), firstLayerHeight, layerHeight)
+		total = &n
+	}
+
+	if current == nil && z != nil {
+		// Z moves around before the first layer, e.g. while homing.
+		n := 0
+		if objects.PrintStats.PrintDuration > 0 {
+			n = layerAt(*z, firstLayerHeight, layerHeight)
+		}
+		if total != nil {
+			n = min(n, *total)
+		}
+		current = &n
+	}
+
+	return current, total, z
+}
+
+// layerAt is the layer printed at height z.
+func layerAt(z float64, firstLayerHeight float64, layerHeight float64) int {
+	// Allow for rounding, e.g. a Z of 0.6000000000000001 or heights read
+	// as float32.
+	n := math.Ceil((z-firstLayerHeight)/layerHeight + 1 - 1e-4)
+
+	return max(int(n), 0)
+}
+
+// layerHeight is the height of the top of layer, which unlike the nozzle's
+// Z doesn't follow Z-hops. It is nil without the file's layer height, or
+// before the first layer.
+func layerHeight(layer int, file *GCodeMetadata) *float64 {
+	if file == nil || file.LayerHeight <= 0 || layer < 1 {
+		return nil
+	}
+
+	firstLayerHeight := float64(file.FirstLayerHeight)
+	if firstLayerHeight <= 0 {
+		firstLayerHeight = float64(file.LayerHeight)
+	}
+
+	// Round off the float32 error of the heights, as layerAt allows for.
+	h := firstLayerHeight + float64(layer-1)*float64(file.LayerHeight)
+	h = math.Round(h*1e4) / 1e4
+
+	return &h
+}
@@ -3,13 +3,15 @@ package moonraker
 import (
 	"3dp-controller/internal/printer"
 	"3dp-controller/internal/util"
-	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"math"
 	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -17,34 +19,169 @@ import (
 
 var _ printer.Printer = (*Monitor)(nil)
 var _ printer.Thumbnailer = (*Monitor)(nil)
+var _ printer.TemperatureReporter = (*Monitor)(nil)
+var _ printer.JobController = (*Monitor)(nil)
 
 type MonitorPrinterObjects struct {
-	DisplayStatus PrinterObjectDisplayStatus
-	IdleTimeout   PrinterObjectIdleTimeout
-	PrintStats    PrinterObjectPrintStats
-	VirtualSDCard PrinterObjectVirtualSDCard
-	Webhooks      PrinterObjectWebhooks
+	DisplayStatus PrinterObjectDisplayStatus `json:"display_status"`
+	IdleTimeout   PrinterObjectIdleTimeout   `json:"idle_timeout"`
+	PrintStats    PrinterObjectPrintStats    `json:"print_stats"`
+	VirtualSDCard PrinterObjectVirtualSDCard `json:"virtual_sdcard"`
+	Webhooks      PrinterObjectWebhooks      `json:"webhooks"`
+	ExcludeObject PrinterObjectExcludeObject `json:"exclude_object"`
+	GCodeMove     PrinterObjectGCodeMove     `json:"gcode_move"`
+	// Fan is nil for printers without a part cooling fan.
+	Fan *PrinterObjectFan `json:"fan"`
 }
 
+// MapState maps Klipper's printer objects onto printer.PrinterState. Every
+// backend that reads Klipper's objects uses it, so they all report, and
+// therefore enforce, identically.
+func MapState(objects *MonitorPrinterObjects) printer.PrinterState {
+	if objects.Webhooks.State != "ready" {
+		switch objects.Webhooks.State {
+		case "startup":
+			return printer.Unknown
+		case "shutdown", "error", "disconnected":
+			return printer.Error
+		default:
+			return printer.Unknown
+		}
+	}
+
+	switch objects.PrintStats.State {
+	case "standby", "complete", "cancelled":
+		return printer.Ready
+	case "printing":
+		if objects.PrintStats.GetPrintDuration() > 0 {
+			return printer.Printing
+		}
+		return printer.PrePrint
+	case "paused":
+		return printer.Pause
+	case "error":
+		return printer.Error
+	default:
+		return printer.Unknown
+	}
+}
+
+// Observation returns what the printer.Enforcer needs to know about objects
+// in the given state. file is the metadata of the loaded file, if known, see
+// layersFrom; without it ZHeight is the nozzle's Z. The Enforcer should only
+// be given observations while Klippy is ready.
+func (o *MonitorPrinterObjects) Observation(state printer.PrinterState, file *GCodeMetadata) printer.Observation {
+	obs := printer.Observation{
+		State:          state,
+		PrintDuration:  o.PrintStats.GetPrintDuration(),
+		Progress:       o.VirtualSDCard.Progress,
+		DisplayMessage: o.DisplayStatus.Message,
+	}
+	obs.CurrentLayer, _, obs.ZHeight = layersFrom(o, file)
+	if obs.CurrentLayer != nil {
+		if h := layerHeight(*obs.CurrentLayer, file); h != nil {
+			obs.ZHeight = h
+		}
+	}
+
+	return obs
+}
+
+// Options are the moonraker backend's `options:`. Behind Moonraker's
+// [authorization] either an API key or a user's credentials are needed,
+// unless the controller is a trusted client.
+type Options struct {
+	ApiKey   util.Secret `yaml:"api_key"`
+	Username string      `yaml:"username"`
+	Password util.Secret `yaml:"password"`
+
+	// WebcamUrl is what relative webcam URLs are resolved against. It
+	// defaults to the printer URL without its port, where Mainsail's or
+	// Fluidd's nginx serves the webcams.
+	WebcamUrl string `yaml:"webcam_url"`
+}
+
+func (o *Options) Validate() error {
+	if o.ApiKey != "" && o.Username != "" {
+		return errors.New("api key and username are mutually exclusive")
+	}
+
+	if (o.Username == "") != (o.Password == "") {
+		return errors.New("username and password must be given together")
+	}
+
+	if o.WebcamUrl != "" {
+		if _, err := url.Parse(o.WebcamUrl); err != nil {
+			return fmt.Errorf("webcam_url: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *Options) clientOptions() []ClientOption {
+	switch {
+	case o.ApiKey != "":
+		return []ClientOption{WithAPIKey(string(o.ApiKey))}
+	case o.Username != "":
+		return []ClientOption{WithLogin(o.Username, string(o.Password))}
+	default:
+		return nil
+	}
+}
+
+// subscribedObjects are the printer objects MonitorPrinterObjects is built
+// from.
+var subscribedObjects = []string{
+	"webhooks", "print_stats", "idle_timeout", "display_status", "virtual_sdcard",
+	"exclude_object", "gcode_move", "fan",
+}
+
+// heatersObject lists the heaters and sensors to subscribe to as well.
+const heatersObject = "heaters"
+
 type Monitor struct {
 	printerName string
 	printerUrl  *url.URL
+	webcamUrl   *url.URL
+	client      *Client
 	logger      *zap.SugaredLogger
-	config      printer.MonitorConfig
-
-	registeredJobId    string
-	allowNoRegPrint    bool
-	jobPausedByMonitor bool
-	lastMessage        string
+	enforcer    *printer.Enforcer
 
+	mu             sync.RWMutex
 	state          printer.PrinterState
 	lastError      *printer.ErrorInfo
 	lastUpdateTime time.Time
 	printerObjects *MonitorPrinterObjects
 	hasLoadedFile  bool
 
-	latestJob  *Job
-	loadedFile *GCodeMetadata
+	latestJob *Job
+	// latestJobGen counts the changes of latestJob, so that a history
+	// fetch that started before one can tell that its job is older.
+	latestJobGen uint64
+	loadedFile   *GCodeMetadata
+
+	// sensors are the heaters' and sensors' object names.
+	sensors      []string
+	temperatures []printer.Temperature
+
+	filesMu      sync.Mutex
+	fileMetadata map[string]cachedMetadata
+
+	consoleMu   sync.Mutex
+	consoleSubs map[chan printer.ConsoleLine]struct{}
+
+	// systemInfo is refetched whenever the printer reconnects.
+	systemMu   sync.Mutex
+	systemInfo *printer.HostInfo
+
+	// report merges the websocket's status updates; nil while it is down.
+	report map[string]map[string]any
+
+	wsMu sync.Mutex
+	ws   *WSClient
+
+	statusCh chan struct{}
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
@@ -63,14 +200,20 @@ func (m *Monitor) PrinterType() string {
 }
 
 func (m *Monitor) Config() printer.MonitorConfig {
-	return m.config
+	return m.enforcer.Config()
 }
 
 func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.state
 }
 
 func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if m.printerObjects == nil {
 		return ""
 	}
@@ -83,6 +226,9 @@ func (m *Monitor) Message() string {
 }
 
 func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if m.state != printer.Error && m.state != printer.InternalError {
 		return nil
 	}
@@ -91,36 +237,22 @@ func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
 }
 
 func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	return m.lastUpdateTime
 }
 
 func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if m.latestJob == nil {
 		return nil
 	}
 
 	job := m.latestJob
-
-	j := &printer.Job{
-		JobId:  job.JobId,
-		Name:   job.Filename,
-		Status: job.Status,
-	}
-
-	if job.Metadata != nil {
-		j.ContentId = job.Metadata.UUID
-		j.HasThumbnail = len(job.Metadata.Thumbnails) > 0
-	}
-
-	if job.StartTime > 0 {
-		t := time.UnixMilli(int64(job.StartTime * 1000))
-		j.StartTime = &t
-	}
-
-	if job.EndTime > 0 {
-		t := time.UnixMilli(int64(job.EndTime * 1000))
-		j.EndTime = &t
-	}
+	j := jobFrom(job)
 
 	loadedFileMatchesJob := m.loadedFile != nil && job.Metadata != nil &&
 		m.loadedFile.UUID == job.Metadata.UUID
@@ -136,6 +268,11 @@ func (m *Monitor) Job() *printer.Job {
 		totalDuration := printer.Seconds(m.printerObjects.PrintStats.GetTotalDuration().Seconds())
 		j.TotalDuration = &totalDuration
 
+		filamentUsed := float64(m.printerObjects.PrintStats.FilamentUsed)
+		j.FilamentUsed = &filamentUsed
+
+		j.CurrentLayer, j.TotalLayer, j.ZHeight = layersFrom(m.printerObjects, m.loadedFile)
+
 		var estRemainSec float64
 		haveEstimate := false
 
@@ -162,44 +299,72 @@ func (m *Monitor) Job() *printer.Job {
 	return j
 }
 
+// jobFrom converts the identity/outcome fields of a Moonraker job.
+func jobFrom(job *Job) *printer.Job {
+	j := &printer.Job{
+		JobId:  job.JobId,
+		Name:   job.Filename,
+		Status: job.Status,
+	}
+
+	if job.Metadata != nil {
+		j.ContentId = job.Metadata.UUID
+		j.HasThumbnail = len(job.Metadata.Thumbnails) > 0
+	}
+
+	if job.StartTime > 0 {
+		t := unixTime(job.StartTime)
+		j.StartTime = &t
+	}
+
+	if job.EndTime > 0 {
+		t := unixTime(job.EndTime)
+		j.EndTime = &t
+	}
+
+	return j
+}
+
+// unixTime converts a Moonraker timestamp, in seconds.
+func unixTime(t float64) time.Time {
+	sec, frac := math.Modf(t)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+func (m *Monitor) Temperatures() []printer.Temperature {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.temperatures
+}
+
 func (m *Monitor) RegisteredJobId() string {
-	return m.registeredJobId
+	return m.enforcer.RegisteredJobId()
 }
 
 func (m *Monitor) AllowNoRegPrint() bool {
-	return m.allowNoRegPrint
+	return m.enforcer.AllowNoRegPrint()
 }
 
 func (m *Monitor) JobPausedByMonitor() bool {
-	return m.jobPausedByMonitor
+	return m.enforcer.JobPausedByMonitor()
 }
 
 func (m *Monitor) SetRegisteredJobId(jobId string) {
-	m.registeredJobId = jobId
-
-	if m.ctx != nil && jobId != "" {
-		err := m.clearMessage(m.ctx)
-
-		if err != nil {
-			m.logger.Errorf("Error clearing message: %s\n", err)
-		}
-	}
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
 }
 
 func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
-	m.allowNoRegPrint = allowNoRegPrint
-
-	if m.ctx != nil && allowNoRegPrint {
-		err := m.clearMessage(m.ctx)
-		if err != nil {
-			m.logger.Errorf("Error clearing message: %s\n", err)
-		}
-	}
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
 }
 
-func NewMonitor(name string, printerURL string, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
 	m := new(Monitor)
 
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
 	u, err := url.Parse(printerURL)
 	if err != nil {
 		return nil, err
@@ -207,31 +372,33 @@ func NewMonitor(name string, printerURL string, config printer.MonitorConfig, lo
 
 	m.printerName = name
 	m.printerUrl = u
+	m.webcamUrl = webcamUrl(u, options.WebcamUrl)
 	m.logger = logger
-	m.config = config
-
-	m.registeredJobId = ""
-	m.allowNoRegPrint = true
-	m.jobPausedByMonitor = false
+	m.client = NewClient(u, options.clientOptions()...)
+	m.enforcer = printer.NewEnforcer(config, m, logger)
 
 	m.state = printer.Disconnected
 	m.lastUpdateTime = time.Now()
 	m.hasLoadedFile = false
+	m.statusCh = make(chan struct{}, 1)
+	m.consoleSubs = make(map[chan printer.ConsoleLine]struct{})
 
 	return m, nil
 }
 
+// Start follows the printer over Moonraker's websocket, and polls the HTTP
+// API instead while the websocket is down.
 func (m *Monitor) Start(ctx context.Context) {
 	if m.ctx != nil {
 		return
 	}
 
-	ctx = context.WithValue(ctx, "moonrakerAPIUrl", m.printerUrl)
-
 	ctx, cancel := context.WithCancel(ctx)
 	m.ctx = ctx
 	m.cancelFunc = cancel
 
+	go m.connectLoop(ctx)
+
 	ticker1Duration := 2 * time.Second
 	ticker1 := time.NewTicker(ticker1Duration)
 
@@ -239,15 +406,21 @@ func (m *Monitor) Start(ctx context.Context) {
 	ticker2 := time.NewTicker(ticker2Duration)
 
 	go func() {
-		m.update()
+		m.update(ctx)
 
 		for {
 			select {
 			case <-ctx.Done():
 				ticker1.Stop()
 				return
+			case <-m.statusCh:
+				m.enforce(ctx)
 			case <-ticker1.C:
-				m.update()
+				if m.currentWS() == nil {
+					m.update(ctx)
+				} else {
+					m.enforce(ctx)
+				}
 			}
 		}
 	}()
@@ -259,52 +432,12 @@ func (m *Monitor) Start(ctx context.Context) {
 				ticker2.Stop()
 				return
 			case <-ticker2.C:
-				// Update latest job
-				go func() {
-					if m.state == printer.Disconnected || m.state == printer.InternalError {
-						m.latestJob = nil
-						return
-					}
-
-					ctx2, cancel2 := context.WithTimeout(ctx, ticker2Duration)
-					defer cancel2()
-
-					job, err := m.getLatestJob(ctx2)
-					if err != nil {
-						m.logger.Errorf("Failed to get latest job: %s\n", err)
-						return
-					}
-					m.latestJob = job
-
-					if m.latestJob == nil {
-						m.logger.Warnln("No latest job found")
-						return
-					}
-
-					// Clear registeredJobId if job is not in_progress, or jobId not match
-					if m.latestJob.Status != "in_progress" || m.latestJob.JobId != m.registeredJobId {
-						m.registeredJobId = ""
-					}
-				}()
-
-				// Update loaded file
-				go func() {
-					if m.state == printer.Disconnected || m.state == printer.InternalError {
-						m.loadedFile = nil
-						return
-					}
-
-					ctx2, cancel2 := context.WithTimeout(ctx, ticker2Duration)
-					defer cancel2()
-
-					metadata, err := m.getLoadedFile(ctx2)
-					if err != nil {
-						m.logger.Errorf("Failed to get loaded file: %s\n", err)
-						return
-					}
-
-					m.loadedFile = metadata
-				}()
+				if m.currentWS() != nil {
+					continue
+				}
+
+				go m.refreshLatestJob(ctx)
+				go m.refreshLoadedFile(ctx)
 			}
 		}
 	}()
@@ -319,261 +452,546 @@ func (m *Monitor) Stop() {
 	}
 }
 
-func (m *Monitor) update() {
-	printerObjectsResponse, err := GetPrinterObjects(m.ctx)
+// update polls the printer objects over HTTP.
+func (m *Monitor) update(ctx context.Context) {
+	report, err := m.client.QueryObjects(ctx, m.objectNames()...)
+
+	var objects *MonitorPrinterObjects
+	if err == nil {
+		objects, err = decodeObjects(report)
+	}
+
+	if m.currentWS() != nil {
+		// The websocket came up meanwhile and is more recent.
+		return
+	}
 
+	m.mu.Lock()
+
+	prevState := m.state
 	m.lastUpdateTime = time.Now()
 
 	if err != nil {
 		m.printerObjects = nil
 		m.hasLoadedFile = false
+		m.temperatures = nil
 
 		var nonOkErr ERRRespNotOk
+		var apiErr *APIError
 		if util.IsErrNetworkProblem(err) {
 			m.state = printer.Disconnected
 			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == 502 {
+			m.state = printer.Disconnected
+			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) &&
+			(nonOkErr.RespStatusCode() == 401 || nonOkErr.RespStatusCode() == 403) {
+			m.state = printer.InternalError
+			code := nonOkErr.RespStatusCode()
+			m.lastError = &printer.ErrorInfo{Code: &code, Message: "authorization failed: " + err.Error()}
+			m.logger.Warnf("Moonraker rejected our credentials: %s\n", err)
+		} else if errors.As(err, &apiErr) {
+			// Moonraker is up but cannot answer, e.g. Klippy is not
+			// connected.
+			m.setAPIError(apiErr)
 		} else if errors.As(err, &nonOkErr) {
-			if nonOkErr.RespStatusCode() == 502 {
-				m.state = printer.Disconnected
-				m.lastError = nil
-			} else {
-				m.state = printer.InternalError
-				code := nonOkErr.RespStatusCode()
-				m.lastError = &printer.ErrorInfo{Code: &code, Message: err.Error()}
-				m.logger.Warnf(
-					"Failed to get printer objects: %s, status_code: %d\n", err, nonOkErr.RespStatusCode(),
-				)
-			}
+			m.state = printer.InternalError
+			code := nonOkErr.RespStatusCode()
+			m.lastError = &printer.ErrorInfo{Code: &code, Message: err.Error()}
+			m.logger.Warnf(
+				"Failed to get printer objects: %s, status_code: %d\n", err, nonOkErr.RespStatusCode(),
+			)
 		} else {
 			m.state = printer.InternalError
 			m.lastError = &printer.ErrorInfo{Message: err.Error()}
 			m.logger.Errorf("Error getting printer objects: %s\n", err)
 		}
 	} else {
-		if printerObjectsResponse.Result.Status == nil {
-			m.state = printer.Error
-			m.hasLoadedFile = false
+		m.setObjects(objects)
+		m.setTemperatures(report)
+	}
+	//m.logger.Debugf("Status: %s\n", m.state)
 
-			code := printerObjectsResponse.Error.Code
-			m.lastError = &printer.ErrorInfo{Code: &code, Message: printerObjectsResponse.Error.Message}
+	reconnected := isOffline(prevState) && !isOffline(m.state)
 
-			m.logger.Errorf("MoonrakerError: %d %s\n",
-				printerObjectsResponse.Error.Code, printerObjectsResponse.Error.Message)
-		} else {
-			m.lastError = nil
+	m.mu.Unlock()
 
-			status := printerObjectsResponse.Result.Status
+	if reconnected {
+		go m.updateSystemInfo(ctx)
+	}
 
-			printerObjects := new(MonitorPrinterObjects)
-			m.printerObjects = printerObjects
+	m.enforce(ctx)
+}
 
-			printerObjects.DisplayStatus = status.DisplayStatus
-			printerObjects.IdleTimeout = status.IdleTimeout
-			printerObjects.PrintStats = status.PrintStats
-			printerObjects.VirtualSDCard = status.VirtualSDCard
-			printerObjects.Webhooks = status.Webhooks
+// isOffline tells whether Moonraker cannot be talked to in state.
+func isOffline(state printer.PrinterState) bool {
+	return state == printer.Disconnected || state == printer.InternalError
+}
 
-			if status.Webhooks.State != "ready" {
-				m.hasLoadedFile = false
+// objectNames are the printer objects to query or subscribe to.
+func (m *Monitor) objectNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-				switch status.Webhooks.State {
-				case "startup":
-					m.state = printer.Unknown
-				case "shutdown", "error", "disconnected":
-					m.state = printer.Error
-				default:
-					m.state = printer.Unknown
-				}
-			} else {
-				printerShouldPrint := m.allowNoRegPrint || m.registeredJobId != ""
-				printDuration := printerObjects.PrintStats.GetPrintDuration()
-
-				switch printerObjects.PrintStats.State {
-				case "standby", "complete", "cancelled":
-					m.state = printer.Ready
-				case "printing":
-					if printDuration > 0 {
-						m.state = printer.Printing
-					} else {
-						m.state = printer.PrePrint
-					}
-				case "paused":
-					m.state = printer.Pause
-				case "error":
-					m.state = printer.Error
-				default:
-					m.state = printer.Unknown
-				}
+	names := append([]string{heatersObject}, subscribedObjects...)
+	return append(names, m.sensors...)
+}
 
-				m.hasLoadedFile = printerObjects.PrintStats.State != "standby" &&
-					m.state != printer.Error && m.state != printer.Unknown
-
-				// Check if printer is illegally printing
-				if m.state == printer.Printing && !printerShouldPrint {
-					m.logger.Infoln("Printer should not print now!!")
-
-					progress := printerObjects.VirtualSDCard.Progress
-
-					if printDuration > m.config.NoPauseDuration ||
-						(m.config.ShouldPauseProgress > 0 && progress >= m.config.ShouldPauseProgress) {
-						m.jobPausedByMonitor = true
-					}
-
-					if m.config.ShouldCancelProgress > 0 && progress >= m.config.ShouldCancelProgress {
-						if m.state == printer.Printing {
-							m.logger.Infoln("Canceling")
-							err := CancelPrint(m.ctx)
-							if err != nil {
-								m.logger.Errorf("Failed to cancel printing: %s\n", err)
-							}
-						}
-					}
-				}
+// setTemperatures reads the heaters and sensors from a report. Ones the
+// heaters object lists for the first time are only in the next report. It
+// must be called with m.mu held.
+func (m *Monitor) setTemperatures(report map[string]map[string]any) {
+	m.sensors = availableSensors(report[heatersObject])
+	m.temperatures = temperaturesFrom(report, m.sensors)
+}
 
-				// Pause printer if printer should be paused by monitor
-				if m.state == printer.Printing && m.jobPausedByMonitor {
-					m.logger.Infoln("Pausing")
-
-					err := PausePrint(m.ctx)
-					if err != nil {
-						m.logger.Errorf("Error pausing the printer: %s\n", err)
-					}
-
-					data := struct {
-					}{}
-
-					var tpl bytes.Buffer
-					if err := m.config.PauseMessage.Execute(&tpl, data); err != nil {
-						m.logger.Errorf("Error pausing the pause message: %s\n", err)
-					} else {
-						err := m.updateStatusMessage(m.ctx, tpl.String())
-						if err != nil {
-							m.logger.Errorln(err)
-						}
-					}
-				}
+// setObjects takes in a new snapshot of the printer objects. It reports
+// whether the loaded file may have changed. It must be called with m.mu
+// held.
+func (m *Monitor) setObjects(objects *MonitorPrinterObjects) bool {
+	prev, prevHasLoadedFile := m.printerObjects, m.hasLoadedFile
 
-				// Show warning countdown if printer will be paused
-				if m.state == printer.Printing && !m.jobPausedByMonitor && !printerShouldPrint {
-					remDuration := (m.config.NoPauseDuration - printDuration).Round(time.Second)
-
-					data := struct {
-						RemainDurationStr string
-					}{remDuration.String()}
-
-					var tpl bytes.Buffer
-					if err := m.config.WillPauseMessage.Execute(&tpl, data); err != nil {
-						m.logger.Errorf("Error pausing the will pause message: %s\n", err)
-					} else {
-						err := m.updateStatusMessage(m.ctx, tpl.String())
-						if err != nil {
-							m.logger.Errorln(err)
-						}
-					}
-				} else {
-					// TODO: clear will pause message
-				}
+	m.lastUpdateTime = time.Now()
+	m.lastError = nil
+	m.printerObjects = objects
+	m.state = MapState(objects)
+
+	if objects.Webhooks.State != "ready" {
+		m.hasLoadedFile = false
+	} else {
+		m.hasLoadedFile = objects.PrintStats.State != "standby" &&
+			m.state != printer.Error && m.state != printer.Unknown
+	}
 
-				// Resume print if allow print set to true
-				if m.jobPausedByMonitor && printerShouldPrint {
+	return prev == nil || prevHasLoadedFile != m.hasLoadedFile ||
+		prev.PrintStats.FileName != objects.PrintStats.FileName
+}
 
-					if m.state == printer.Pause {
-						m.logger.Infoln("Resuming")
+// setAPIError records an error Moonraker answered a status query with,
+// e.g. while Klippy is not connected. It must be called with m.mu held.
+func (m *Monitor) setAPIError(apiErr *APIError) {
+	m.lastUpdateTime = time.Now()
+	m.state = printer.Error
+	m.hasLoadedFile = false
+
+	code := apiErr.Code
+	m.lastError = &printer.ErrorInfo{Code: &code, Message: apiErr.Message}
 
-						err := ResumePrint(m.ctx)
-						if err != nil {
-							m.logger.Errorf("Error resuming the printer: %s\n", err)
-						}
+	m.logger.Errorf("MoonrakerError: %d %s\n", apiErr.Code, apiErr.Message)
+}
 
-						err = m.clearMessage(m.ctx)
-						if err != nil {
-							m.logger.Errorln(err)
-						}
-					}
+// enforce runs the Enforcer on the latest printer objects.
+func (m *Monitor) enforce(ctx context.Context) {
+	m.mu.RLock()
+	objects := m.printerObjects
+	state := m.state
+	file := m.loadedFile
+	m.mu.RUnlock()
 
-					m.jobPausedByMonitor = false
-				}
+	if objects == nil || objects.Webhooks.State != "ready" {
+		return
+	}
+
+	m.enforcer.Enforce(ctx, objects.Observation(state, file))
+}
+
+// ---------
+// Websocket
+
+func (m *Monitor) currentWS() *WSClient {
+	m.wsMu.Lock()
+	defer m.wsMu.Unlock()
+
+	return m.ws
+}
+
+// connectLoop keeps the websocket open, reconnecting with backoff. The HTTP
+// tickers take over while it is down.
+func (m *Monitor) connectLoop(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		connectedAt := time.Now()
+
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.logger.Warnf("Moonraker websocket closed, polling instead: %s\n", err)
+
+		if time.Since(connectedAt) > 30*time.Second {
+			backoff = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// serve runs one websocket connection until it fails.
+func (m *Monitor) serve(ctx context.Context) error {
+	klippyReady := make(chan struct{}, 1)
+
+	wsUrl, err := m.client.WebSocketURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := DialWS(ctx, wsUrl, func(method string, params json.RawMessage) {
+		if method == "notify_klippy_ready" {
+			select {
+			case klippyReady <- struct{}{}:
+			default:
 			}
+			return
+		}
+
+		m.handleNotification(ctx, method, params)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		m.wsMu.Lock()
+		m.ws = nil
+		m.wsMu.Unlock()
+
+		m.mu.Lock()
+		m.report = nil
+		m.mu.Unlock()
+
+		_ = client.Close()
+	}()
+
+	if err := m.subscribe(ctx, client); err != nil {
+		return err
+	}
+
+	m.wsMu.Lock()
+	m.ws = client
+	m.wsMu.Unlock()
+
+	go m.refreshLatestJob(ctx)
+	go m.updateSystemInfo(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-client.Done():
+			return client.Err()
+		case <-klippyReady:
+			m.logger.Infoln("Klippy ready, renewing subscription")
+
+			if err := m.subscribe(ctx, client); err != nil {
+				return err
+			}
+
+			// Klipper may have been updated before it restarted.
+			go m.updateSystemInfo(ctx)
 		}
 	}
-	//m.logger.Debugf("Status: %s\n", m.state)
 }
 
-func (m *Monitor) updateStatusMessage(ctx context.Context, msg string) error {
-	if m.printerObjects.DisplayStatus.Message == msg {
+// subscribe (re)subscribes to the printer objects and replaces the report
+// with their current status. An error from Moonraker itself, e.g. because
+// Klippy is not connected, becomes the printer's state until Klippy is
+// ready; only transport errors are returned.
+func (m *Monitor) subscribe(ctx context.Context, client *WSClient) error {
+	names := m.objectNames()
+	result, err := client.Subscribe(ctx, names...)
+
+	if err == nil {
+		m.mu.Lock()
+		m.setTemperatures(result.Status)
+		m.mu.Unlock()
+
+		if more := m.objectNames(); !slices.Equal(more, names) {
+			// Now that the heaters object named them, subscribe to the
+			// heaters and sensors as well.
+			result, err = client.Subscribe(ctx, more...)
+		}
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		m.mu.Lock()
+		m.report = make(map[string]map[string]any)
+		m.printerObjects = nil
+		m.temperatures = nil
+		m.setAPIError(apiErr)
+		m.mu.Unlock()
+
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
-	m.lastMessage = msg
+	m.mu.Lock()
+	m.report = make(map[string]map[string]any)
+	m.mu.Unlock()
+
+	m.mergeStatus(ctx, result.Status)
+
+	return nil
+}
+
+func (m *Monitor) handleNotification(ctx context.Context, method string, params json.RawMessage) {
+	switch method {
+	case "notify_status_update":
+		status, err := ParseStatusUpdate(params)
+		if err != nil {
+			m.logger.Warnf("Failed to decode status update: %s\n", err)
+			return
+		}
+
+		m.mergeStatus(ctx, status)
+	case "notify_history_changed":
+		change, err := ParseHistoryChange(params)
+		if err != nil {
+			m.logger.Warnf("Failed to decode history change: %s\n", err)
+			return
+		}
+
+		m.mu.Lock()
+		// A finished job only replaces the latest job if it is that job.
+		if change.Action == "added" || m.latestJob == nil || m.latestJob.JobId == change.Job.JobId {
+			m.setLatestJob(&change.Job)
+		}
+		m.clearStaleRegistration()
+		m.mu.Unlock()
+	case "notify_gcode_response":
+		line, err := ParseGCodeResponse(params)
+		if err != nil {
+			m.logger.Warnf("Failed to decode gcode response: %s\n", err)
+			return
+		}
 
-	return SetStatusMessage(ctx, msg)
+		m.publishConsole(printer.ConsoleLine{
+			Time:    time.Now(),
+			Type:    printer.ConsoleResponse,
+			Message: line,
+		})
+	case "notify_klippy_shutdown":
+		m.mergeStatus(ctx, map[string]map[string]any{
+			"webhooks": {"state": "shutdown"},
+		})
+	case "notify_klippy_disconnected":
+		m.mergeStatus(ctx, map[string]map[string]any{
+			"webhooks": {"state": "disconnected", "state_message": "Klippy disconnected"},
+		})
+	}
 }
 
-func (m *Monitor) clearMessage(ctx context.Context) error {
-	if m.lastMessage == m.printerObjects.DisplayStatus.Message {
-		return m.updateStatusMessage(ctx, "")
+// mergeStatus merges changed fields into the report and recomputes the
+// state from it.
+func (m *Monitor) mergeStatus(ctx context.Context, status map[string]map[string]any) {
+	m.mu.Lock()
+
+	if m.report == nil {
+		m.mu.Unlock()
+		return
 	}
 
-	return nil
+	for name, fields := range status {
+		obj, ok := m.report[name]
+		if !ok {
+			obj = make(map[string]any)
+			m.report[name] = obj
+		}
+		for k, v := range fields {
+			obj[k] = v
+		}
+	}
+
+	objects, err := decodeObjects(m.report)
+	if err != nil {
+		m.mu.Unlock()
+		m.logger.Warnf("Failed to decode printer objects: %s\n", err)
+		return
+	}
+
+	fileChanged := m.setObjects(objects)
+	m.setTemperatures(m.report)
+	m.mu.Unlock()
+
+	if fileChanged {
+		go m.refreshLoadedFile(ctx)
+	}
+
+	select {
+	case m.statusCh <- struct{}{}:
+	default:
+	}
 }
 
-func (m *Monitor) getLatestJob(ctx context.Context) (*Job, error) {
-	resp, err := GetLatestJob(ctx)
+func decodeObjects(report map[string]map[string]any) (*MonitorPrinterObjects, error) {
+	b, err := json.Marshal(report)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.Error != nil {
-		return nil, fmt.Errorf("api response %d %s", resp.Error.Code, resp.Error.Message)
+	out := new(MonitorPrinterObjects)
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
 	}
 
-	if len(resp.Result.Jobs) == 0 {
-		return nil, nil
+	return out, nil
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	return m.client.PausePrint(ctx)
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	return m.client.ResumePrint(ctx)
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	return m.client.CancelPrint(ctx)
+}
+
+func (m *Monitor) SetStatusMessage(ctx context.Context, msg string) error {
+	return m.client.SetStatusMessage(ctx, msg)
+}
+
+// Pause, Resume, Cancel and EmergencyStop implement printer.JobController.
+
+func (m *Monitor) Pause(ctx context.Context) error {
+	m.logger.Infoln("Pausing on request")
+	return m.client.PausePrint(ctx)
+}
+
+func (m *Monitor) Resume(ctx context.Context) error {
+	if err := m.enforcer.CheckManualResume(); err != nil {
+		return err
 	}
 
-	return &(resp.Result.Jobs[0]), nil
+	m.logger.Infoln("Resuming on request")
+	return m.client.ResumePrint(ctx)
 }
 
-func (m *Monitor) getLoadedFile(ctx context.Context) (*GCodeMetadata, error) {
-	if !m.hasLoadedFile {
-		return nil, nil
+func (m *Monitor) Cancel(ctx context.Context) error {
+	m.logger.Infoln("Canceling on request")
+	return m.client.CancelPrint(ctx)
+}
+
+func (m *Monitor) EmergencyStop(ctx context.Context) error {
+	m.logger.Warnln("Emergency stop on request")
+	return m.client.EmergencyStop(ctx)
+}
+
+// refreshLatestJob fetches the latest job from the history. A job stored
+// while it waits for the history, e.g. by StartPrint, is newer than the one
+// it gets and is kept.
+func (m *Monitor) refreshLatestJob(ctx context.Context) {
+	m.mu.Lock()
+	if isOffline(m.state) {
+		m.setLatestJob(nil)
+		m.mu.Unlock()
+		return
 	}
+	gen := m.latestJobGen
+	m.mu.Unlock()
 
-	metaResponse, err := GetGcodeMetadata(ctx, m.printerObjects.PrintStats.FileName)
+	job, err := m.client.GetLatestJob(ctx)
 	if err != nil {
-		return nil, err
+		m.logger.Errorf("Failed to get latest job: %s\n", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.latestJobGen != gen {
+		return
 	}
 
-	if metaResponse.Error != nil {
-		return nil, fmt.Errorf("api response %d %s", metaResponse.Error.Code, metaResponse.Error.Message)
+	m.setLatestJob(job)
+
+	if job == nil {
+		m.logger.Warnln("No latest job found")
+		return
 	}
 
-	return metaResponse.Result, nil
+	m.clearStaleRegistration()
 }
 
-func (m *Monitor) LatestThumbnail(ctx context.Context, w io.Writer) (string, error) {
-	if m.latestJob == nil || m.latestJob.Metadata == nil || len(m.latestJob.Metadata.Thumbnails) == 0 {
-		return "", printer.ErrNoThumbnail
+// setLatestJob must be called with m.mu held.
+func (m *Monitor) setLatestJob(job *Job) {
+	m.latestJob = job
+	m.latestJobGen++
+}
+
+// clearStaleRegistration clears the registered job id if the latest job is
+// not in_progress, or its id does not match. It must be called with m.mu
+// held, so that the latest job can't change before the registration is
+// checked against it.
+func (m *Monitor) clearStaleRegistration() {
+	activeJobId := ""
+	if m.latestJob != nil && m.latestJob.Status == "in_progress" {
+		activeJobId = m.latestJob.JobId
 	}
 
-	thumb := m.latestJob.Metadata.Thumbnails[len(m.latestJob.Metadata.Thumbnails)-1]
+	m.enforcer.ClearStaleRegistration(activeJobId)
+}
 
-	u := m.printerUrl.JoinPath("/server/files/gcodes").JoinPath(thumb.RelativePath)
+// refreshLoadedFile fetches the metadata of the file print_stats names.
+func (m *Monitor) refreshLoadedFile(ctx context.Context) {
+	if isOffline(m.State()) {
+		m.mu.Lock()
+		m.loadedFile = nil
+		m.mu.Unlock()
+		return
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	metadata, err := m.getLoadedFile(ctx)
 	if err != nil {
-		return "", err
+		m.logger.Errorf("Failed to get loaded file: %s\n", err)
+		return
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+	m.mu.Lock()
+	m.loadedFile = metadata
+	m.mu.Unlock()
+}
+
+func (m *Monitor) getLoadedFile(ctx context.Context) (*GCodeMetadata, error) {
+	m.mu.RLock()
+	hasLoadedFile := m.hasLoadedFile
+	fileName := ""
+	if m.printerObjects != nil {
+		fileName = m.printerObjects.PrintStats.FileName
 	}
-	defer resp.Body.Close()
+	m.mu.RUnlock()
 
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		return "", err
+	if !hasLoadedFile {
+		return nil, nil
 	}
 
-	return resp.Header.Get("Content-Type"), nil
+	return m.client.GetGcodeMetadata(ctx, fileName)
+}
+
+func (m *Monitor) LatestThumbnail(ctx context.Context, w io.Writer) (string, error) {
+	m.mu.RLock()
+	latestJob := m.latestJob
+	m.mu.RUnlock()
+
+	if latestJob == nil || latestJob.Metadata == nil || len(latestJob.Metadata.Thumbnails) == 0 {
+		return "", printer.ErrNoThumbnail
+	}
+
+	thumb := latestJob.Metadata.Thumbnails[len(latestJob.Metadata.Thumbnails)-1]
+
+	return m.client.DownloadFile(ctx, "gcodes", thumb.RelativePath, w)
 }
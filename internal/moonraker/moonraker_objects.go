@@ -0,0 +1,88 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"slices"
+	"strings"
+)
+
+var _ printer.ObjectExcluder = (*Monitor)(nil)
+
+// Klipper's exclude_object object lists the parts the slicer defined with
+// EXCLUDE_OBJECT_DEFINE at the start of the file. Klipper uppercases their
+// names.
+
+func (m *Monitor) PrintObjects() *printer.PrintObjects {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.printerObjects == nil || len(m.printerObjects.ExcludeObject.Objects) == 0 {
+		return nil
+	}
+
+	eo := m.printerObjects.ExcludeObject
+
+	objects := &printer.PrintObjects{
+		Objects:  make([]printer.PrintObject, len(eo.Objects)),
+		Excluded: slices.Clone(eo.ExcludedObjects),
+	}
+
+	for i, o := range eo.Objects {
+		objects.Objects[i] = printer.PrintObject{
+			Name:    o.Name,
+			Center:  o.Center,
+			Polygon: o.Polygon,
+		}
+	}
+
+	if objects.Excluded == nil {
+		objects.Excluded = make([]string, 0)
+	}
+
+	if eo.CurrentObject != nil {
+		objects.Current = *eo.CurrentObject
+	}
+
+	return objects
+}
+
+func (m *Monitor) ExcludeObject(ctx context.Context, name string) error {
+	objects := m.PrintObjects()
+	if objects == nil {
+		return printer.ErrNoObject
+	}
+
+	i := slices.IndexFunc(objects.Objects, func(o printer.PrintObject) bool {
+		return strings.EqualFold(o.Name, name)
+	})
+	if i < 0 {
+		return printer.ErrNoObject
+	}
+	name = objects.Objects[i].Name
+
+	if slices.Contains(objects.Excluded, name) {
+		return nil
+	}
+
+	quoted, ok := quoteParam(name)
+	if !ok {
+		return &printer.GCodeError{Message: "object name " + name + " cannot be passed to EXCLUDE_OBJECT"}
+	}
+
+	m.logger.Infof("Excluding object %s on request\n", name)
+
+	return gcodeError(m.client.RunGCode(ctx, "EXCLUDE_OBJECT NAME="+quoted))
+}
+
+// quoteParam quotes value for a parameter of an extended G-code command.
+// Klipper splits the parameters like a shell, so the double quotes keep spaces
+// and '=' in the value, but it cuts everything from '#', '*' or ';' as a
+// comment, quoted or not; such values can't be passed.
+func quoteParam(value string) (string, bool) {
+	if strings.ContainsAny(value, "#*;\r\n") {
+		return "", false
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`, true
+}
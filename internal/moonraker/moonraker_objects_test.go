@@ -0,0 +1,59 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQuoteParam(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"CUBE", `"CUBE"`, true},
+		{"PART 1", `"PART 1"`, true},
+		{"A=B", `"A=B"`, true},
+		{`SAY "HI"`, `"SAY \"HI\""`, true},
+		{`C:\PARTS`, `"C:\\PARTS"`, true},
+		{"PART#2", "", false},
+		{"PART;2", "", false},
+		{"PART*2", "", false},
+		{"PART\n2", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := quoteParam(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("quoteParam(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExcludeObjectQuotesName(t *testing.T) {
+	f := newFakeMoonraker(t)
+	m := newTestMonitor(t, f)
+	m.printerObjects = &MonitorPrinterObjects{
+		ExcludeObject: PrinterObjectExcludeObject{
+			Objects: []ExcludeObjectDefinition{{Name: "PART 1 A=B"}, {Name: "PART#2"}},
+		},
+	}
+	ctx := context.Background()
+
+	if err := m.ExcludeObject(ctx, "part 1 a=b"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-f.scripts, `EXCLUDE_OBJECT NAME="PART 1 A=B"`; got != want {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+
+	var gcodeErr *printer.GCodeError
+	if err := m.ExcludeObject(ctx, "PART#2"); !errors.As(err, &gcodeErr) {
+		t.Errorf("ExcludeObject(PART#2) = %v, want a GCodeError", err)
+	}
+	if len(f.scripts) != 0 {
+		t.Errorf("ran %q for a name that can't be quoted", <-f.scripts)
+	}
+}
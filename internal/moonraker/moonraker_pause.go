@@ -0,0 +1,23 @@
+package moonraker
+
+import "3dp-controller/internal/printer"
+
+var _ printer.PauseScheduler = (*Monitor)(nil)
+
+// SchedulePause pauses the current or next job at the layer or Z height of
+// at. Layers are those of Job.CurrentLayer.
+func (m *Monitor) SchedulePause(at printer.PauseAt) error {
+	return m.enforcer.SchedulePause(at)
+}
+
+func (m *Monitor) ScheduledPause() *printer.PauseAt {
+	return m.enforcer.ScheduledPause()
+}
+
+func (m *Monitor) CancelScheduledPause() {
+	m.enforcer.CancelScheduledPause()
+}
+
+func (m *Monitor) JobPausedBySchedule() bool {
+	return m.enforcer.JobPausedBySchedule()
+}
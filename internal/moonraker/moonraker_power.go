@@ -0,0 +1,52 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+)
+
+var _ printer.PowerController = (*Monitor)(nil)
+
+// PowerDevices lists the devices of Moonraker's power component, none if
+// it is not configured.
+func (m *Monitor) PowerDevices(ctx context.Context) ([]printer.PowerDevice, error) {
+	devices, err := m.client.ListPowerDevices(ctx)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	out := make([]printer.PowerDevice, len(devices))
+	for i, d := range devices {
+		out[i] = printer.PowerDevice{
+			Name:                d.Device,
+			Status:              printer.PowerStatus(d.Status),
+			LockedWhilePrinting: d.LockedWhilePrinting,
+		}
+	}
+
+	return out, nil
+}
+
+func (m *Monitor) SetPower(ctx context.Context, name string, on bool) error {
+	action := "off"
+	if on {
+		action = "on"
+	}
+
+	m.logger.Infof("Switching power device %s %s\n", name, action)
+
+	_, err := m.client.SetPowerDevice(ctx, name, action)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return printer.ErrNoPowerDevice
+	}
+
+	return err
+}
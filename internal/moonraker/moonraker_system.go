@@ -0,0 +1,83 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"strings"
+	"time"
+)
+
+var _ printer.SystemInfo = (*Monitor)(nil)
+
+// SystemInfo returns the cached host information, fetching it if the
+// monitor has not yet.
+func (m *Monitor) SystemInfo(ctx context.Context) (*printer.HostInfo, error) {
+	m.systemMu.Lock()
+	info := m.systemInfo
+	m.systemMu.Unlock()
+
+	if info != nil {
+		return info, nil
+	}
+
+	return m.refreshSystemInfo(ctx)
+}
+
+// refreshSystemInfo fetches the host information and caches it. Only
+// Moonraker itself has to answer; what Klippy or the machine component
+// cannot tell, e.g. while Klippy is not connected, is left empty until the
+// next refresh.
+func (m *Monitor) refreshSystemInfo(ctx context.Context) (*printer.HostInfo, error) {
+	server, err := m.client.GetServerInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &printer.HostInfo{
+		Versions:  map[string]string{"moonraker": server.MoonrakerVersion},
+		UpdatedAt: time.Now(),
+	}
+
+	klippy, err := m.client.GetKlippyHostInfo(ctx)
+	if err != nil {
+		m.logger.Debugf("Failed to get Klippy host info: %s\n", err)
+	} else {
+		info.Hostname = klippy.HostName
+		info.Versions["klipper"] = klippy.SWVersion
+		info.CPU = klippy.CPUInfo
+		info.ConfigFile = klippy.ConfigFile
+	}
+
+	machine, err := m.client.GetMachineSystemInfo(ctx)
+	if err != nil {
+		m.logger.Debugf("Failed to get machine system info: %s\n", err)
+	} else {
+		if machine.CPUInfo.CPUDesc != "" {
+			info.CPU = machine.CPUInfo.CPUDesc
+		}
+
+		info.Hardware = machine.CPUInfo.Model
+		if info.Hardware == "" {
+			info.Hardware = machine.CPUInfo.HardwareDesc
+		}
+
+		dist := machine.Distribution
+		info.OS = dist.Name
+		if info.OS == "" {
+			info.OS = strings.TrimSpace(dist.Id + " " + dist.Version)
+		}
+	}
+
+	m.systemMu.Lock()
+	m.systemInfo = info
+	m.systemMu.Unlock()
+
+	return info, nil
+}
+
+// updateSystemInfo refreshes the host information after a (re)connect.
+func (m *Monitor) updateSystemInfo(ctx context.Context) {
+	if _, err := m.refreshSystemInfo(ctx); err != nil {
+		m.logger.Warnf("Failed to get system info: %s\n", err)
+	}
+}
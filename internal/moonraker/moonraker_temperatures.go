@@ -0,0 +1,83 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"strings"
+)
+
+// Klipper lists every heater and temperature sensor in the "heaters"
+// object's available_sensors, by object name: "extruder", "heater_bed",
+// "heater_generic chamber", "temperature_sensor mcu", ... Each of them
+// reports "temperature"; heaters also report "target" and "power".
+
+// availableSensors returns the object names listed by the heaters object.
+func availableSensors(heaters map[string]any) []string {
+	list, _ := heaters["available_sensors"].([]any)
+
+	sensors := make([]string, 0, len(list))
+	for _, v := range list {
+		if name, ok := v.(string); ok {
+			sensors = append(sensors, name)
+		}
+	}
+
+	return sensors
+}
+
+// temperaturesFrom reads the named sensors from a status report. Sensors
+// without a reading yet are left out.
+func temperaturesFrom(report map[string]map[string]any, sensors []string) []printer.Temperature {
+	var temps []printer.Temperature
+
+	for _, objectName := range sensors {
+		obj, ok := report[objectName]
+		if !ok {
+			continue
+		}
+
+		current, ok := obj["temperature"].(float64)
+		if !ok {
+			continue
+		}
+
+		t := printer.Temperature{
+			Name:    sensorName(objectName),
+			Kind:    sensorKind(objectName),
+			Current: current,
+		}
+
+		if target, ok := obj["target"].(float64); ok {
+			t.Target = &target
+		}
+
+		if power, ok := obj["power"].(float64); ok {
+			t.Power = &power
+		}
+
+		temps = append(temps, t)
+	}
+
+	return temps
+}
+
+// sensorName drops the type from names like "temperature_sensor chamber".
+func sensorName(objectName string) string {
+	if _, name, ok := strings.Cut(objectName, " "); ok {
+		return name
+	}
+
+	return objectName
+}
+
+func sensorKind(objectName string) printer.TemperatureKind {
+	switch {
+	case strings.HasPrefix(objectName, "extruder"):
+		return printer.TemperatureNozzle
+	case objectName == "heater_bed":
+		return printer.TemperatureBed
+	case strings.Contains(sensorName(objectName), "chamber"):
+		return printer.TemperatureChamber
+	default:
+		return printer.TemperatureOther
+	}
+}
@@ -0,0 +1,74 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var _ printer.Tuner = (*Monitor)(nil)
+
+func (m *Monitor) Tuning() *printer.Tuning {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.printerObjects == nil {
+		return nil
+	}
+
+	gcodeMove := m.printerObjects.GCodeMove
+	if len(gcodeMove.HomingOrigin) < 3 {
+		// Klippy hasn't reported gcode_move yet.
+		return nil
+	}
+
+	t := &printer.Tuning{
+		SpeedFactor:   gcodeMove.SpeedFactor,
+		ExtrudeFactor: gcodeMove.ExtrudeFactor,
+		ZOffset:       gcodeMove.HomingOrigin[2],
+	}
+
+	if fan := m.printerObjects.Fan; fan != nil {
+		speed := fan.Speed
+		t.FanSpeed = &speed
+	}
+
+	return t
+}
+
+// SetTuning runs the G-code for change as one script. The Z offset is
+// applied to the current position right away while a job is active, and
+// from the next move otherwise, since moving needs homed axes.
+func (m *Monitor) SetTuning(ctx context.Context, change printer.TuningChange) error {
+	var script []string
+
+	if change.SpeedFactor != nil {
+		script = append(script, fmt.Sprintf("M220 S%.1f", *change.SpeedFactor*100))
+	}
+
+	if change.ExtrudeFactor != nil {
+		script = append(script, fmt.Sprintf("M221 S%.1f", *change.ExtrudeFactor*100))
+	}
+
+	if change.FanSpeed != nil {
+		script = append(script, fmt.Sprintf("M106 S%d", int(math.Round(*change.FanSpeed*255))))
+	}
+
+	if change.ZOffset != nil {
+		move := 0
+		if m.State().HasActiveJob() {
+			move = 1
+		}
+		script = append(script, fmt.Sprintf("SET_GCODE_OFFSET Z=%.3f MOVE=%d", *change.ZOffset, move))
+	}
+
+	if len(script) == 0 {
+		return nil
+	}
+
+	m.logger.Infof("Tuning on request: %s\n", strings.Join(script, ", "))
+
+	return gcodeError(m.client.RunGCode(ctx, strings.Join(script, "\n")))
+}
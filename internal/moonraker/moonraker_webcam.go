@@ -0,0 +1,148 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var _ printer.Webcam = (*Monitor)(nil)
+
+// webcamUrl returns the URL relative webcam URLs are resolved against.
+func webcamUrl(printerUrl *url.URL, option string) *url.URL {
+	if option != "" {
+		// Options.Validate parsed it already.
+		u, _ := url.Parse(option)
+		return u
+	}
+
+	u := *printerUrl
+	u.Host = u.Hostname()
+	if strings.Contains(u.Host, ":") {
+		u.Host = "[" + u.Host + "]"
+	}
+	u.Path = "/"
+	u.RawPath = ""
+	return &u
+}
+
+func (m *Monitor) Webcams(ctx context.Context) ([]string, error) {
+	webcams, err := m.enabledWebcams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(webcams))
+	for i, w := range webcams {
+		names[i] = w.Name
+	}
+
+	return names, nil
+}
+
+func (m *Monitor) WebcamSnapshot(ctx context.Context, name string, w io.Writer) (string, error) {
+	webcam, err := m.webcam(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	if webcam.SnapshotUrl == "" {
+		return "", fmt.Errorf("webcam %s has no snapshot url", webcam.Name)
+	}
+
+	resp, err := m.getWebcamUrl(ctx, webcam.SnapshotUrl)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("Content-Type"), nil
+}
+
+func (m *Monitor) WebcamStream(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	webcam, err := m.webcam(ctx, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if webcam.StreamUrl == "" {
+		return nil, "", fmt.Errorf("webcam %s has no stream url", webcam.Name)
+	}
+
+	resp, err := m.getWebcamUrl(ctx, webcam.StreamUrl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func (m *Monitor) enabledWebcams(ctx context.Context) ([]WebcamInfo, error) {
+	webcams, err := m.client.ListWebcams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := webcams[:0]
+	for _, w := range webcams {
+		if w.Enabled {
+			enabled = append(enabled, w)
+		}
+	}
+
+	return enabled, nil
+}
+
+// webcam looks up the named webcam, or the first one for "".
+func (m *Monitor) webcam(ctx context.Context, name string) (*WebcamInfo, error) {
+	webcams, err := m.enabledWebcams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range webcams {
+		if name == "" || w.Name == name {
+			return &w, nil
+		}
+	}
+
+	return nil, printer.ErrNoWebcam
+}
+
+// getWebcamUrl GETs a webcam URL. Webcams are served outside Moonraker, so
+// the request is not authenticated.
+func (m *Monitor) getWebcamUrl(ctx context.Context, rawUrl string) (*http.Response, error) {
+	ref, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", m.webcamUrl.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, ERRRespNotOk{
+			error:      fmt.Errorf("non-200 http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,307 @@
+package moonraker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Moonraker serves JSON-RPC 2.0 on ws://<host>/websocket. Besides answering
+// requests it pushes notifications: after printer.objects.subscribe,
+// notify_status_update carries the fields of the subscribed objects that
+// changed; notify_history_changed reports jobs being added and finished;
+// notify_gcode_response carries each line of console output; and
+// notify_klippy_ready, notify_klippy_shutdown and notify_klippy_disconnected
+// follow Klippy itself. Subscriptions do not survive a Klippy restart, so
+// they have to be renewed on notify_klippy_ready.
+
+var ErrWSClosed = errors.New("moonraker websocket closed")
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	Id      int    `json:"id"`
+}
+
+// rpcMessage is a response (Id set) or a notification (Method set).
+type rpcMessage struct {
+	Id     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *APIError       `json:"error"`
+}
+
+// WSClient is a JSON-RPC connection to Moonraker's websocket.
+type WSClient struct {
+	conn   *websocket.Conn
+	notify func(method string, params json.RawMessage)
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextId  int
+	pending map[int]chan rpcMessage
+
+	done chan struct{}
+	err  error
+}
+
+// WebSocketURL returns the websocket endpoint of the Moonraker at apiUrl.
+func WebSocketURL(apiUrl *url.URL) *url.URL {
+	u := apiUrl.JoinPath("/websocket")
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	return u
+}
+
+// DialWS connects to the websocket at u. notify is called from the reader
+// goroutine with the method and params of every notification, so it must
+// not make calls on the client itself.
+func DialWS(ctx context.Context, u *url.URL, notify func(method string, params json.RawMessage)) (*WSClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &WSClient{
+		conn:    conn,
+		notify:  notify,
+		pending: make(map[int]chan rpcMessage),
+		done:    make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go c.pingLoop()
+
+	return c, nil
+}
+
+// Done is closed once the connection is lost.
+func (c *WSClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was lost, once Done is closed.
+func (c *WSClient) Err() error {
+	<-c.done
+	return c.err
+}
+
+func (c *WSClient) Close() error {
+	return c.conn.Close()
+}
+
+const (
+	wsPingInterval = 10 * time.Second
+	wsReadTimeout  = 3 * wsPingInterval
+)
+
+func (c *WSClient) readLoop() {
+	defer close(c.done)
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) ||
+				errors.Is(err, websocket.ErrCloseSent) {
+				err = ErrWSClosed
+			}
+			c.err = err
+			return
+		}
+
+		_ = c.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		var msg rpcMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			continue
+		}
+
+		if msg.Id == nil {
+			if c.notify != nil && msg.Method != "" {
+				c.notify(msg.Method, msg.Params)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[*msg.Id]
+		delete(c.pending, *msg.Id)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+func (c *WSClient) pingLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingInterval))
+			c.writeMu.Unlock()
+			if err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Call sends a request and decodes its result into out, which may be nil.
+// An error answer is returned as *APIError.
+func (c *WSClient) Call(ctx context.Context, method string, params any, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	c.mu.Lock()
+	c.nextId++
+	id := c.nextId
+	ch := make(chan rpcMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	err := c.conn.WriteJSON(rpcRequest{JSONRPC: "2.0", Method: method, Params: params, Id: id})
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return c.err
+	case msg := <-ch:
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		if out == nil || msg.Result == nil {
+			return nil
+		}
+
+		return json.Unmarshal(msg.Result, out)
+	}
+}
+
+// -------------------
+// Object subscription
+
+// StatusUpdate is the result of printer.objects.subscribe. The
+// notify_status_update notifications that follow it only carry the fields
+// that changed.
+type StatusUpdate struct {
+	EventTime float64                   `json:"eventtime"`
+	Status    map[string]map[string]any `json:"status"`
+}
+
+// Subscribe subscribes to all fields of the named objects, replacing any
+// earlier subscription on this connection, and returns their current
+// status.
+func (c *WSClient) Subscribe(ctx context.Context, objects ...string) (*StatusUpdate, error) {
+	objs := make(map[string]any, len(objects))
+	for _, o := range objects {
+		objs[o] = nil
+	}
+
+	out := new(StatusUpdate)
+	if err := c.Call(ctx, "printer.objects.subscribe", map[string]any{"objects": objs}, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ParseStatusUpdate decodes the params of notify_status_update, which are
+// [status, eventtime].
+func ParseStatusUpdate(params json.RawMessage) (map[string]map[string]any, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty status update")
+	}
+
+	var status map[string]map[string]any
+	if err := json.Unmarshal(args[0], &status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// HistoryChange is the params of notify_history_changed.
+type HistoryChange struct {
+	// Action is "added" when a job starts and "finished" when it ends.
+	Action string `json:"action"`
+	Job    Job    `json:"job"`
+}
+
+// ParseHistoryChange decodes the params of notify_history_changed, which
+// are [change].
+func ParseHistoryChange(params json.RawMessage) (*HistoryChange, error) {
+	var args []HistoryChange
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return nil, errors.New("empty history change")
+	}
+
+	return &args[0], nil
+}
+
+// ParseGCodeResponse decodes the params of notify_gcode_response, which
+// are [line].
+func ParseGCodeResponse(params json.RawMessage) (string, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil {
+		return "", err
+	}
+
+	if len(args) == 0 {
+		return "", errors.New("empty gcode response")
+	}
+
+	return args[0], nil
+}
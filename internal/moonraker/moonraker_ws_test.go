@@ -0,0 +1,531 @@
+package moonraker
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var errKlippyDisconnected = &APIError{Code: 503, Message: "Klippy Disconnected"}
+
+// fakeMoonraker serves the parts of Moonraker's HTTP API and websocket the
+// monitor uses, from a status the test sets.
+type fakeMoonraker struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	status      map[string]map[string]any
+	klippyReady bool
+	wsDown      bool
+	conn        *websocket.Conn
+	dials       []time.Time
+	queries     int
+	// jobs is the job history, latest first. A print started adds a job.
+	jobs []Job
+	// holdHistory, when set, holds back the next answer from the history
+	// until it is closed.
+	holdHistory chan struct{}
+	// gcodeStore is the console history. A script run adds itself as a
+	// command, then what scriptOutput gives for it.
+	gcodeStore   []GCodeStoreEntry
+	scriptOutput func(script string) []GCodeStoreEntry
+
+	writeMu sync.Mutex
+
+	// calls receives the websocket methods called and the paths of the
+	// commands posted over HTTP.
+	calls chan string
+	// scripts receives the G-code scripts run.
+	scripts chan string
+}
+
+func newFakeMoonraker(t *testing.T) *fakeMoonraker {
+	f := &fakeMoonraker{
+		status: map[string]map[string]any{
+			"webhooks":    {"state": "ready", "state_message": "Printer is ready"},
+			"print_stats": {"state": "standby", "filename": "", "print_duration": 0.0},
+		},
+		klippyReady: true,
+		calls:       make(chan string, 100),
+		scripts:     make(chan string, 100),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/websocket", f.serveWS)
+	mux.HandleFunc("/printer/objects/query", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.queries++
+		ready := f.klippyReady
+		f.mu.Unlock()
+
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": errKlippyDisconnected})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"result": map[string]any{"eventtime": 1.0, "status": f.snapshot()},
+		})
+	})
+	mux.HandleFunc("/server/history/list", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		jobs := JobList{Count: len(f.jobs), Jobs: append([]Job{}, f.jobs...)}
+		hold := f.holdHistory
+		f.holdHistory = nil
+		f.mu.Unlock()
+
+		f.calls <- r.URL.Path
+		if hold != nil {
+			<-hold
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": jobs})
+	})
+	mux.HandleFunc("/printer/print/start", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		job := Job{JobId: fmt.Sprintf("%06d", len(f.jobs)+1), Filename: r.URL.Query().Get("filename"), Status: "in_progress"}
+		f.jobs = append([]Job{job}, f.jobs...)
+		f.mu.Unlock()
+
+		f.calls <- r.URL.Path
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": "ok"})
+	})
+	mux.HandleFunc("/server/gcode_store", func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+
+		f.mu.Lock()
+		store := f.gcodeStore[max(len(f.gcodeStore)-count, 0):]
+		f.mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"gcode_store": store}})
+	})
+	mux.HandleFunc("/printer/gcode/script", func(w http.ResponseWriter, r *http.Request) {
+		script := r.URL.Query().Get("script")
+
+		f.mu.Lock()
+		f.addGCode(GCodeStoreEntry{Message: script, Type: "command"})
+		if f.scriptOutput != nil {
+			f.addGCode(f.scriptOutput(script)...)
+		}
+		f.mu.Unlock()
+
+		f.calls <- r.URL.Path
+		f.scripts <- script
+		_ = json.NewEncoder(w).Encode(map[string]any{"result": "ok"})
+	})
+	for _, path := range []string{
+		"/printer/print/pause", "/printer/print/resume", "/printer/print/cancel",
+	} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			f.calls <- path
+			_ = json.NewEncoder(w).Encode(map[string]any{"result": "ok"})
+		})
+	}
+
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// addGCode adds entries to the console history, each a second after the
+// last. f.mu must be held.
+func (f *fakeMoonraker) addGCode(entries ...GCodeStoreEntry) {
+	for _, entry := range entries {
+		entry.Time = float64(len(f.gcodeStore) + 1)
+		f.gcodeStore = append(f.gcodeStore, entry)
+	}
+}
+
+func (f *fakeMoonraker) snapshot() json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, _ := json.Marshal(f.status)
+	return b
+}
+
+func (f *fakeMoonraker) setStatus(name string, fields map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for k, v := range fields {
+		f.status[name][k] = v
+	}
+}
+
+func (f *fakeMoonraker) queryCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.queries
+}
+
+func (f *fakeMoonraker) serveWS(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.dials = append(f.dials, time.Now())
+	down := f.wsDown
+	f.mu.Unlock()
+
+	if down {
+		http.Error(w, "websocket down", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	f.conn = conn
+	f.mu.Unlock()
+
+	for {
+		var req struct {
+			Id     int    `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		f.calls <- req.Method
+
+		f.mu.Lock()
+		ready := f.klippyReady
+		f.mu.Unlock()
+
+		switch {
+		case req.Method != "printer.objects.subscribe":
+			f.send(conn, map[string]any{"id": req.Id, "error": &APIError{Code: -32601, Message: "Method not found"}})
+		case !ready:
+			f.send(conn, map[string]any{"id": req.Id, "error": errKlippyDisconnected})
+		default:
+			f.send(conn, map[string]any{"id": req.Id, "result": map[string]any{"eventtime": 1.0, "status": f.snapshot()}})
+		}
+	}
+}
+
+func (f *fakeMoonraker) send(conn *websocket.Conn, msg map[string]any) {
+	msg["jsonrpc"] = "2.0"
+
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	_ = conn.WriteJSON(msg)
+}
+
+// notify sends a notification on the current websocket connection.
+func (f *fakeMoonraker) notify(method string, params ...any) {
+	f.mu.Lock()
+	conn := f.conn
+	f.mu.Unlock()
+
+	if conn != nil {
+		f.send(conn, map[string]any{"method": method, "params": params})
+	}
+}
+
+// dropWS closes the websocket connection without a close frame, as a
+// network failure would.
+func (f *fakeMoonraker) dropWS() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.conn != nil {
+		_ = f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func newTestMonitor(t *testing.T, f *fakeMoonraker) *Monitor {
+	t.Helper()
+
+	m, err := NewMonitor("test", f.srv.URL, Options{}, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func startMonitor(t *testing.T, f *fakeMoonraker) *Monitor {
+	t.Helper()
+
+	m := newTestMonitor(t, f)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	return m
+}
+
+func TestWSClientRoutesResponsesAndNotifications(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Answer both calls once they are in, out of order and with a
+		// notification in between.
+		ids := make(map[string]int)
+		for len(ids) < 2 {
+			var req rpcRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			ids[req.Method] = req.Id
+		}
+
+		_ = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": ids["second"], "result": "second"})
+		_ = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "notify_status_update", "params": []any{map[string]any{"webhooks": map[string]any{"state": "ready"}}, 1.0}})
+		_ = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": ids["first"], "result": "first"})
+
+		var req rpcRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			return
+		}
+		_ = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": req.Id, "error": errKlippyDisconnected})
+
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+
+	type notification struct {
+		method string
+		params json.RawMessage
+	}
+	notifications := make(chan notification, 10)
+
+	c, err := DialWS(context.Background(), WebSocketURL(u), func(method string, params json.RawMessage) {
+		notifications <- notification{method, params}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for _, method := range []string{"first", "second"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var out string
+			if err := c.Call(context.Background(), method, nil, &out); err != nil {
+				t.Errorf("%s: %s", method, err)
+			} else if out != method {
+				t.Errorf("%s got the answer to %s", method, out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case n := <-notifications:
+		status, err := ParseStatusUpdate(n.params)
+		if n.method != "notify_status_update" || err != nil || status["webhooks"]["state"] != "ready" {
+			t.Errorf("notification %s %s: %v, %v", n.method, n.params, status, err)
+		}
+	default:
+		t.Error("notification not delivered")
+	}
+
+	var apiErr *APIError
+	if err := c.Call(context.Background(), "failing", nil, nil); !errors.As(err, &apiErr) || apiErr.Code != 503 {
+		t.Errorf("error answer = %v", err)
+	}
+
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("not done after the server closed")
+	}
+	if !errors.Is(c.Err(), ErrWSClosed) {
+		t.Errorf("Err() = %v, want ErrWSClosed", c.Err())
+	}
+}
+
+func TestParseStatusUpdate(t *testing.T) {
+	status, err := ParseStatusUpdate(json.RawMessage(`[{"print_stats": {"state": "printing"}, "virtual_sdcard": {"progress": 0.5}}, 1234.5]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status["print_stats"]["state"] != "printing" || status["virtual_sdcard"]["progress"] != 0.5 {
+		t.Errorf("status = %v", status)
+	}
+
+	for _, params := range []string{`[]`, `{}`, `["printing"]`} {
+		if _, err := ParseStatusUpdate(json.RawMessage(params)); err == nil {
+			t.Errorf("ParseStatusUpdate(%s) succeeded", params)
+		}
+	}
+}
+
+func TestParseHistoryChange(t *testing.T) {
+	change, err := ParseHistoryChange(json.RawMessage(`[{"action": "finished", "job": {"job_id": "000012", "filename": "benchy.gcode", "status": "completed"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Action != "finished" || change.Job.JobId != "000012" || change.Job.Status != "completed" {
+		t.Errorf("change = %+v", change)
+	}
+
+	if _, err := ParseHistoryChange(json.RawMessage(`[]`)); err == nil {
+		t.Error("empty history change parsed")
+	}
+}
+
+func TestMergeStatus(t *testing.T) {
+	m := newTestMonitor(t, newFakeMoonraker(t))
+	ctx := context.Background()
+
+	m.mergeStatus(ctx, map[string]map[string]any{"webhooks": {"state": "ready"}})
+	if m.printerObjects != nil {
+		t.Fatal("merged without a subscription")
+	}
+
+	m.mu.Lock()
+	m.report = make(map[string]map[string]any)
+	m.mu.Unlock()
+
+	m.mergeStatus(ctx, map[string]map[string]any{
+		"webhooks":    {"state": "ready"},
+		"print_stats": {"state": "printing", "filename": "benchy.gcode", "print_duration": 0.0},
+	})
+	if s := m.State(); s != printer.PrePrint {
+		t.Fatalf("state = %s, want PrePrint", s)
+	}
+
+	// Notifications only carry the fields that changed.
+	m.mergeStatus(ctx, map[string]map[string]any{"print_stats": {"print_duration": 12.5}})
+	if s := m.State(); s != printer.Printing {
+		t.Fatalf("state = %s, want Printing", s)
+	}
+	m.mu.RLock()
+	fileName := m.printerObjects.PrintStats.FileName
+	m.mu.RUnlock()
+	if fileName != "benchy.gcode" {
+		t.Errorf("filename = %q after a partial update", fileName)
+	}
+
+	m.mergeStatus(ctx, map[string]map[string]any{"webhooks": {"state": "shutdown", "state_message": "MCU shutdown"}})
+	if s := m.State(); s != printer.Error || m.Message() != "MCU shutdown" {
+		t.Errorf("state = %s, %q", s, m.Message())
+	}
+}
+
+func TestMonitorFollowsNotifications(t *testing.T) {
+	f := newFakeMoonraker(t)
+	m := startMonitor(t, f)
+
+	testutil.WaitFor(t, f.calls, "printer.objects.subscribe")
+	testutil.Eventually(t, "the websocket", func() bool { return m.currentWS() != nil })
+
+	f.notify("notify_status_update", map[string]any{"print_stats": map[string]any{"state": "printing", "print_duration": 3.0}}, 10.0)
+	testutil.Eventually(t, "Printing", func() bool { return m.State() == printer.Printing })
+
+	f.notify("notify_history_changed", map[string]any{
+		"action": "added",
+		"job":    map[string]any{"job_id": "000042", "filename": "benchy.gcode", "status": "in_progress"},
+	})
+	testutil.Eventually(t, "the job", func() bool {
+		j := m.Job()
+		return j != nil && j.JobId == "000042"
+	})
+
+	f.notify("notify_klippy_shutdown")
+	testutil.Eventually(t, "Error", func() bool { return m.State() == printer.Error })
+}
+
+func TestMonitorResubscribesWhenKlippyReady(t *testing.T) {
+	f := newFakeMoonraker(t)
+	f.klippyReady = false
+	m := startMonitor(t, f)
+
+	testutil.WaitFor(t, f.calls, "printer.objects.subscribe")
+	testutil.Eventually(t, "Error", func() bool {
+		detail := m.ErrorDetail()
+		return m.State() == printer.Error && detail != nil && detail.Message == "Klippy Disconnected"
+	})
+
+	f.mu.Lock()
+	f.klippyReady = true
+	f.mu.Unlock()
+
+	f.notify("notify_klippy_ready")
+	testutil.WaitFor(t, f.calls, "printer.objects.subscribe")
+	testutil.Eventually(t, "Ready", func() bool { return m.State() == printer.Ready })
+
+	// Subscribed objects keep notifying after the renewal.
+	f.notify("notify_status_update", map[string]any{"print_stats": map[string]any{"state": "paused"}}, 10.0)
+	testutil.Eventually(t, "Pause", func() bool { return m.State() == printer.Pause })
+}
+
+func TestConnectLoopFallsBackToPolling(t *testing.T) {
+	f := newFakeMoonraker(t)
+	f.wsDown = true
+	m := startMonitor(t, f)
+
+	testutil.Eventually(t, "Ready over HTTP", func() bool { return m.State() == printer.Ready })
+	testutil.Eventually(t, "three dials", func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.dials) >= 3
+	})
+
+	f.mu.Lock()
+	dials := f.dials
+	f.wsDown = false
+	f.mu.Unlock()
+
+	// The retries back off 1s, then 2s.
+	if d := dials[1].Sub(dials[0]); d < 900*time.Millisecond {
+		t.Errorf("first retry after %s", d)
+	}
+	if d := dials[2].Sub(dials[1]); d < 1900*time.Millisecond {
+		t.Errorf("second retry after %s", d)
+	}
+
+	testutil.Eventually(t, "the websocket", func() bool { return m.currentWS() != nil })
+
+	// Polling stops while the websocket is up.
+	time.Sleep(100 * time.Millisecond)
+	queries := f.queryCount()
+	time.Sleep(2500 * time.Millisecond)
+	if n := f.queryCount(); n != queries {
+		t.Errorf("%d queries while the websocket was up", n-queries)
+	}
+
+	f.mu.Lock()
+	f.wsDown = true
+	f.mu.Unlock()
+	f.setStatus("print_stats", map[string]any{"state": "paused"})
+	f.dropWS()
+
+	testutil.Eventually(t, "the websocket to close", func() bool { return m.currentWS() == nil })
+	testutil.Eventually(t, "Pause over HTTP", func() bool { return m.State() == printer.Pause })
+	if f.queryCount() == queries {
+		t.Error("not polling after the websocket closed")
+	}
+}
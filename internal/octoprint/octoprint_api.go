@@ -0,0 +1,292 @@
+package octoprint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ERRRespNotOk is returned when OctoPrint answers with an unexpected HTTP
+// status code.
+type ERRRespNotOk struct {
+	error
+
+	statusCode int
+	respBody   []byte
+}
+
+func (e ERRRespNotOk) RespStatusCode() int {
+	return e.statusCode
+}
+
+func (e ERRRespNotOk) RespBody() []byte {
+	return e.respBody
+}
+
+func (e ERRRespNotOk) Error() string {
+	return e.error.Error()
+}
+
+// Client is a minimal OctoPrint REST API client authenticated with an API
+// key (global or application key).
+type Client struct {
+	baseUrl    *url.URL
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewClient(baseUrl *url.URL, apiKey string) *Client {
+	return &Client{
+		baseUrl:    baseUrl,
+		apiKey:     apiKey,
+		httpClient: http.DefaultClient,
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, u *url.URL, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Api-Key", c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// do sends the request and decodes a 200 response into out (if non-nil). Any
+// other 2xx status is accepted without a body.
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	req, err := c.newRequest(ctx, method, c.baseUrl.JoinPath(path), body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ERRRespNotOk{
+			error:      fmt.Errorf("non-2xx http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+			respBody:   b,
+		}
+	}
+
+	if out == nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	return json.NewDecoder(bytes.NewReader(b)).Decode(out)
+}
+
+// ------------------------
+// Retrieve information about the current job
+
+type JobFile struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Origin string `json:"origin"` // "local" or "sdcard"
+	Size   *int64 `json:"size"`
+}
+
+type JobProgress struct {
+	Completion    *float32 `json:"completion"` // percent, 0..100
+	FilePos       *int64   `json:"filepos"`
+	PrintTime     *float64 `json:"printTime"`     // seconds
+	PrintTimeLeft *float64 `json:"printTimeLeft"` // seconds
+}
+
+type JobInformation struct {
+	Job struct {
+		File               JobFile  `json:"file"`
+		EstimatedPrintTime *float64 `json:"estimatedPrintTime"`
+	} `json:"job"`
+	Progress JobProgress `json:"progress"`
+	State    string      `json:"state"`
+	Error    string      `json:"error"`
+}
+
+func (c *Client) GetJob(ctx context.Context) (*JobInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(JobInformation)
+	if err := c.do(ctx, "GET", "/api/job", nil, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ---------------------------
+// Retrieve the current printer state
+
+type PrinterStateFlags struct {
+	Operational   bool `json:"operational"`
+	Paused        bool `json:"paused"`
+	Printing      bool `json:"printing"`
+	Pausing       bool `json:"pausing"`
+	Cancelling    bool `json:"cancelling"`
+	SDReady       bool `json:"sdReady"`
+	Error         bool `json:"error"`
+	Ready         bool `json:"ready"`
+	ClosedOrError bool `json:"closedOrError"`
+}
+
+type PrinterStateResponse struct {
+	State struct {
+		Text  string            `json:"text"`
+		Flags PrinterStateFlags `json:"flags"`
+	} `json:"state"`
+}
+
+// ErrPrinterNotOperational is returned by GetPrinter when OctoPrint isn't
+// connected to the printer (HTTP 409).
+var ErrPrinterNotOperational = errors.New("printer is not operational")
+
+func (c *Client) GetPrinter(ctx context.Context) (*PrinterStateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(PrinterStateResponse)
+	err := c.do(ctx, "GET", "/api/printer", nil, out)
+
+	var nonOkErr ERRRespNotOk
+	if errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == http.StatusConflict {
+		return nil, ErrPrinterNotOperational
+	} else if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// ----------------
+// Issue a job command
+
+func (c *Client) jobCommand(ctx context.Context, command string, action string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	body := struct {
+		Command string `json:"command"`
+		Action  string `json:"action,omitempty"`
+	}{command, action}
+
+	return c.do(ctx, "POST", "/api/job", body, nil)
+}
+
+func (c *Client) PausePrint(ctx context.Context) error {
+	return c.jobCommand(ctx, "pause", "pause")
+}
+
+func (c *Client) ResumePrint(ctx context.Context) error {
+	return c.jobCommand(ctx, "pause", "resume")
+}
+
+func (c *Client) CancelPrint(ctx context.Context) error {
+	return c.jobCommand(ctx, "cancel", "")
+}
+
+// -----------------------
+// Send an arbitrary command to the printer
+
+func (c *Client) SendCommands(ctx context.Context, commands ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	body := struct {
+		Commands []string `json:"commands"`
+	}{commands}
+
+	return c.do(ctx, "POST", "/api/printer/command", body, nil)
+}
+
+// ----------------------------------
+// Retrieve a specific file's information
+
+type FileInformation struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Origin string `json:"origin"`
+	// Thumbnail is set by the PrusaSlicer Thumbnails plugin: a URL relative
+	// to OctoPrint's base URL, possibly with a cache-busting query.
+	Thumbnail string `json:"thumbnail"`
+}
+
+func (c *Client) GetFile(ctx context.Context, origin string, path string) (*FileInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(FileInformation)
+	if err := c.do(ctx, "GET", "/api/files/"+origin+"/"+path, nil, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Download writes the resource at ref, a URL relative to OctoPrint's base
+// URL (as returned in FileInformation.Thumbnail), to w and returns its
+// content type.
+func (c *Client) Download(ctx context.Context, ref string, w io.Writer) (string, error) {
+	rel, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	u := c.baseUrl.JoinPath(rel.Path)
+	u.RawQuery = rel.RawQuery
+
+	req, err := c.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", ERRRespNotOk{
+			error:      fmt.Errorf("non-200 http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+		}
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", err
+	}
+
+	return resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,455 @@
+package octoprint
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/util"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.Thumbnailer = (*Monitor)(nil)
+
+// Options are the connection settings specific to an OctoPrint instance.
+type Options struct {
+	// ApiKey is an OctoPrint global or application API key.
+	ApiKey string `yaml:"api_key"`
+}
+
+func (o *Options) Validate() error {
+	if o.ApiKey == "" {
+		return errors.New("api key is required")
+	}
+
+	return nil
+}
+
+// trackedJob is a print job as observed by the monitor. OctoPrint has no job
+// IDs or history in its core API, so jobs are identified by file path and the
+// time they were first seen.
+type trackedJob struct {
+	id        string
+	file      JobFile
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+	// thumbnail is the PrusaSlicer Thumbnails plugin URL, "" when the plugin
+	// isn't installed or the file has none.
+	thumbnail string
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+	client      *Client
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	jobInfo        *JobInformation
+	job            *trackedJob
+	displayMessage string
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "octoprint"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.jobInfo == nil {
+		return ""
+	}
+
+	if m.jobInfo.Error != "" {
+		return m.jobInfo.Error
+	}
+
+	return m.jobInfo.State
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	job := m.job
+
+	startTime := job.startTime
+	j := &printer.Job{
+		JobId:        job.id,
+		Name:         job.file.Name,
+		Status:       job.status,
+		HasThumbnail: job.thumbnail != "",
+		StartTime:    &startTime,
+		EndTime:      job.endTime,
+	}
+
+	if job.status == "in_progress" && m.jobInfo != nil {
+		progress := m.jobInfo.Progress
+
+		if progress.Completion != nil {
+			p := *progress.Completion / 100
+			j.Progress = &p
+		}
+
+		if progress.PrintTime != nil {
+			printDuration := printer.Seconds(*progress.PrintTime)
+			j.PrintDuration = &printDuration
+		}
+
+		totalDuration := printer.Seconds(time.Since(job.startTime).Seconds())
+		j.TotalDuration = &totalDuration
+
+		if progress.PrintTimeLeft != nil {
+			remaining := printer.Seconds(*progress.PrintTimeLeft)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.logger = logger
+	m.client = NewClient(u, options.ApiKey)
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		m.update(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	jobInfo, err := m.client.GetJob(ctx)
+	if err == nil {
+		// /api/printer only adds the flags; /api/job already carries the state
+		// text, including while the printer is offline (409 here).
+		_, err = m.client.GetPrinter(ctx)
+		if errors.Is(err, ErrPrinterNotOperational) {
+			err = nil
+		}
+	}
+
+	m.mu.Lock()
+	m.lastUpdateTime = time.Now()
+
+	if err != nil {
+		m.jobInfo = nil
+
+		var nonOkErr ERRRespNotOk
+		if util.IsErrNetworkProblem(err) {
+			m.state = printer.Disconnected
+			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) && nonOkErr.RespStatusCode() == 502 {
+			m.state = printer.Disconnected
+			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) {
+			m.state = printer.InternalError
+			code := nonOkErr.RespStatusCode()
+			m.lastError = &printer.ErrorInfo{Code: &code, Message: err.Error()}
+			m.logger.Warnf("Failed to get printer state: %s, status_code: %d\n", err, code)
+		} else {
+			m.state = printer.InternalError
+			m.lastError = &printer.ErrorInfo{Message: err.Error()}
+			m.logger.Errorf("Error getting printer state: %s\n", err)
+		}
+
+		m.mu.Unlock()
+		return
+	}
+
+	m.jobInfo = jobInfo
+
+	var printDuration time.Duration
+	if jobInfo.Progress.PrintTime != nil {
+		printDuration = time.Duration(*jobInfo.Progress.PrintTime * float64(time.Second))
+	}
+
+	m.state = mapState(jobInfo.State, printDuration)
+	m.lastError = nil
+	if m.state == printer.Error {
+		msg := jobInfo.Error
+		if msg == "" {
+			msg = jobInfo.State
+		}
+		m.lastError = &printer.ErrorInfo{Message: msg}
+	}
+
+	newJob := m.trackJob(jobInfo, printDuration)
+
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = m.job.id
+	}
+
+	var progress float32
+	if jobInfo.Progress.Completion != nil {
+		progress = *jobInfo.Progress.Completion / 100
+	}
+
+	obs := printer.Observation{
+		State:          m.state,
+		PrintDuration:  printDuration,
+		Progress:       progress,
+		DisplayMessage: m.displayMessage,
+	}
+	m.mu.Unlock()
+
+	if newJob != nil {
+		m.loadThumbnail(ctx, newJob)
+	}
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, obs)
+}
+
+// trackJob starts or finishes m.job from the latest job information and
+// returns the job when a new one started. It must be called with m.mu held.
+func (m *Monitor) trackJob(jobInfo *JobInformation, printDuration time.Duration) *trackedJob {
+	file := jobInfo.Job.File
+	active := m.state == printer.PrePrint || m.state == printer.Printing || m.state == printer.Pause
+
+	if active && file.Path != "" {
+		if m.job != nil && m.job.status == "in_progress" && m.job.file.Path == file.Path {
+			return nil
+		}
+
+		startTime := time.Now().Add(-printDuration)
+		m.job = &trackedJob{
+			id:        fmt.Sprintf("%s@%d", file.Path, startTime.Unix()),
+			file:      file,
+			status:    "in_progress",
+			startTime: startTime,
+		}
+
+		return m.job
+	}
+
+	if m.job != nil && m.job.status == "in_progress" && !active {
+		endTime := time.Now()
+		m.job.endTime = &endTime
+
+		completion := jobInfo.Progress.Completion
+		switch {
+		case m.state == printer.Error:
+			m.job.status = "error"
+		case completion != nil && *completion >= 100:
+			m.job.status = "completed"
+		default:
+			m.job.status = "cancelled"
+		}
+	}
+
+	return nil
+}
+
+// loadThumbnail looks up the PrusaSlicer Thumbnails plugin's thumbnail for a
+// newly started job.
+func (m *Monitor) loadThumbnail(ctx context.Context, job *trackedJob) {
+	if job.file.Origin != "local" {
+		return
+	}
+
+	info, err := m.client.GetFile(ctx, job.file.Origin, job.file.Path)
+	if err != nil {
+		m.logger.Errorf("Failed to get file information: %s\n", err)
+		return
+	}
+
+	m.mu.Lock()
+	job.thumbnail = info.Thumbnail
+	m.mu.Unlock()
+}
+
+func (m *Monitor) LatestThumbnail(ctx context.Context, w io.Writer) (string, error) {
+	m.mu.RLock()
+	thumbnail := ""
+	if m.job != nil {
+		thumbnail = m.job.thumbnail
+	}
+	m.mu.RUnlock()
+
+	if thumbnail == "" {
+		return "", printer.ErrNoThumbnail
+	}
+
+	return m.client.Download(ctx, thumbnail, w)
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	return m.client.PausePrint(ctx)
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	return m.client.ResumePrint(ctx)
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	return m.client.CancelPrint(ctx)
+}
+
+func (m *Monitor) SetStatusMessage(ctx context.Context, msg string) error {
+	if err := m.client.SendCommands(ctx, "M117 "+msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.displayMessage = msg
+	m.mu.Unlock()
+
+	return nil
+}
+
+// mapState maps OctoPrint's state string onto printer.PrinterState.
+func mapState(state string, printDuration time.Duration) printer.PrinterState {
+	switch {
+	case state == "Operational":
+		return printer.Ready
+	case state == "Printing", state == "Printing from SD", state == "Resuming", state == "Finishing":
+		if printDuration > 0 {
+			return printer.Printing
+		}
+		return printer.PrePrint
+	case state == "Starting", strings.HasPrefix(state, "Starting print"), state == "Sending file to SD":
+		return printer.PrePrint
+	case state == "Pausing", state == "Paused":
+		return printer.Pause
+	case state == "Error", strings.HasPrefix(state, "Offline after error"),
+		strings.HasPrefix(state, "Offline (Error"):
+		return printer.Error
+	case state == "Offline", state == "Closed":
+		return printer.Disconnected
+	default:
+		return printer.Unknown
+	}
+}
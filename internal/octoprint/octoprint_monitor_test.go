@@ -0,0 +1,363 @@
+package octoprint
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/testutil"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const testApiKey = "0123456789ABCDEF"
+
+// fakeOctoPrint serves the parts of OctoPrint's REST API the monitor uses.
+type fakeOctoPrint struct {
+	srv *httptest.Server
+
+	mu sync.Mutex
+	// job is what GET /api/job answers.
+	job map[string]any
+	// printerStatus is the status of GET /api/printer, 409 while OctoPrint
+	// is not connected to the printer.
+	printerStatus int
+	// jobStatus overrides the status of GET /api/job.
+	jobStatus int
+	// thumbnail is what the PrusaSlicer Thumbnails plugin adds to file
+	// information, "" when it is not installed.
+	thumbnail string
+
+	// commands receives the job commands and G-code posted.
+	commands chan string
+}
+
+func newFakeOctoPrint(t *testing.T) *fakeOctoPrint {
+	f := &fakeOctoPrint{
+		printerStatus: http.StatusOK,
+		commands:      make(chan string, 100),
+	}
+	f.setJob("Operational", "", nil, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/job", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.jobStatus != 0 {
+			w.WriteHeader(f.jobStatus)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.job)
+	})
+	mux.HandleFunc("POST /api/job", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Command string `json:"command"`
+			Action  string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.commands <- strings.TrimSuffix(body.Command+" "+body.Action, " ")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/printer", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		w.WriteHeader(f.printerStatus)
+		_, _ = w.Write([]byte(`{"state": {"text": "", "flags": {}}}`))
+	})
+	mux.HandleFunc("POST /api/printer/command", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Commands []string `json:"commands"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		f.commands <- strings.Join(body.Commands, "\n")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/files/local/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		info := map[string]any{"name": r.PathValue("path"), "path": r.PathValue("path"), "origin": "local"}
+		if f.thumbnail != "" {
+			info["thumbnail"] = f.thumbnail
+		}
+		_ = json.NewEncoder(w).Encode(info)
+	})
+	mux.HandleFunc("GET /plugin/prusaslicerthumbnails/thumbnail/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png:" + r.PathValue("name")))
+	})
+
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != testApiKey {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+// setJob sets the state text, the file being printed, and its completion
+// in percent and print time in seconds, each nil when OctoPrint has none.
+func (f *fakeOctoPrint) setJob(state string, path string, completion any, printTime any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file := map[string]any{"name": nil, "path": nil, "origin": nil}
+	if path != "" {
+		origin := "local"
+		if strings.HasPrefix(path, "sd:") {
+			origin, path = "sdcard", strings.TrimPrefix(path, "sd:")
+		}
+		file = map[string]any{"name": path, "path": path, "origin": origin}
+	}
+
+	f.job = map[string]any{
+		"job": map[string]any{"file": file},
+		"progress": map[string]any{
+			"completion":    completion,
+			"printTime":     printTime,
+			"printTimeLeft": nil,
+		},
+		"state": state,
+	}
+}
+
+func (f *fakeOctoPrint) set(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn()
+}
+
+func newTestMonitor(t *testing.T, f *fakeOctoPrint) *Monitor {
+	t.Helper()
+
+	m, err := NewMonitor("mk3", f.srv.URL, Options{ApiKey: testApiKey}, testutil.MonitorConfig(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestMapState(t *testing.T) {
+	tests := []struct {
+		state         string
+		printDuration time.Duration
+		want          printer.PrinterState
+	}{
+		{"Operational", 0, printer.Ready},
+		{"Starting print from SD", 0, printer.PrePrint},
+		{"Printing", 0, printer.PrePrint},
+		{"Printing", time.Second, printer.Printing},
+		{"Printing from SD", time.Second, printer.Printing},
+		{"Finishing", time.Second, printer.Printing},
+		{"Pausing", time.Second, printer.Pause},
+		{"Paused", time.Second, printer.Pause},
+		{"Error", 0, printer.Error},
+		{"Offline after error", 0, printer.Error},
+		{"Offline", 0, printer.Disconnected},
+		{"Detecting serial connection", 0, printer.Unknown},
+	}
+
+	for _, tt := range tests {
+		if got := mapState(tt.state, tt.printDuration); got != tt.want {
+			t.Errorf("mapState(%q, %s) = %s, want %s", tt.state, tt.printDuration, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	f := newFakeOctoPrint(t)
+	m := newTestMonitor(t, f)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		setup func()
+		want  printer.PrinterState
+	}{
+		{"operational", func() { f.setJob("Operational", "", nil, nil) }, printer.Ready},
+		{"printing", func() { f.setJob("Printing", "benchy.gcode", 10.0, 30.0) }, printer.Printing},
+		{"pausing", func() { f.setJob("Pausing", "benchy.gcode", 10.0, 30.0) }, printer.Pause},
+		{"paused", func() { f.setJob("Paused", "benchy.gcode", 10.0, 30.0) }, printer.Pause},
+		{"error", func() { f.setJob("Error", "", nil, nil) }, printer.Error},
+		{"offline", func() {
+			f.setJob("Offline", "", nil, nil)
+			f.set(func() { f.printerStatus = http.StatusConflict })
+		}, printer.Disconnected},
+		{"bad gateway", func() { f.set(func() { f.jobStatus = http.StatusBadGateway }) }, printer.Disconnected},
+		{"forbidden", func() { f.set(func() { f.jobStatus = http.StatusForbidden }) }, printer.InternalError},
+	}
+
+	for _, tt := range tests {
+		tt.setup()
+		m.update(ctx)
+
+		if got := m.State(); got != tt.want {
+			t.Errorf("%s: state = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if detail := m.ErrorDetail(); detail == nil || detail.Code == nil || *detail.Code != http.StatusForbidden {
+		t.Errorf("ErrorDetail() = %+v, want code 403", detail)
+	}
+
+	f.set(func() { f.jobStatus = 0; f.printerStatus = http.StatusOK })
+	f.setJob("Offline after error", "", nil, nil)
+	f.set(func() { f.job["error"] = "SERIAL ERROR" })
+	m.update(ctx)
+	if detail := m.ErrorDetail(); m.State() != printer.Error || detail == nil || detail.Message != "SERIAL ERROR" {
+		t.Errorf("state = %s, ErrorDetail() = %+v", m.State(), detail)
+	}
+	if m.Message() != "SERIAL ERROR" {
+		t.Errorf("Message() = %q", m.Message())
+	}
+}
+
+func TestUpdateTracksJob(t *testing.T) {
+	f := newFakeOctoPrint(t)
+	m := newTestMonitor(t, f)
+	ctx := context.Background()
+
+	m.update(ctx)
+	if j := m.Job(); j != nil {
+		t.Fatalf("job %+v before any print", j)
+	}
+
+	f.setJob("Printing", "parts/benchy.gcode", 42.5, 30.0)
+	f.set(func() { f.job["progress"].(map[string]any)["printTimeLeft"] = 60.0 })
+	m.update(ctx)
+
+	j := m.Job()
+	if j == nil || j.Status != "in_progress" || j.Name != "parts/benchy.gcode" {
+		t.Fatalf("job = %+v", j)
+	}
+	if !strings.HasPrefix(j.JobId, "parts/benchy.gcode@") {
+		t.Errorf("JobId = %q", j.JobId)
+	}
+	if j.Progress == nil || *j.Progress != 0.425 {
+		t.Errorf("Progress = %v", j.Progress)
+	}
+	if j.PrintDuration == nil || *j.PrintDuration != 30 {
+		t.Errorf("PrintDuration = %v", j.PrintDuration)
+	}
+	if j.EstimatedRemaining == nil || *j.EstimatedRemaining != 60 {
+		t.Errorf("EstimatedRemaining = %v", j.EstimatedRemaining)
+	}
+	if j.StartTime == nil || time.Since(*j.StartTime) < 30*time.Second {
+		t.Errorf("StartTime = %v, want 30s ago", j.StartTime)
+	}
+
+	// The same job while it runs on.
+	jobId := j.JobId
+	f.setJob("Paused", "parts/benchy.gcode", 50.0, 40.0)
+	m.update(ctx)
+	if j := m.Job(); j.JobId != jobId || j.Status != "in_progress" {
+		t.Fatalf("job = %+v, want %s still in progress", j, jobId)
+	}
+
+	tests := []struct {
+		state      string
+		completion any
+		status     string
+	}{
+		{"Operational", 100.0, "completed"},
+		{"Operational", 50.0, "cancelled"},
+		{"Error", 50.0, "error"},
+	}
+
+	for _, tt := range tests {
+		f.setJob("Printing", "parts/benchy.gcode", 10.0, 5.0)
+		m.update(ctx)
+		f.setJob(tt.state, "parts/benchy.gcode", tt.completion, 5.0)
+		m.update(ctx)
+
+		j := m.Job()
+		if j.Status != tt.status || j.EndTime == nil || j.Progress != nil {
+			t.Errorf("%s at %v: job = %+v, want %s", tt.state, tt.completion, j, tt.status)
+		}
+	}
+}
+
+func TestThumbnailPlugin(t *testing.T) {
+	f := newFakeOctoPrint(t)
+	m := newTestMonitor(t, f)
+	ctx := context.Background()
+
+	// Without the plugin, files have no thumbnail.
+	f.setJob("Printing", "cube.gcode", 1.0, 1.0)
+	m.update(ctx)
+	if m.Job().HasThumbnail {
+		t.Error("thumbnail without the plugin")
+	}
+	if _, err := m.LatestThumbnail(ctx, new(bytes.Buffer)); !errors.Is(err, printer.ErrNoThumbnail) {
+		t.Errorf("LatestThumbnail() = %v, want ErrNoThumbnail", err)
+	}
+
+	f.set(func() { f.thumbnail = "plugin/prusaslicerthumbnails/thumbnail/benchy.png?20240101" })
+	f.setJob("Printing", "benchy.gcode", 1.0, 1.0)
+	m.update(ctx)
+	if !m.Job().HasThumbnail {
+		t.Fatal("no thumbnail with the plugin")
+	}
+
+	var buf bytes.Buffer
+	contentType, err := m.LatestThumbnail(ctx, &buf)
+	if err != nil || contentType != "image/png" || buf.String() != "png:benchy.png" {
+		t.Errorf("LatestThumbnail() = %q, %v, body %q", contentType, err, buf.String())
+	}
+
+	// Files printed from the SD card are not in OctoPrint's storage.
+	f.setJob("Printing", "sd:BENCHY~1.GCO", 1.0, 1.0)
+	m.update(ctx)
+	if m.Job().HasThumbnail {
+		t.Error("thumbnail for an SD card file")
+	}
+}
+
+func TestJobCommands(t *testing.T) {
+	f := newFakeOctoPrint(t)
+	m := newTestMonitor(t, f)
+	ctx := context.Background()
+
+	tests := []struct {
+		send func(context.Context) error
+		want string
+	}{
+		{m.PausePrint, "pause pause"},
+		{m.ResumePrint, "pause resume"},
+		{m.CancelPrint, "cancel"},
+		{func(ctx context.Context) error { return m.SetStatusMessage(ctx, "hello") }, "M117 hello"},
+	}
+
+	for _, tt := range tests {
+		if err := tt.send(ctx); err != nil {
+			t.Fatal(err)
+		}
+		testutil.WaitFor(t, f.commands, tt.want)
+	}
+}
+
+func TestNewMonitorRequiresApiKey(t *testing.T) {
+	if _, err := NewMonitor("mk3", "http://octopi.local", Options{}, printer.MonitorConfig{}, zap.NewNop().Sugar()); err == nil {
+		t.Error("created a monitor without an API key")
+	}
+}
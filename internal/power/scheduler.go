@@ -0,0 +1,185 @@
+package power
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultMaxTemperature is the temperature, in °C, below which a printer
+// counts as cold unless configured otherwise.
+const DefaultMaxTemperature = 50
+
+// Config tells when the Scheduler powers printers down.
+type Config struct {
+	// IdleTime is how long a printer has to be Ready and cold before it is
+	// powered down.
+	IdleTime time.Duration
+	// MaxTemperature is what every heater and sensor has to be below, in
+	// °C, for the printer to count as cold.
+	MaxTemperature float64
+	// Devices are the power devices to switch off, all of a printer's if
+	// empty.
+	Devices []string
+}
+
+// checkInterval is how often the Scheduler looks at the printers.
+const checkInterval = 30 * time.Second
+
+// Scheduler powers down printers that have been Ready and cold for a while.
+// Printers that cannot report their temperatures or switch their power
+// (printer.TemperatureReporter, printer.PowerController) are left alone.
+type Scheduler struct {
+	config   Config
+	monitors map[string]printer.Printer
+	logger   *zap.SugaredLogger
+
+	// idleSince is when each printer was last seen becoming Ready and cold.
+	idleSince map[string]time.Time
+	// poweredDown holds the printers powered down since they became idle.
+	poweredDown map[string]bool
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func NewScheduler(config Config, monitors map[string]printer.Printer, logger *zap.SugaredLogger) *Scheduler {
+	return &Scheduler{
+		config:      config,
+		monitors:    monitors,
+		logger:      logger,
+		idleSince:   make(map[string]time.Time),
+		poweredDown: make(map[string]bool),
+	}
+}
+
+func (s *Scheduler) Start(ctx context.Context) {
+	if s.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.ctx = ctx
+	s.cancelFunc = cancel
+
+	ticker := time.NewTicker(checkInterval)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case now := <-ticker.C:
+				s.check(ctx, now)
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) Stop() {
+	if s.ctx != nil {
+		s.cancelFunc()
+
+		s.ctx = nil
+		s.cancelFunc = nil
+	}
+}
+
+func (s *Scheduler) check(ctx context.Context, now time.Time) {
+	for key, p := range s.monitors {
+		pc, ok := p.(printer.PowerController)
+		if !ok {
+			continue
+		}
+
+		if !s.idle(p) {
+			delete(s.idleSince, key)
+			delete(s.poweredDown, key)
+			continue
+		}
+
+		since, ok := s.idleSince[key]
+		if !ok {
+			s.idleSince[key] = now
+			continue
+		}
+
+		if s.poweredDown[key] || now.Sub(since) < s.config.IdleTime {
+			continue
+		}
+
+		if err := s.powerDown(ctx, p, pc); err != nil {
+			s.logger.Errorf("Failed to power down printer %s: %s\n", key, err)
+			continue
+		}
+
+		s.poweredDown[key] = true
+	}
+}
+
+// idle tells whether p is Ready and all its heaters and sensors are off and
+// below the configured temperature.
+func (s *Scheduler) idle(p printer.Printer) bool {
+	if p.State() != printer.Ready {
+		return false
+	}
+
+	tr, ok := p.(printer.TemperatureReporter)
+	if !ok {
+		return false
+	}
+
+	temperatures := tr.Temperatures()
+	if len(temperatures) == 0 {
+		return false
+	}
+
+	for _, t := range temperatures {
+		if t.Current >= s.config.MaxTemperature || (t.Target != nil && *t.Target > 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// powerDown switches off those of the configured devices that are on. It
+// refuses to while the printer has an active job, in case one started
+// since it was found idle.
+func (s *Scheduler) powerDown(ctx context.Context, p printer.Printer, pc printer.PowerController) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	devices, err := pc.PowerDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		if d.Status != printer.PowerOn {
+			continue
+		}
+
+		if len(s.config.Devices) > 0 && !slices.Contains(s.config.Devices, d.Name) {
+			continue
+		}
+
+		if state := p.State(); state.HasActiveJob() {
+			s.logger.Warnf("Not powering down %s while it is %s\n", p.PrinterName(), state)
+			return nil
+		}
+
+		s.logger.Infof("Powering down %s, idle for %s: switching off %s\n",
+			p.PrinterName(), s.config.IdleTime, d.Name)
+
+		if err := pc.SetPower(ctx, d.Name, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
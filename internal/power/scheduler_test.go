@@ -0,0 +1,187 @@
+package power
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// powerPrinter is a printer.Printer that reports temperatures and has power
+// devices. Only the methods the Scheduler uses are implemented.
+type powerPrinter struct {
+	printer.Printer
+
+	state        printer.PrinterState
+	temperatures []printer.Temperature
+	devices      []printer.PowerDevice
+	// switchedOff lists the devices switched off, in order.
+	switchedOff []string
+}
+
+func newPowerPrinter() *powerPrinter {
+	return &powerPrinter{
+		state:        printer.Ready,
+		temperatures: []printer.Temperature{heater(printer.TemperatureNozzle, 30, 0), heater(printer.TemperatureBed, 25, 0)},
+		devices: []printer.PowerDevice{
+			{Name: "printer", Status: printer.PowerOn},
+			{Name: "lights", Status: printer.PowerOn},
+			{Name: "dryer", Status: printer.PowerOff},
+		},
+	}
+}
+
+func heater(kind printer.TemperatureKind, current, target float64) printer.Temperature {
+	return printer.Temperature{Name: string(kind), Kind: kind, Current: current, Target: &target}
+}
+
+func (p *powerPrinter) PrinterName() string                 { return "test" }
+func (p *powerPrinter) State() printer.PrinterState         { return p.state }
+func (p *powerPrinter) Temperatures() []printer.Temperature { return p.temperatures }
+func (p *powerPrinter) PowerDevices(_ context.Context) ([]printer.PowerDevice, error) {
+	return slices.Clone(p.devices), nil
+}
+
+func (p *powerPrinter) SetPower(_ context.Context, name string, on bool) error {
+	for i := range p.devices {
+		if p.devices[i].Name == name {
+			p.devices[i].Status = printer.PowerOff
+			if on {
+				p.devices[i].Status = printer.PowerOn
+			}
+		}
+	}
+	if !on {
+		p.switchedOff = append(p.switchedOff, name)
+	}
+
+	return nil
+}
+
+const idleTime = 10 * time.Minute
+
+func newTestScheduler(p *powerPrinter, devices ...string) *Scheduler {
+	config := Config{IdleTime: idleTime, MaxTemperature: DefaultMaxTemperature, Devices: devices}
+	return NewScheduler(config, map[string]printer.Printer{"test": p}, zap.NewNop().Sugar())
+}
+
+func TestPowersDownAfterIdleTime(t *testing.T) {
+	p := newPowerPrinter()
+	s := newTestScheduler(p)
+	ctx := context.Background()
+	t0 := time.Now()
+
+	s.check(ctx, t0)
+	s.check(ctx, t0.Add(idleTime-time.Second))
+	if len(p.switchedOff) != 0 {
+		t.Fatalf("switched off %v before the idle time", p.switchedOff)
+	}
+
+	s.check(ctx, t0.Add(idleTime))
+	if want := []string{"printer", "lights"}; !slices.Equal(p.switchedOff, want) {
+		t.Fatalf("switched off %v, want %v", p.switchedOff, want)
+	}
+
+	p.devices[0].Status = printer.PowerOn
+	s.check(ctx, t0.Add(2*idleTime))
+	if len(p.switchedOff) != 2 {
+		t.Errorf("switched off %v again while still idle", p.switchedOff[2:])
+	}
+}
+
+func TestRefusesWithActiveJob(t *testing.T) {
+	ctx := context.Background()
+
+	for _, state := range []printer.PrinterState{printer.PrePrint, printer.Printing, printer.Pause} {
+		t.Run(string(state), func(t *testing.T) {
+			p := newPowerPrinter()
+			p.state = state
+			s := newTestScheduler(p)
+			t0 := time.Now()
+
+			s.check(ctx, t0)
+			s.check(ctx, t0.Add(2*idleTime))
+			if len(p.switchedOff) != 0 {
+				t.Errorf("switched off %v while %s", p.switchedOff, state)
+			}
+
+			// A job that started since the printer was found idle.
+			if err := s.powerDown(ctx, p, p); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.switchedOff) != 0 {
+				t.Errorf("powerDown switched off %v while %s", p.switchedOff, state)
+			}
+		})
+	}
+}
+
+func TestIdleTimerResetsWhenNotReady(t *testing.T) {
+	p := newPowerPrinter()
+	s := newTestScheduler(p)
+	ctx := context.Background()
+	t0 := time.Now()
+
+	s.check(ctx, t0)
+
+	p.state = printer.Printing
+	s.check(ctx, t0.Add(idleTime/2))
+
+	p.state = printer.Ready
+	s.check(ctx, t0.Add(idleTime))
+	s.check(ctx, t0.Add(idleTime+idleTime/2))
+	if len(p.switchedOff) != 0 {
+		t.Fatalf("switched off %v counting the idle time from before the print", p.switchedOff)
+	}
+
+	s.check(ctx, t0.Add(2*idleTime))
+	if len(p.switchedOff) == 0 {
+		t.Error("not switched off once idle since the print")
+	}
+}
+
+func TestTemperatureThreshold(t *testing.T) {
+	chamber := func(current float64) printer.Temperature {
+		return printer.Temperature{Name: "chamber", Kind: printer.TemperatureChamber, Current: current}
+	}
+
+	tests := []struct {
+		name         string
+		temperatures []printer.Temperature
+		want         bool
+	}{
+		{"cold", []printer.Temperature{heater(printer.TemperatureNozzle, 49.9, 0), chamber(30)}, true},
+		{"at the threshold", []printer.Temperature{heater(printer.TemperatureNozzle, DefaultMaxTemperature, 0)}, false},
+		{"warm sensor", []printer.Temperature{heater(printer.TemperatureNozzle, 25, 0), chamber(55)}, false},
+		{"heater on", []printer.Temperature{heater(printer.TemperatureBed, 25, 60)}, false},
+		{"no readings", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPowerPrinter()
+			p.temperatures = tt.temperatures
+			s := newTestScheduler(p)
+
+			if got := s.idle(p); got != tt.want {
+				t.Errorf("idle() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	p := newPowerPrinter()
+	s := newTestScheduler(p, "lights", "dryer")
+
+	if err := s.powerDown(context.Background(), p, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"lights"}; !slices.Equal(p.switchedOff, want) {
+		t.Errorf("switched off %v, want %v", p.switchedOff, want)
+	}
+}
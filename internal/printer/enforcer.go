@@ -0,0 +1,406 @@
+package printer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// JobCommander is the set of printer commands Enforcer issues. Each backend
+// implements it over its own transport.
+type JobCommander interface {
+	PausePrint(ctx context.Context) error
+	ResumePrint(ctx context.Context) error
+	CancelPrint(ctx context.Context) error
+	// SetStatusMessage shows msg on the printer's display. Backends whose
+	// printers have no way to show a message return nil.
+	SetStatusMessage(ctx context.Context, msg string) error
+}
+
+// ErrPausedByMonitor is returned by JobController.Resume for a print the
+// monitor paused, which it would only pause again until the job is
+// registered or unregistered printing is allowed.
+var ErrPausedByMonitor = errors.New("print was paused by the monitor, register the job first")
+
+// ErrInvalidPauseAt is returned by Enforcer.SchedulePause for a PauseAt
+// without exactly one positive value.
+var ErrInvalidPauseAt = errors.New("set either a layer or a z_height above 0")
+
+// Observation is what Enforcer needs to know about a printer at one update.
+type Observation struct {
+	State         PrinterState
+	PrintDuration time.Duration
+	Progress      float32 // 0..1
+
+	// DisplayMessage is the message currently shown on the printer, used to
+	// avoid resending it. Backends that can't read it back pass the last
+	// message they sent through SetStatusMessage.
+	DisplayMessage string
+
+	// CurrentLayer is nil when the backend doesn't know it, see Job.
+	CurrentLayer *int
+	// ZHeight is the height of the current layer, or the nozzle's Z where
+	// the backend can't tell layers apart. Nil when it is unknown.
+	ZHeight *float64
+}
+
+// Enforcer holds a printer's job-registration state and applies the
+// MonitorConfig pause/cancel policy on every update. Each backend owns one so
+// that all of them enforce identically; it is safe for concurrent use.
+type Enforcer struct {
+	config    MonitorConfig
+	commander JobCommander
+	logger    *zap.SugaredLogger
+
+	mu                 sync.Mutex
+	registeredJobId    string
+	allowNoRegPrint    bool
+	jobPausedByMonitor bool
+	lastMessage        string
+	displayMessage     string
+
+	// scheduledPause is pending until it happens or the job it was
+	// scheduled for ends; scheduledPauseJob tells that the job started.
+	scheduledPause    *PauseAt
+	scheduledPauseJob bool
+	// jobPausedBySchedule is set from the scheduled pause until the job
+	// resumes; scheduledPauseHeld once the printer actually paused.
+	jobPausedBySchedule bool
+	scheduledPauseHeld  bool
+}
+
+func NewEnforcer(config MonitorConfig, commander JobCommander, logger *zap.SugaredLogger) *Enforcer {
+	return &Enforcer{
+		config:          config,
+		commander:       commander,
+		logger:          logger,
+		allowNoRegPrint: true,
+	}
+}
+
+func (e *Enforcer) Config() MonitorConfig {
+	return e.config
+}
+
+func (e *Enforcer) RegisteredJobId() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.registeredJobId
+}
+
+func (e *Enforcer) AllowNoRegPrint() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.allowNoRegPrint
+}
+
+func (e *Enforcer) JobPausedByMonitor() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.jobPausedByMonitor
+}
+
+func (e *Enforcer) JobPausedBySchedule() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.jobPausedBySchedule
+}
+
+func (e *Enforcer) ScheduledPause() *PauseAt {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.scheduledPause
+}
+
+// SchedulePause pauses the current job, or the next one if none is active,
+// once it reaches at. It replaces any pause scheduled before. Unlike a
+// pause for an unregistered job, the Enforcer never resumes it.
+func (e *Enforcer) SchedulePause(at PauseAt) error {
+	validLayer := at.Layer != nil && *at.Layer > 0
+	validZ := at.ZHeight != nil && *at.ZHeight > 0
+	if validLayer == validZ || (at.Layer != nil && !validLayer) || (at.ZHeight != nil && !validZ) {
+		return ErrInvalidPauseAt
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.scheduledPause = &at
+	e.scheduledPauseJob = false
+
+	return nil
+}
+
+func (e *Enforcer) CancelScheduledPause() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.scheduledPause = nil
+}
+
+// CheckManualResume returns ErrPausedByMonitor if a manual resume would be
+// undone by the next Enforce.
+func (e *Enforcer) CheckManualResume() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.jobPausedByMonitor && !e.allowNoRegPrint && e.registeredJobId == "" {
+		return ErrPausedByMonitor
+	}
+
+	return nil
+}
+
+// SetRegisteredJobId registers jobId and, when ctx is non-nil (i.e. the
+// backend is running), clears any warning the monitor put on the display.
+func (e *Enforcer) SetRegisteredJobId(ctx context.Context, jobId string) {
+	e.mu.Lock()
+	e.registeredJobId = jobId
+	e.mu.Unlock()
+
+	if ctx != nil && jobId != "" {
+		if err := e.clearMessage(ctx); err != nil {
+			e.logger.Errorf("Error clearing message: %s\n", err)
+		}
+	}
+}
+
+// SetAllowNoRegPrint behaves like SetRegisteredJobId for the allow flag.
+func (e *Enforcer) SetAllowNoRegPrint(ctx context.Context, allow bool) {
+	e.mu.Lock()
+	e.allowNoRegPrint = allow
+	e.mu.Unlock()
+
+	if ctx != nil && allow {
+		if err := e.clearMessage(ctx); err != nil {
+			e.logger.Errorf("Error clearing message: %s\n", err)
+		}
+	}
+}
+
+// ClearMessage clears the display if it still shows the Enforcer's last
+// message, as SetRegisteredJobId does for a non-nil ctx.
+func (e *Enforcer) ClearMessage(ctx context.Context) error {
+	return e.clearMessage(ctx)
+}
+
+// ClearStaleRegistration forgets the registered job ID unless it matches
+// activeJobId, the ID of the job currently in progress ("" when none is).
+func (e *Enforcer) ClearStaleRegistration(activeJobId string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if activeJobId == "" || e.registeredJobId != activeJobId {
+		e.registeredJobId = ""
+	}
+}
+
+// Enforce applies the policy to obs: it warns, pauses or cancels an
+// unregistered print, and resumes a print it paused itself once the print
+// becomes allowed.
+func (e *Enforcer) Enforce(ctx context.Context, obs Observation) {
+	e.mu.Lock()
+	e.displayMessage = obs.DisplayMessage
+
+	if obs.State == Ready {
+		// The job it paused is over, e.g. cancelled by hand, so the next
+		// job gets its own grace period.
+		e.jobPausedByMonitor = false
+		e.jobPausedBySchedule = false
+		e.scheduledPauseHeld = false
+
+		if e.scheduledPauseJob {
+			// The job ended before reaching the scheduled pause.
+			e.scheduledPause = nil
+			e.scheduledPauseJob = false
+		}
+	} else if obs.State.HasActiveJob() && e.scheduledPause != nil {
+		e.scheduledPauseJob = true
+	}
+
+	resumedFromSchedule := false
+	if obs.State == Pause && e.jobPausedBySchedule {
+		e.scheduledPauseHeld = true
+	} else if obs.State == Printing && e.scheduledPauseHeld {
+		e.jobPausedBySchedule = false
+		e.scheduledPauseHeld = false
+		resumedFromSchedule = true
+	}
+
+	shouldPauseAsScheduled := obs.State == Printing && e.scheduledPause != nil &&
+		e.scheduledPause.reached(obs)
+	if shouldPauseAsScheduled {
+		e.scheduledPause = nil
+		e.scheduledPauseJob = false
+		e.jobPausedBySchedule = true
+	}
+
+	printerShouldPrint := e.allowNoRegPrint || e.registeredJobId != ""
+
+	shouldCancel := false
+
+	// Check if printer is illegally printing
+	if obs.State == Printing && !printerShouldPrint {
+		e.logger.Infoln("Printer should not print now!!")
+
+		if obs.PrintDuration > e.config.NoPauseDuration ||
+			(e.config.ShouldPauseProgress > 0 && obs.Progress >= e.config.ShouldPauseProgress) {
+			e.jobPausedByMonitor = true
+		}
+
+		shouldCancel = e.config.ShouldCancelProgress > 0 && obs.Progress >= e.config.ShouldCancelProgress
+	}
+
+	// The scheduled pause already pauses the printer.
+	shouldPause := obs.State == Printing && e.jobPausedByMonitor && !shouldPauseAsScheduled
+	shouldWarn := obs.State == Printing && !e.jobPausedByMonitor && !printerShouldPrint
+
+	shouldResume := false
+	if e.jobPausedByMonitor && printerShouldPrint {
+		// A job that also reached its scheduled pause stays paused, the
+		// user resumes it once done, e.g. with the filament change.
+		shouldResume = obs.State == Pause && !e.jobPausedBySchedule
+		e.jobPausedByMonitor = false
+	}
+	e.mu.Unlock()
+
+	if shouldCancel {
+		e.logger.Infoln("Canceling")
+		if err := e.commander.CancelPrint(ctx); err != nil {
+			e.logger.Errorf("Failed to cancel printing: %s\n", err)
+		}
+	}
+
+	if shouldPauseAsScheduled {
+		e.logger.Infoln("Pausing as scheduled")
+
+		if err := e.commander.PausePrint(ctx); err != nil {
+			e.logger.Errorf("Error pausing the printer: %s\n", err)
+		}
+
+		data := struct {
+			Layer   int
+			ZHeight float64
+		}{}
+		if obs.CurrentLayer != nil {
+			data.Layer = *obs.CurrentLayer
+		}
+		if obs.ZHeight != nil {
+			data.ZHeight = *obs.ZHeight
+		}
+
+		if e.config.ScheduledPauseMessage != nil {
+			var tpl bytes.Buffer
+			if err := e.config.ScheduledPauseMessage.Execute(&tpl, data); err != nil {
+				e.logger.Errorf("Error executing the scheduled pause message: %s\n", err)
+			} else if tpl.Len() > 0 {
+				if err := e.updateStatusMessage(ctx, tpl.String()); err != nil {
+					e.logger.Errorln(err)
+				}
+			}
+		}
+	}
+
+	if resumedFromSchedule {
+		if err := e.clearMessage(ctx); err != nil {
+			e.logger.Errorln(err)
+		}
+	}
+
+	// Pause printer if printer should be paused by monitor
+	if shouldPause {
+		e.logger.Infoln("Pausing")
+
+		if err := e.commander.PausePrint(ctx); err != nil {
+			e.logger.Errorf("Error pausing the printer: %s\n", err)
+		}
+
+		data := struct {
+		}{}
+
+		var tpl bytes.Buffer
+		if err := e.config.PauseMessage.Execute(&tpl, data); err != nil {
+			e.logger.Errorf("Error pausing the pause message: %s\n", err)
+		} else if err := e.updateStatusMessage(ctx, tpl.String()); err != nil {
+			e.logger.Errorln(err)
+		}
+	}
+
+	// Show warning countdown if printer will be paused
+	if shouldWarn {
+		remDuration := (e.config.NoPauseDuration - obs.PrintDuration).Round(time.Second)
+
+		data := struct {
+			RemainDurationStr string
+		}{remDuration.String()}
+
+		var tpl bytes.Buffer
+		if err := e.config.WillPauseMessage.Execute(&tpl, data); err != nil {
+			e.logger.Errorf("Error pausing the will pause message: %s\n", err)
+		} else if err := e.updateStatusMessage(ctx, tpl.String()); err != nil {
+			e.logger.Errorln(err)
+		}
+	}
+
+	// Resume print if allow print set to true
+	if shouldResume {
+		e.logger.Infoln("Resuming")
+
+		if err := e.commander.ResumePrint(ctx); err != nil {
+			e.logger.Errorf("Error resuming the printer: %s\n", err)
+		}
+
+		if err := e.clearMessage(ctx); err != nil {
+			e.logger.Errorln(err)
+		}
+	}
+}
+
+func (e *Enforcer) updateStatusMessage(ctx context.Context, msg string) error {
+	e.mu.Lock()
+	if e.displayMessage == msg {
+		e.mu.Unlock()
+		return nil
+	}
+
+	e.lastMessage = msg
+	e.mu.Unlock()
+
+	return e.commander.SetStatusMessage(ctx, msg)
+}
+
+func (e *Enforcer) clearMessage(ctx context.Context) error {
+	e.mu.Lock()
+	isOurs := e.lastMessage == e.displayMessage
+	e.mu.Unlock()
+
+	if isOurs {
+		return e.updateStatusMessage(ctx, "")
+	}
+
+	return nil
+}
+
+// reached tells whether obs is at or past p. Z is only looked at once the
+// job prints, as the start G-code moves the nozzle up and down, e.g. to
+// probe or purge.
+func (p *PauseAt) reached(obs Observation) bool {
+	if p.Layer != nil {
+		return obs.CurrentLayer != nil && *obs.CurrentLayer >= *p.Layer
+	}
+
+	if p.ZHeight != nil {
+		return obs.PrintDuration > 0 && obs.ZHeight != nil && *obs.ZHeight >= *p.ZHeight
+	}
+
+	return false
+}
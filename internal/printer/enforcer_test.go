@@ -0,0 +1,336 @@
+package printer
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type fakeCommander struct {
+	calls []string
+}
+
+func (c *fakeCommander) PausePrint(context.Context) error {
+	c.calls = append(c.calls, "pause")
+	return nil
+}
+
+func (c *fakeCommander) ResumePrint(context.Context) error {
+	c.calls = append(c.calls, "resume")
+	return nil
+}
+
+func (c *fakeCommander) CancelPrint(context.Context) error {
+	c.calls = append(c.calls, "cancel")
+	return nil
+}
+
+func (c *fakeCommander) SetStatusMessage(_ context.Context, msg string) error {
+	c.calls = append(c.calls, "message:"+msg)
+	return nil
+}
+
+func newTestEnforcer(c *fakeCommander) *Enforcer {
+	return NewEnforcer(MonitorConfig{
+		NoPauseDuration:       time.Minute,
+		WillPauseMessage:      template.Must(template.New("").Parse("will pause")),
+		PauseMessage:          template.Must(template.New("").Parse("paused")),
+		ScheduledPauseMessage: template.Must(template.New("").Parse("layer {{.Layer}}")),
+	}, c, zap.NewNop().Sugar())
+}
+
+func intPtr(i int) *int { return &i }
+
+func floatPtr(f float64) *float64 { return &f }
+
+func count(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSchedulePauseValidates(t *testing.T) {
+	e := newTestEnforcer(&fakeCommander{})
+
+	for _, at := range []PauseAt{
+		{},
+		{Layer: intPtr(0)},
+		{ZHeight: floatPtr(-1)},
+		{Layer: intPtr(3), ZHeight: floatPtr(1)},
+		{Layer: intPtr(3), ZHeight: floatPtr(0)},
+	} {
+		if err := e.SchedulePause(at); err != ErrInvalidPauseAt {
+			t.Errorf("SchedulePause(%+v) = %v, want ErrInvalidPauseAt", at, err)
+		}
+	}
+
+	if err := e.SchedulePause(PauseAt{Layer: intPtr(3)}); err != nil {
+		t.Errorf("SchedulePause(layer 3) = %v", err)
+	}
+}
+
+func TestScheduledPauseAtLayer(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+
+	if err := e.SchedulePause(PauseAt{Layer: intPtr(5)}); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Enforce(ctx, Observation{State: Ready})
+	if e.ScheduledPause() == nil {
+		t.Fatal("schedule dropped before the job started")
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, CurrentLayer: intPtr(4)})
+	if len(c.calls) != 0 {
+		t.Fatalf("calls before layer 5: %v", c.calls)
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, CurrentLayer: intPtr(5)})
+	if !slices.Equal(c.calls, []string{"pause", "message:layer 5"}) {
+		t.Fatalf("calls at layer 5: %v", c.calls)
+	}
+	if e.ScheduledPause() != nil || !e.JobPausedBySchedule() || e.JobPausedByMonitor() {
+		t.Fatal("pause not recorded as scheduled")
+	}
+
+	e.Enforce(ctx, Observation{State: Pause, CurrentLayer: intPtr(5), DisplayMessage: "layer 5"})
+	e.Enforce(ctx, Observation{State: Pause, CurrentLayer: intPtr(5), DisplayMessage: "layer 5"})
+	if count(c.calls, "resume") != 0 {
+		t.Fatal("scheduled pause resumed by the monitor")
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, CurrentLayer: intPtr(6), DisplayMessage: "layer 5"})
+	if e.JobPausedBySchedule() {
+		t.Fatal("still held after a manual resume")
+	}
+	if c.calls[len(c.calls)-1] != "message:" {
+		t.Fatalf("message not cleared after resume: %v", c.calls)
+	}
+}
+
+func TestScheduledPauseDroppedWithJob(t *testing.T) {
+	e := newTestEnforcer(&fakeCommander{})
+	ctx := context.Background()
+
+	if err := e.SchedulePause(PauseAt{ZHeight: floatPtr(10)}); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, ZHeight: floatPtr(3)})
+	e.Enforce(ctx, Observation{State: Ready})
+	if e.ScheduledPause() != nil {
+		t.Fatal("schedule kept after its job ended")
+	}
+}
+
+func TestScheduledPauseAtZWaitsForPrinting(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+
+	if err := e.SchedulePause(PauseAt{ZHeight: floatPtr(5)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Start G-code lifting the nozzle, e.g. to purge.
+	e.Enforce(ctx, Observation{State: Printing, ZHeight: floatPtr(10)})
+	if count(c.calls, "pause") != 0 {
+		t.Fatal("paused during the start G-code")
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, ZHeight: floatPtr(0.2)})
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Minute / 2, ZHeight: floatPtr(5)})
+	if count(c.calls, "pause") != 1 {
+		t.Fatalf("calls at Z 5: %v", c.calls)
+	}
+}
+
+func TestScheduledPauseNotResumedByRegistration(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	if err := e.SchedulePause(PauseAt{Layer: intPtr(10)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The unregistered job runs out of grace at the scheduled layer.
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: 2 * time.Minute, CurrentLayer: intPtr(10)})
+	if count(c.calls, "pause") != 1 {
+		t.Fatalf("calls: %v", c.calls)
+	}
+	if !e.JobPausedByMonitor() || !e.JobPausedBySchedule() {
+		t.Fatal("pause not recorded as both")
+	}
+
+	e.SetRegisteredJobId(ctx, "job")
+	e.Enforce(ctx, Observation{State: Pause, PrintDuration: 2 * time.Minute, CurrentLayer: intPtr(10)})
+	if count(c.calls, "resume") != 0 {
+		t.Fatalf("registration resumed a scheduled pause: %v", c.calls)
+	}
+	if e.JobPausedByMonitor() || !e.JobPausedBySchedule() {
+		t.Fatal("registration should only clear the monitor's pause")
+	}
+}
+
+func TestUnregisteredPrintPausedAndResumed(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second})
+	if !slices.Equal(c.calls, []string{"message:will pause"}) {
+		t.Fatalf("calls in the grace period: %v", c.calls)
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: 2 * time.Minute, DisplayMessage: "will pause"})
+	if count(c.calls, "pause") != 1 || e.CheckManualResume() != ErrPausedByMonitor {
+		t.Fatalf("calls after the grace period: %v", c.calls)
+	}
+
+	e.SetRegisteredJobId(ctx, "job")
+	e.Enforce(ctx, Observation{State: Pause, PrintDuration: 2 * time.Minute})
+	if count(c.calls, "resume") != 1 {
+		t.Fatalf("not resumed after registration: %v", c.calls)
+	}
+}
+
+func TestPrintAllowed(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+
+	// Unregistered printing is allowed until it is turned off.
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Hour, Progress: 0.99})
+
+	e.SetAllowNoRegPrint(ctx, false)
+	e.SetRegisteredJobId(ctx, "job")
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Hour, Progress: 0.99})
+
+	if len(c.calls) != 0 {
+		t.Fatalf("calls: %v", c.calls)
+	}
+}
+
+func TestUnregisteredPrintPausedAtProgress(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	e.config.ShouldPauseProgress = 0.5
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, Progress: 0.4, DisplayMessage: "will pause"})
+	if len(c.calls) != 0 {
+		t.Fatalf("calls before 50%%: %v", c.calls)
+	}
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, Progress: 0.5, DisplayMessage: "will pause"})
+	if !slices.Equal(c.calls, []string{"pause", "message:paused"}) {
+		t.Fatalf("calls at 50%%: %v", c.calls)
+	}
+}
+
+func TestUnregisteredPrintCancelled(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	e.config.ShouldCancelProgress = 0.9
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second, Progress: 0.95})
+	if count(c.calls, "cancel") != 1 || count(c.calls, "pause") != 0 {
+		t.Fatalf("calls: %v", c.calls)
+	}
+}
+
+func TestPauseForgottenOnceReady(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: 2 * time.Minute})
+	if !e.JobPausedByMonitor() {
+		t.Fatal("not paused")
+	}
+
+	// The paused job was cancelled by hand; the next one gets its grace
+	// period.
+	e.Enforce(ctx, Observation{State: Ready})
+	if e.JobPausedByMonitor() || e.CheckManualResume() != nil {
+		t.Fatal("pause kept after the job ended")
+	}
+
+	c.calls = nil
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second})
+	if count(c.calls, "pause") != 0 {
+		t.Fatalf("next job paused in its grace period: %v", c.calls)
+	}
+
+	e.SetAllowNoRegPrint(ctx, true)
+	e.Enforce(ctx, Observation{State: Pause, PrintDuration: time.Second})
+	if count(c.calls, "resume") != 0 {
+		t.Fatalf("resumed a pause that was not the monitor's: %v", c.calls)
+	}
+}
+
+func TestMessageNotResent(t *testing.T) {
+	c := &fakeCommander{}
+	e := newTestEnforcer(c)
+	ctx := context.Background()
+	e.SetAllowNoRegPrint(ctx, false)
+
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: time.Second})
+	e.Enforce(ctx, Observation{State: Printing, PrintDuration: 2 * time.Second, DisplayMessage: "will pause"})
+	if !slices.Equal(c.calls, []string{"message:will pause"}) {
+		t.Fatalf("calls: %v", c.calls)
+	}
+
+	// Paused by hand, and someone else put up a message.
+	e.Enforce(ctx, Observation{State: Pause, PrintDuration: 2 * time.Second, DisplayMessage: "layer 3"})
+	e.SetRegisteredJobId(ctx, "job")
+	if len(c.calls) != 1 {
+		t.Fatalf("cleared a message that was not the monitor's: %v", c.calls)
+	}
+
+	e.SetRegisteredJobId(ctx, "")
+	e.Enforce(ctx, Observation{State: Pause, PrintDuration: 2 * time.Second, DisplayMessage: "will pause"})
+	e.SetRegisteredJobId(ctx, "job")
+	if c.calls[len(c.calls)-1] != "message:" {
+		t.Fatalf("warning not cleared after registration: %v", c.calls)
+	}
+}
+
+func TestClearStaleRegistration(t *testing.T) {
+	e := newTestEnforcer(&fakeCommander{})
+	e.SetRegisteredJobId(nil, "job")
+
+	e.ClearStaleRegistration("job")
+	if e.RegisteredJobId() != "job" {
+		t.Fatal("cleared the active job's registration")
+	}
+
+	e.ClearStaleRegistration("other")
+	if e.RegisteredJobId() != "" {
+		t.Fatal("kept the registration of another job")
+	}
+
+	e.SetRegisteredJobId(nil, "job")
+	e.ClearStaleRegistration("")
+	if e.RegisteredJobId() != "" {
+		t.Fatal("kept the registration with no job in progress")
+	}
+}
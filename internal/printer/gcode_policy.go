@@ -0,0 +1,75 @@
+package printer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultGCodeDeny are the commands GCodePolicy denies unless configured
+// otherwise. Each restarts Klipper or its MCUs, aborting any print.
+var DefaultGCodeDeny = []string{"FIRMWARE_RESTART", "RESTART", "SAVE_CONFIG"}
+
+// ErrGCodeNotPermitted is wrapped by GCodePolicy.Check's errors.
+var ErrGCodeNotPermitted = errors.New("command not permitted")
+
+// GCodePolicy decides which commands may be run through a Console. If Allow
+// is given, only its commands are permitted; otherwise all but Deny's are.
+// Command names are case-insensitive.
+type GCodePolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Check returns an error wrapping ErrGCodeNotPermitted for the first
+// command in script that is not permitted.
+func (p GCodePolicy) Check(script string) error {
+	for _, line := range strings.FieldsFunc(script, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		cmd := gcodeCommand(line)
+		if cmd != "" && !p.permits(cmd) {
+			return fmt.Errorf("%w: %s", ErrGCodeNotPermitted, cmd)
+		}
+	}
+
+	return nil
+}
+
+func (p GCodePolicy) permits(cmd string) bool {
+	listed := func(list []string) bool {
+		return slices.ContainsFunc(list, func(c string) bool { return strings.EqualFold(c, cmd) })
+	}
+
+	if len(p.Allow) > 0 {
+		return listed(p.Allow)
+	}
+
+	return !listed(p.Deny)
+}
+
+var gcodeWord = regexp.MustCompile(`[A-Z_]+|[A-Z*/]`)
+
+// gcodeCommand returns the command a line runs the way Klipper reads it:
+// the first word along with the number that follows it, as in "G1 X10" or
+// "M104S200", skipping a line number and comments.
+func gcodeCommand(line string) string {
+	line, _, _ = strings.Cut(line, ";")
+	line = strings.ToUpper(line)
+
+	words := gcodeWord.FindAllStringIndex(line, 3)
+	if len(words) > 0 && line[words[0][0]:words[0][1]] == "N" {
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		return ""
+	}
+
+	end := len(line)
+	if len(words) > 1 {
+		end = words[1][0]
+	}
+
+	return line[words[0][0]:words[0][1]] + strings.TrimSpace(line[words[0][1]:end])
+}
@@ -23,6 +23,12 @@ const (
 	InternalError PrinterState = "internal_error" // Internal error
 )
 
+// HasActiveJob tells whether a job is pre-printing, printing or paused in
+// state, so that the printer must keep its power.
+func (s PrinterState) HasActiveJob() bool {
+	return s == PrePrint || s == Printing || s == Pause
+}
+
 // MonitorConfig holds the job-authorization enforcement policy shared by every
 // backend.
 type MonitorConfig struct {
@@ -31,6 +37,10 @@ type MonitorConfig struct {
 	ShouldCancelProgress float32
 	WillPauseMessage     *template.Template
 	PauseMessage         *template.Template
+	// ScheduledPauseMessage is shown when a scheduled pause happens, with
+	// the .Layer and .ZHeight it happened at. Nothing is shown if it is nil
+	// or renders empty.
+	ScheduledPauseMessage *template.Template
 }
 
 // Printer is the backend-agnostic contract implemented by every printer
@@ -88,3 +98,167 @@ type Thumbnailer interface {
 type RawReporter interface {
 	RawReport() any
 }
+
+// TemperatureReporter is an optional capability for backends that can read
+// their heaters and temperature sensors. The web layer type-asserts for it
+// and returns 501 when a backend does not implement it.
+type TemperatureReporter interface {
+	// Temperatures returns the latest reading of every heater and sensor,
+	// or nil while none is known.
+	Temperatures() []Temperature
+}
+
+// JobController is an optional capability for backends that let staff
+// control the current job remotely. These are manual actions, kept apart
+// from the Enforcer's: the monitor never resumes a print paused by hand,
+// and Resume refuses with ErrPausedByMonitor to resume a print the monitor
+// paused. The web layer type-asserts for it and returns 501 when a backend
+// does not implement it.
+type JobController interface {
+	Pause(ctx context.Context) error
+	Resume(ctx context.Context) error
+	Cancel(ctx context.Context) error
+	// EmergencyStop halts the printer at once; it usually needs a firmware
+	// restart afterwards.
+	EmergencyStop(ctx context.Context) error
+}
+
+// ErrFileNotFound is returned by FileManager when the named file does not
+// exist on the printer.
+var ErrFileNotFound = errors.New("file not found")
+
+// FileManager is an optional capability for backends that store print files
+// on the printer. Paths are relative to the printer's print file storage;
+// callers are expected to have rejected paths that climb out of it. The web
+// layer type-asserts for it and returns 501 when a backend does not
+// implement it.
+type FileManager interface {
+	Files(ctx context.Context) ([]File, error)
+	// UploadFile stores r at path, replacing any file already there.
+	UploadFile(ctx context.Context, path string, r io.Reader) (*File, error)
+	DeleteFile(ctx context.Context, path string) error
+	// StartPrint prints the file at path and registers the job it starts,
+	// so that the Enforcer lets it print. It returns the job's ID.
+	StartPrint(ctx context.Context, path string) (jobId string, err error)
+}
+
+// ErrNoWebcam is returned by Webcam when the printer has no camera by the
+// given name.
+var ErrNoWebcam = errors.New("no such webcam")
+
+// Webcam is an optional capability for backends that know the cameras
+// watching the printer. Camera names come from Webcams; "" picks the first.
+// The web layer type-asserts for it and returns 501 when a backend does not
+// implement it.
+type Webcam interface {
+	// Webcams lists the camera names, the default first.
+	Webcams(ctx context.Context) ([]string, error)
+	// WebcamSnapshot writes a still image from the camera to w and returns
+	// its content type.
+	WebcamSnapshot(ctx context.Context, name string, w io.Writer) (contentType string, err error)
+	// WebcamStream opens the camera's multipart MJPEG stream, which lasts
+	// until ctx is done or the caller closes it.
+	WebcamStream(ctx context.Context, name string) (stream io.ReadCloser, contentType string, err error)
+}
+
+// GCodeError is returned by Console.RunGCode when the printer rejected the
+// script, e.g. for an unknown command or unhomed axes.
+type GCodeError struct {
+	Message string
+}
+
+func (e *GCodeError) Error() string {
+	return e.Message
+}
+
+// Console is an optional capability for backends that can run G-code and
+// show the printer's console. Which commands may be run is up to the
+// caller, see GCodePolicy. The web layer type-asserts for it and returns
+// 501 when a backend does not implement it.
+type Console interface {
+	// RunGCode runs script, one or more lines of G-code or macros, and
+	// returns the lines the printer answered with.
+	RunGCode(ctx context.Context, script string) ([]string, error)
+	// ConsoleHistory returns up to count of the latest console lines, oldest
+	// first.
+	ConsoleHistory(ctx context.Context, count int) ([]ConsoleLine, error)
+	// SubscribeConsole delivers console lines as they come until
+	// unsubscribe is called. A subscriber that falls behind misses lines.
+	SubscribeConsole() (lines <-chan ConsoleLine, unsubscribe func())
+}
+
+// ErrInvalidCursor is returned by JobHistory for a JobQuery.Cursor it did
+// not hand out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// JobHistory is an optional capability for backends that keep a history of
+// the jobs printed. The web layer type-asserts for it and returns 501 when
+// a backend does not implement it.
+type JobHistory interface {
+	// Jobs returns a page of the jobs query selects, newest first.
+	Jobs(ctx context.Context, query JobQuery) (*JobPage, error)
+}
+
+// SystemInfo is an optional capability for backends that can tell what
+// host and software a printer runs on. The web layer type-asserts for it
+// and returns 501 when a backend does not implement it.
+type SystemInfo interface {
+	// SystemInfo returns the printer's host information, cached since the
+	// backend last (re)connected.
+	SystemInfo(ctx context.Context) (*HostInfo, error)
+}
+
+// ErrNoPowerDevice is returned by PowerController.SetPower for a device the
+// printer does not have.
+var ErrNoPowerDevice = errors.New("no such power device")
+
+// PowerController is an optional capability for backends that can switch
+// the printer's power devices, e.g. smart plugs. The web layer type-asserts
+// for it and returns 501 when a backend does not implement it.
+type PowerController interface {
+	// PowerDevices lists the devices and their current status.
+	PowerDevices(ctx context.Context) ([]PowerDevice, error)
+	// SetPower switches the named device on or off.
+	SetPower(ctx context.Context, name string, on bool) error
+}
+
+// ErrNoObject is returned by ObjectExcluder.ExcludeObject for a part the
+// current job does not have.
+var ErrNoObject = errors.New("no such object")
+
+// ObjectExcluder is an optional capability for backends that can drop a
+// single part from a job while the other parts keep printing. The web layer
+// type-asserts for it and returns 501 when a backend does not implement it.
+type ObjectExcluder interface {
+	// PrintObjects returns the parts of the current job, nil when it has
+	// none the backend knows of.
+	PrintObjects() *PrintObjects
+	// ExcludeObject stops printing the named part.
+	ExcludeObject(ctx context.Context, name string) error
+}
+
+// Tuner is an optional capability for backends that can adjust speed,
+// extrusion, cooling and Z offset while printing. Which values are allowed
+// is up to the caller, see TuningLimits. The web layer type-asserts for it
+// and returns 501 when a backend does not implement it.
+type Tuner interface {
+	// Tuning returns the current values, nil while they are unknown.
+	Tuning() *Tuning
+	// SetTuning applies change.
+	SetTuning(ctx context.Context, change TuningChange) error
+}
+
+// PauseScheduler is an optional capability for backends that can pause a
+// job once it reaches a layer or height, e.g. for a filament change. The web
+// layer type-asserts for it and returns 501 when a backend does not
+// implement it.
+type PauseScheduler interface {
+	// SchedulePause replaces the scheduled pause, if any, with at.
+	SchedulePause(at PauseAt) error
+	// ScheduledPause returns the pause still to come, nil if none is.
+	ScheduledPause() *PauseAt
+	CancelScheduledPause()
+	// JobPausedBySchedule tells whether the job is held by a scheduled
+	// pause, which is only ever resumed by hand.
+	JobPausedBySchedule() bool
+}
@@ -0,0 +1,134 @@
+package printer
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Backends register themselves here from an init function, and main builds
+// every configured printer through New, so adding a backend never touches
+// main or the config parser. A printer entry selects its backend with
+// `type:` and passes backend-specific settings in `options:`.
+
+// Backend is a registered printer backend.
+type Backend struct {
+	// NewOptions returns a pointer to a new options value for the entry's
+	// `options:` block to be decoded into, with defaults already set. It
+	// may be nil for backends without options. If the value implements
+	// OptionsValidator it is validated after decoding.
+	NewOptions func() any
+
+	// New creates the printer for one config entry.
+	New func(cfg BackendConfig, logger *zap.SugaredLogger) (Printer, error)
+}
+
+// BackendConfig is one printer entry as passed to Backend.New.
+type BackendConfig struct {
+	Name string
+	Url  string
+	// Options is the value returned by Backend.NewOptions, decoded and
+	// validated, or nil.
+	Options any
+	Monitor MonitorConfig
+}
+
+// OptionsValidator is implemented by backend options that can check
+// themselves once decoded.
+type OptionsValidator interface {
+	Validate() error
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Backend)
+)
+
+// Register makes a backend available under name. It panics if name is
+// already taken or b has no New function.
+func Register(name string, b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if b.New == nil {
+		panic("printer: Register backend " + name + " without New")
+	}
+
+	if _, ok := backends[name]; ok {
+		panic("printer: Register called twice for backend " + name)
+	}
+
+	backends[name] = b
+}
+
+// Backends returns the names of all registered backends, sorted.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func lookupBackend(name string) (Backend, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	b, ok := backends[name]
+	if !ok {
+		return Backend{}, fmt.Errorf("unknown printer type '%s'", name)
+	}
+
+	return b, nil
+}
+
+// DecodeOptions builds the options value for the named backend. decode
+// fills it from the config entry (e.g. a yaml.Node's Decode method) and may
+// be nil when the entry has no `options:` block.
+func DecodeOptions(name string, decode func(v any) error) (any, error) {
+	b, err := lookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.NewOptions == nil {
+		if decode != nil {
+			return nil, fmt.Errorf("printer type '%s' takes no options", name)
+		}
+		return nil, nil
+	}
+
+	options := b.NewOptions()
+
+	if decode != nil {
+		if err := decode(options); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+
+	if v, ok := options.(OptionsValidator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+
+	return options, nil
+}
+
+// New creates a printer with the named backend. cfg.Options must come from
+// DecodeOptions for the same backend.
+func New(name string, cfg BackendConfig, logger *zap.SugaredLogger) (Printer, error) {
+	b, err := lookupBackend(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.New(cfg, logger)
+}
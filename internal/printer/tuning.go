@@ -0,0 +1,51 @@
+package printer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultTuningLimits are the TuningLimits unless configured otherwise.
+var DefaultTuningLimits = TuningLimits{
+	MinSpeedFactor:   0.2,
+	MaxSpeedFactor:   2,
+	MinExtrudeFactor: 0.8,
+	MaxExtrudeFactor: 1.2,
+	MaxZOffset:       0.5,
+}
+
+// ErrTuningOutOfBounds is wrapped by TuningLimits.Check's errors.
+var ErrTuningOutOfBounds = errors.New("tuning out of bounds")
+
+// TuningLimits bounds what a Tuner may be set to. ZOffset may be set within
+// ±MaxZOffset; FanSpeed always within 0..1.
+type TuningLimits struct {
+	MinSpeedFactor   float64
+	MaxSpeedFactor   float64
+	MinExtrudeFactor float64
+	MaxExtrudeFactor float64
+	MaxZOffset       float64
+}
+
+// Check returns an error wrapping ErrTuningOutOfBounds for the first value
+// of change outside the limits.
+func (l TuningLimits) Check(change TuningChange) error {
+	bounds := []struct {
+		name   string
+		v      *float64
+		lo, hi float64
+	}{
+		{"speed_factor", change.SpeedFactor, l.MinSpeedFactor, l.MaxSpeedFactor},
+		{"extrude_factor", change.ExtrudeFactor, l.MinExtrudeFactor, l.MaxExtrudeFactor},
+		{"fan_speed", change.FanSpeed, 0, 1},
+		{"z_offset", change.ZOffset, -l.MaxZOffset, l.MaxZOffset},
+	}
+
+	for _, b := range bounds {
+		if b.v != nil && (*b.v < b.lo || *b.v > b.hi) {
+			return fmt.Errorf("%w: %s must be within %g..%g", ErrTuningOutOfBounds, b.name, b.lo, b.hi)
+		}
+	}
+
+	return nil
+}
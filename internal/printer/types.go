@@ -30,11 +30,14 @@ type ErrorInfo struct {
 
 // Job is the neutral representation of a print job, returned by
 // Printer.Job(). It's non-nil once any job has been observed.
-// Progress/PrintDuration/TotalDuration/EstimatedRemaining are non-nil only
-// while that job is actively pre-printing/printing/paused — nil once it's no
-// longer active, since they're meaningless at that point. The
-// identity/outcome fields below are always populated once a job exists,
-// whether or not it's still active.
+// Progress/PrintDuration/TotalDuration/EstimatedRemaining, like the layer
+// and Z fields, are non-nil only while that job is actively
+// pre-printing/printing/paused — nil once it's no longer active, since
+// they're meaningless at that point. Jobs from JobHistory are the
+// exception: they carry a finished job's final
+// PrintDuration/TotalDuration/FilamentUsed. The identity/outcome fields
+// below are always populated once a job exists, whether or not it's still
+// active.
 type Job struct {
 	JobId        string `json:"job_id"`
 	Name         string `json:"name"`
@@ -62,4 +65,175 @@ type Job struct {
 	// estimate exists.
 	TotalDuration      *Seconds `json:"total_duration"`
 	EstimatedRemaining *Seconds `json:"remaining_sec"`
+	// FilamentUsed is the length of filament extruded so far, in mm.
+	FilamentUsed *float64 `json:"filament_used"`
+	// CurrentLayer counts from 1, and is 0 before the first layer started.
+	CurrentLayer *int `json:"current_layer"`
+	TotalLayer   *int `json:"total_layer"`
+	// ZHeight is the nozzle's current Z position, in mm.
+	ZHeight *float64 `json:"z_height"`
+}
+
+// JobQuery selects jobs from a JobHistory. Since and Before bound the jobs'
+// start time; nil leaves that end open.
+type JobQuery struct {
+	Since  *time.Time
+	Before *time.Time
+	Limit  int
+	// Cursor continues a listing where the JobPage it came from ended.
+	Cursor string
+}
+
+// JobPage is a page of a JobHistory listing, newest job first.
+type JobPage struct {
+	Jobs []Job `json:"jobs"`
+	// NextCursor continues the listing; empty on its last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// TemperatureKind tells what a Temperature measures, so that a dashboard
+// can pick out e.g. the nozzle without knowing each vendor's naming.
+type TemperatureKind string
+
+const (
+	TemperatureNozzle  TemperatureKind = "nozzle"
+	TemperatureBed     TemperatureKind = "bed"
+	TemperatureChamber TemperatureKind = "chamber"
+	TemperatureOther   TemperatureKind = "other"
+)
+
+// Temperature is the latest reading of one heater or sensor, in °C.
+type Temperature struct {
+	// Name is the backend's name for it (e.g. Klipper's "extruder",
+	// "heater_bed" or the "chamber" of "temperature_sensor chamber").
+	Name    string          `json:"name"`
+	Kind    TemperatureKind `json:"kind"`
+	Current float64         `json:"current"`
+	// Target is nil for sensors, and 0 for heaters that are off.
+	Target *float64 `json:"target"`
+	// Power is the heater's duty cycle, 0..1; nil for sensors or when the
+	// backend doesn't report it.
+	Power *float64 `json:"power"`
+}
+
+// File is a print file stored on a printer.
+type File struct {
+	// Path is relative to the printer's print file storage, "/"-separated.
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// Metadata is nil when the backend has none for the file, e.g. while it
+	// is still analysing a fresh upload.
+	Metadata *FileMetadata `json:"metadata"`
+}
+
+// FileMetadata is what the slicer recorded in a print file. Values the
+// slicer didn't record are zero, or nil for EstimatedTime.
+type FileMetadata struct {
+	Slicer        string  `json:"slicer"`
+	SlicerVersion string  `json:"slicer_version"`
+	LayerHeight   float64 `json:"layer_height"`  // mm
+	ObjectHeight  float64 `json:"object_height"` // mm
+	// FilamentTotal is the length of filament the print needs, in mm.
+	FilamentTotal float64  `json:"filament_total"`
+	EstimatedTime *Seconds `json:"estimated_time"`
+	HasThumbnail  bool     `json:"has_thumbnail"`
+}
+
+// ConsoleLineType tells the G-code sent to a printer apart from what it
+// answered.
+type ConsoleLineType string
+
+const (
+	ConsoleCommand  ConsoleLineType = "command"
+	ConsoleResponse ConsoleLineType = "response"
+)
+
+// ConsoleLine is a line of a printer's G-code console.
+type ConsoleLine struct {
+	Time    time.Time       `json:"time"`
+	Type    ConsoleLineType `json:"type"`
+	Message string          `json:"message"`
+}
+
+// HostInfo describes the host a printer's firmware runs on. Fields the
+// backend doesn't know are empty.
+type HostInfo struct {
+	Hostname string `json:"hostname"`
+	// Versions maps each piece of software on the host to its version,
+	// keyed by its lowercase name (e.g. "klipper", "moonraker").
+	Versions map[string]string `json:"versions"`
+	CPU      string            `json:"cpu"`
+	// Hardware is the board or machine model, e.g. "Raspberry Pi 4 Model B".
+	Hardware string `json:"hardware"`
+	OS       string `json:"os"`
+	// ConfigFile is the path of the firmware's configuration on the host.
+	ConfigFile string `json:"config_file"`
+	// UpdatedAt is when the backend last fetched this.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PowerStatus is the state of a power device.
+type PowerStatus string
+
+const (
+	PowerOn    PowerStatus = "on"
+	PowerOff   PowerStatus = "off"
+	PowerInit  PowerStatus = "init"
+	PowerError PowerStatus = "error"
+)
+
+// PowerDevice is a switchable power supply of a printer, e.g. a smart plug.
+type PowerDevice struct {
+	Name   string      `json:"name"`
+	Status PowerStatus `json:"status"`
+	// LockedWhilePrinting tells that the backend refuses to switch the
+	// device while a job is printing.
+	LockedWhilePrinting bool `json:"locked_while_printing"`
+}
+
+// PrintObject is a part of the current job that can be excluded from it.
+type PrintObject struct {
+	Name string `json:"name"`
+	// Center and Polygon are the part's X/Y outline in mm, nil when the
+	// slicer didn't record them.
+	Center  []float64   `json:"center"`
+	Polygon [][]float64 `json:"polygon"`
+}
+
+// PrintObjects are the parts of the current job.
+type PrintObjects struct {
+	Objects []PrintObject `json:"objects"`
+	// Current is the part being printed, empty between parts.
+	Current string `json:"current"`
+	// Excluded are the names of the parts no longer printed.
+	Excluded []string `json:"excluded"`
+}
+
+// Tuning is the live adjustments of a printer's motion and cooling.
+type Tuning struct {
+	// SpeedFactor and ExtrudeFactor scale the G-code's speeds and
+	// extrusion; 1 prints as sliced.
+	SpeedFactor   float64 `json:"speed_factor"`
+	ExtrudeFactor float64 `json:"extrude_factor"`
+	// FanSpeed is the part cooling fan's speed, 0..1; nil for printers
+	// without one.
+	FanSpeed *float64 `json:"fan_speed"`
+	// ZOffset is the babystepped Z offset, in mm.
+	ZOffset float64 `json:"z_offset"`
+}
+
+// TuningChange changes a printer's Tuning. Nil fields are left as they are.
+type TuningChange struct {
+	SpeedFactor   *float64 `json:"speed_factor"`
+	ExtrudeFactor *float64 `json:"extrude_factor"`
+	FanSpeed      *float64 `json:"fan_speed"`
+	ZOffset       *float64 `json:"z_offset"`
+}
+
+// PauseAt schedules a pause once a job reaches Layer, or once the nozzle
+// reaches ZHeight (mm). Exactly one of them is set.
+type PauseAt struct {
+	Layer   *int     `json:"layer"`
+	ZHeight *float64 `json:"z_height"`
 }
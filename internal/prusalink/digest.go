@@ -0,0 +1,223 @@
+package prusalink
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestTransport is an http.RoundTripper implementing HTTP Digest access
+// authentication (RFC 7616 with MD5, qop=auth), which PrusaLink requires.
+// It remembers the last challenge so that only the first request, and any
+// request after the nonce goes stale, needs a 401 round trip. Requests must
+// have no body or a replayable one (GetBody set).
+type digestTransport struct {
+	username string
+	password string
+	base     http.RoundTripper
+
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        int
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	qop       string
+	algorithm string
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	challenge := t.challenge
+	t.mu.Unlock()
+
+	if challenge != nil {
+		authReq, err := t.authorize(req, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(authReq)
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		drainAndClose(resp)
+	}
+
+	// No challenge yet, or the nonce went stale: ask for a fresh one.
+	probe, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(probe)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge, err = parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return resp, nil
+	}
+	drainAndClose(resp)
+
+	t.mu.Lock()
+	t.challenge = challenge
+	t.nc = 0
+	t.mu.Unlock()
+
+	authReq, err := t.authorize(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.base.RoundTrip(authReq)
+}
+
+func (t *digestTransport) authorize(req *http.Request, c *digestChallenge) (*http.Request, error) {
+	out, err := cloneRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.nc++
+	nc := fmt.Sprintf("%08x", t.nc)
+	t.mu.Unlock()
+
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return nil, err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(t.username + ":" + c.realm + ":" + t.password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	var response string
+	if c.qop == "" {
+		response = md5Hex(ha1 + ":" + c.nonce + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		t.username, c.realm, c.nonce, uri, response)
+	if c.qop != "" {
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s"`, nc, cnonce)
+	}
+	if c.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+	if c.algorithm != "" {
+		header += ", algorithm=" + c.algorithm
+	}
+
+	out.Header.Set("Authorization", header)
+
+	return out, nil
+}
+
+func parseChallenge(header string) (*digestChallenge, error) {
+	const prefix = "Digest "
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errors.New("not a digest challenge")
+	}
+
+	c := new(digestChallenge)
+	for _, part := range splitChallenge(header[len(prefix):]) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+
+		value = strings.Trim(value, `"`)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "realm":
+			c.realm = value
+		case "nonce":
+			c.nonce = value
+		case "opaque":
+			c.opaque = value
+		case "algorithm":
+			c.algorithm = value
+		case "qop":
+			for _, q := range strings.Split(value, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					c.qop = "auth"
+				}
+			}
+		}
+	}
+
+	if c.nonce == "" {
+		return nil, errors.New("digest challenge without nonce")
+	}
+
+	if c.algorithm != "" && !strings.EqualFold(c.algorithm, "MD5") {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", c.algorithm)
+	}
+
+	return c, nil
+}
+
+// splitChallenge splits comma-separated parameters, ignoring commas inside
+// quoted strings.
+func splitChallenge(s string) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+
+	for i, r := range s {
+		switch r {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	out := req.Clone(req.Context())
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("digest auth needs a replayable request body")
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		out.Body = body
+	}
+
+	return out, nil
+}
+
+func drainAndClose(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,232 @@
+package prusalink
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestServer checks requests the way PrusaLink does: it challenges those
+// without valid digest credentials, and answers those with a nonce it has
+// since replaced as stale.
+type digestServer struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	nonce  int
+	probes int
+	// accepted holds the Authorization parameters of the requests let
+	// through, bodies the bodies of every request.
+	accepted []map[string]string
+	bodies   []string
+}
+
+const (
+	testUser     = "maker"
+	testPassword = "secret"
+	testRealm    = "Printer API"
+	testOpaque   = "a, b"
+)
+
+var digestParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+func newDigestServer(t *testing.T) *digestServer {
+	s := &digestServer{nonce: 1}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func (s *digestServer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bodies = append(s.bodies, string(body))
+	nonce := fmt.Sprintf("nonce-%d", s.nonce)
+
+	header, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Digest ")
+	if !ok {
+		s.probes++
+		s.challenge(w, nonce, false)
+		return
+	}
+
+	params := make(map[string]string)
+	for _, m := range digestParam.FindAllStringSubmatch(header, -1) {
+		params[m[1]] = m[2] + m[3]
+	}
+
+	if params["nonce"] != nonce {
+		s.challenge(w, nonce, true)
+		return
+	}
+
+	ha1 := md5Hex(testUser + ":" + testRealm + ":" + testPassword)
+	ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+	want := md5Hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	if params["response"] != want || params["qop"] != "auth" || params["opaque"] != testOpaque ||
+		params["uri"] != r.URL.RequestURI() {
+		s.challenge(w, nonce, false)
+		return
+	}
+
+	s.accepted = append(s.accepted, params)
+	_, _ = io.WriteString(w, "ok")
+}
+
+func (s *digestServer) challenge(w http.ResponseWriter, nonce string, stale bool) {
+	header := fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", opaque="%s", algorithm=MD5`,
+		testRealm, nonce, testOpaque)
+	if stale {
+		header += ", stale=true"
+	}
+
+	w.Header().Set("WWW-Authenticate", header)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// renewNonce makes the current nonce stale.
+func (s *digestServer) renewNonce() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nonce++
+}
+
+func newDigestClient(password string) *http.Client {
+	return &http.Client{Transport: &digestTransport{
+		username: testUser,
+		password: password,
+		base:     http.DefaultTransport,
+	}}
+}
+
+func doRequest(t *testing.T, client *http.Client, req *http.Request) int {
+	t.Helper()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainAndClose(resp)
+
+	return resp.StatusCode
+}
+
+func TestParseChallenge(t *testing.T) {
+	c, err := parseChallenge(`Digest realm="Printer API", nonce="abc", opaque="x, y", qop="auth-int, auth", algorithm=MD5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := digestChallenge{realm: "Printer API", nonce: "abc", opaque: "x, y", qop: "auth", algorithm: "MD5"}
+	if *c != want {
+		t.Errorf("parseChallenge() = %+v, want %+v", *c, want)
+	}
+
+	c, err = parseChallenge(`Digest realm="Printer API", nonce="abc", qop="auth-int"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.qop != "" {
+		t.Errorf("qop = %q for a challenge without auth, want none", c.qop)
+	}
+
+	for _, header := range []string{
+		`Basic realm="Printer API"`,
+		`Digest realm="Printer API", qop="auth"`,
+		`Digest realm="Printer API", nonce="abc", algorithm=SHA-256`,
+	} {
+		if _, err := parseChallenge(header); err == nil {
+			t.Errorf("parseChallenge(%s) succeeded, want an error", header)
+		}
+	}
+}
+
+func TestDigestTransportReusesChallenge(t *testing.T) {
+	s := newDigestServer(t)
+	client := newDigestClient(testPassword)
+
+	for range 2 {
+		req, _ := http.NewRequest(http.MethodGet, s.srv.URL+"/api/v1/status?x=1", nil)
+		if code := doRequest(t, client, req); code != http.StatusOK {
+			t.Fatalf("status %d, want 200", code)
+		}
+	}
+
+	if s.probes != 1 {
+		t.Errorf("%d requests without credentials, want only the first", s.probes)
+	}
+	if len(s.accepted) != 2 {
+		t.Fatalf("%d requests accepted, want 2", len(s.accepted))
+	}
+	first, second := s.accepted[0], s.accepted[1]
+	if first["nc"] != "00000001" || second["nc"] != "00000002" {
+		t.Errorf("nc = %s, %s, want 00000001, 00000002", first["nc"], second["nc"])
+	}
+	if first["cnonce"] == "" || first["cnonce"] == second["cnonce"] {
+		t.Errorf("cnonce = %q, %q, want a fresh one per request", first["cnonce"], second["cnonce"])
+	}
+}
+
+func TestDigestTransportRetriesStaleNonce(t *testing.T) {
+	s := newDigestServer(t)
+	client := newDigestClient(testPassword)
+
+	req, _ := http.NewRequest(http.MethodGet, s.srv.URL+"/api/v1/status", nil)
+	doRequest(t, client, req)
+
+	s.renewNonce()
+
+	req, _ = http.NewRequest(http.MethodGet, s.srv.URL+"/api/v1/status", nil)
+	if code := doRequest(t, client, req); code != http.StatusOK {
+		t.Fatalf("status %d after the nonce went stale, want 200", code)
+	}
+
+	last := s.accepted[len(s.accepted)-1]
+	if last["nonce"] != "nonce-2" || last["nc"] != "00000001" {
+		t.Errorf("retried with nonce %s, nc %s, want nonce-2, 00000001", last["nonce"], last["nc"])
+	}
+}
+
+func TestDigestTransportReplaysBody(t *testing.T) {
+	s := newDigestServer(t)
+	client := newDigestClient(testPassword)
+
+	req, _ := http.NewRequest(http.MethodGet, s.srv.URL+"/api/v1/status", nil)
+	doRequest(t, client, req)
+	s.renewNonce()
+
+	// Stale, probe, authorized: the body goes out three times.
+	req, _ = http.NewRequest(http.MethodPut, s.srv.URL+"/api/v1/files/usb/cube.gcode", strings.NewReader("G28\n"))
+	if code := doRequest(t, client, req); code != http.StatusOK {
+		t.Fatalf("status %d, want 200", code)
+	}
+
+	if got, want := s.bodies[2:], []string{"G28\n", "G28\n", "G28\n"}; !slices.Equal(got, want) {
+		t.Errorf("bodies %q, want %q", got, want)
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, s.srv.URL+"/api/v1/files/usb/cube.gcode", io.NopCloser(strings.NewReader("G28\n")))
+	if _, err := client.Do(req); err == nil {
+		t.Error("request with a body that can't be replayed succeeded, want an error")
+	}
+}
+
+func TestDigestTransportWrongPassword(t *testing.T) {
+	s := newDigestServer(t)
+	client := newDigestClient("wrong")
+
+	req, _ := http.NewRequest(http.MethodGet, s.srv.URL+"/api/v1/status", nil)
+	if code := doRequest(t, client, req); code != http.StatusUnauthorized {
+		t.Errorf("status %d with the wrong password, want 401", code)
+	}
+}
@@ -0,0 +1,223 @@
+package prusalink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ERRRespNotOk is returned when PrusaLink answers with an unexpected HTTP
+// status code.
+type ERRRespNotOk struct {
+	error
+
+	statusCode int
+	respBody   []byte
+}
+
+func (e ERRRespNotOk) RespStatusCode() int {
+	return e.statusCode
+}
+
+func (e ERRRespNotOk) RespBody() []byte {
+	return e.respBody
+}
+
+func (e ERRRespNotOk) Error() string {
+	return e.error.Error()
+}
+
+// Client is a minimal PrusaLink v1 API client authenticated with HTTP digest
+// auth.
+type Client struct {
+	baseUrl    *url.URL
+	httpClient *http.Client
+}
+
+func NewClient(baseUrl *url.URL, username string, password string) *Client {
+	return &Client{
+		baseUrl: baseUrl,
+		httpClient: &http.Client{
+			Transport: &digestTransport{
+				username: username,
+				password: password,
+				base:     http.DefaultTransport,
+			},
+		},
+	}
+}
+
+// do sends the request and returns the response body of a 2xx response.
+// A 204 yields a nil body.
+func (c *Client) do(ctx context.Context, method string, u *url.URL) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", ERRRespNotOk{
+			error:      fmt.Errorf("non-2xx http response: %d", resp.StatusCode),
+			statusCode: resp.StatusCode,
+			respBody:   b,
+		}
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, "", nil
+	}
+
+	return b, resp.Header.Get("Content-Type"), nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, out any) (bool, error) {
+	b, _, err := c.do(ctx, "GET", c.baseUrl.JoinPath(path))
+	if err != nil {
+		return false, err
+	}
+
+	if b == nil {
+		return false, nil
+	}
+
+	return true, json.NewDecoder(bytes.NewReader(b)).Decode(out)
+}
+
+// -----------------
+// Printer status
+
+type StatusJob struct {
+	Id            int      `json:"id"`
+	Progress      *float32 `json:"progress"`       // percent, 0..100
+	TimeRemaining *int     `json:"time_remaining"` // seconds
+	TimePrinting  *int     `json:"time_printing"`  // seconds
+}
+
+type StatusPrinter struct {
+	State string `json:"state"`
+
+	// StatusPrinter describes why the printer is in ERROR or ATTENTION,
+	// when the firmware provides it.
+	StatusPrinter *struct {
+		Ok      bool   `json:"ok"`
+		Message string `json:"message"`
+	} `json:"status_printer"`
+}
+
+type Status struct {
+	Job     *StatusJob    `json:"job"`
+	Printer StatusPrinter `json:"printer"`
+}
+
+func (c *Client) GetStatus(ctx context.Context) (*Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(Status)
+	if _, err := c.getJSON(ctx, "/api/v1/status", out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// -----------------
+// Current job
+
+type JobFile struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Path        string `json:"path"`
+	Refs        struct {
+		Thumbnail string `json:"thumbnail"`
+		Icon      string `json:"icon"`
+		Download  string `json:"download"`
+	} `json:"refs"`
+}
+
+type Job struct {
+	Id            int      `json:"id"`
+	State         string   `json:"state"`
+	Progress      *float32 `json:"progress"`
+	TimeRemaining *int     `json:"time_remaining"`
+	TimePrinting  *int     `json:"time_printing"`
+	File          *JobFile `json:"file"`
+}
+
+// GetJob returns the current job, or nil when there is none.
+func (c *Client) GetJob(ctx context.Context) (*Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	out := new(Job)
+	ok, err := c.getJSON(ctx, "/api/v1/job", out)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *Client) PauseJob(ctx context.Context, jobId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, _, err := c.do(ctx, "PUT", c.baseUrl.JoinPath("/api/v1/job", fmt.Sprint(jobId), "pause"))
+	return err
+}
+
+func (c *Client) ResumeJob(ctx context.Context, jobId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, _, err := c.do(ctx, "PUT", c.baseUrl.JoinPath("/api/v1/job", fmt.Sprint(jobId), "resume"))
+	return err
+}
+
+func (c *Client) StopJob(ctx context.Context, jobId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, _, err := c.do(ctx, "DELETE", c.baseUrl.JoinPath("/api/v1/job", fmt.Sprint(jobId)))
+	return err
+}
+
+// Download writes the resource at ref, a path relative to the printer's base
+// URL (as in JobFile.Refs), to w and returns its content type.
+func (c *Client) Download(ctx context.Context, ref string, w io.Writer) (string, error) {
+	rel, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	u := c.baseUrl.JoinPath(rel.Path)
+	u.RawQuery = rel.RawQuery
+
+	b, contentType, err := c.do(ctx, "GET", u)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+
+	return contentType, nil
+}
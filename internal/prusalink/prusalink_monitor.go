@@ -0,0 +1,449 @@
+package prusalink
+
+import (
+	"3dp-controller/internal/printer"
+	"3dp-controller/internal/util"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var _ printer.Printer = (*Monitor)(nil)
+var _ printer.Thumbnailer = (*Monitor)(nil)
+
+// DefaultUsername is PrusaLink's fixed digest-auth user name.
+const DefaultUsername = "maker"
+
+// Options are the connection settings specific to a PrusaLink printer.
+type Options struct {
+	// Username defaults to DefaultUsername.
+	Username string `yaml:"username"`
+	// Password is the PrusaLink password shown in the printer's network
+	// settings.
+	Password string `yaml:"password"`
+}
+
+func (o *Options) Validate() error {
+	if o.Password == "" {
+		return errors.New("password is required")
+	}
+
+	return nil
+}
+
+// trackedJob is the current or last job, kept after PrusaLink stops
+// reporting it so that its outcome can still be shown.
+type trackedJob struct {
+	job       Job
+	status    string
+	startTime time.Time
+	endTime   *time.Time
+}
+
+type Monitor struct {
+	printerName string
+	printerUrl  *url.URL
+	logger      *zap.SugaredLogger
+	enforcer    *printer.Enforcer
+	client      *Client
+
+	mu             sync.RWMutex
+	state          printer.PrinterState
+	lastError      *printer.ErrorInfo
+	lastUpdateTime time.Time
+	status         *Status
+	job            *trackedJob
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func (m *Monitor) PrinterName() string {
+	return m.printerName
+}
+
+func (m *Monitor) PrinterUrl() string {
+	return m.printerUrl.String()
+}
+
+func (m *Monitor) PrinterType() string {
+	return "prusalink"
+}
+
+func (m *Monitor) Config() printer.MonitorConfig {
+	return m.enforcer.Config()
+}
+
+func (m *Monitor) State() printer.PrinterState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state
+}
+
+func (m *Monitor) Message() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.status == nil || m.status.Printer.StatusPrinter == nil {
+		return ""
+	}
+
+	return m.status.Printer.StatusPrinter.Message
+}
+
+func (m *Monitor) ErrorDetail() *printer.ErrorInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state != printer.Error && m.state != printer.InternalError {
+		return nil
+	}
+
+	return m.lastError
+}
+
+func (m *Monitor) LastUpdateTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastUpdateTime
+}
+
+func (m *Monitor) Job() *printer.Job {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil {
+		return nil
+	}
+
+	job := m.job.job
+
+	startTime := m.job.startTime
+	j := &printer.Job{
+		JobId:     strconv.Itoa(job.Id),
+		Status:    m.job.status,
+		StartTime: &startTime,
+		EndTime:   m.job.endTime,
+	}
+
+	if job.File != nil {
+		j.Name = job.File.DisplayName
+		if j.Name == "" {
+			j.Name = job.File.Name
+		}
+		j.HasThumbnail = job.File.Refs.Thumbnail != ""
+	}
+
+	if m.job.status == "in_progress" {
+		if job.Progress != nil {
+			progress := *job.Progress / 100
+			j.Progress = &progress
+		}
+
+		if job.TimePrinting != nil {
+			printDuration := printer.Seconds(*job.TimePrinting)
+			j.PrintDuration = &printDuration
+		}
+
+		totalDuration := printer.Seconds(time.Since(m.job.startTime).Seconds())
+		j.TotalDuration = &totalDuration
+
+		if job.TimeRemaining != nil && *job.TimeRemaining >= 0 {
+			remaining := printer.Seconds(*job.TimeRemaining)
+			j.EstimatedRemaining = &remaining
+		}
+	}
+
+	return j
+}
+
+func (m *Monitor) RegisteredJobId() string {
+	return m.enforcer.RegisteredJobId()
+}
+
+func (m *Monitor) AllowNoRegPrint() bool {
+	return m.enforcer.AllowNoRegPrint()
+}
+
+func (m *Monitor) JobPausedByMonitor() bool {
+	return m.enforcer.JobPausedByMonitor()
+}
+
+func (m *Monitor) SetRegisteredJobId(jobId string) {
+	m.enforcer.SetRegisteredJobId(m.ctx, jobId)
+}
+
+func (m *Monitor) SetAllowNoRegPrint(allowNoRegPrint bool) {
+	m.enforcer.SetAllowNoRegPrint(m.ctx, allowNoRegPrint)
+}
+
+func NewMonitor(name string, printerURL string, options Options, config printer.MonitorConfig, logger *zap.SugaredLogger) (*Monitor, error) {
+	m := new(Monitor)
+
+	u, err := url.Parse(printerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Username == "" {
+		options.Username = DefaultUsername
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.printerName = name
+	m.printerUrl = u
+	m.logger = logger
+	m.client = NewClient(u, options.Username, options.Password)
+	m.enforcer = printer.NewEnforcer(config, m, logger)
+
+	m.state = printer.Disconnected
+	m.lastUpdateTime = time.Now()
+
+	return m, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) {
+	if m.ctx != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	m.ctx = ctx
+	m.cancelFunc = cancel
+
+	ticker1Duration := 2 * time.Second
+	ticker1 := time.NewTicker(ticker1Duration)
+
+	go func() {
+		m.update(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				ticker1.Stop()
+				return
+			case <-ticker1.C:
+				m.update(ctx)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	if m.ctx != nil {
+		m.cancelFunc()
+
+		m.ctx = nil
+		m.cancelFunc = nil
+	}
+}
+
+func (m *Monitor) update(ctx context.Context) {
+	status, err := m.client.GetStatus(ctx)
+
+	var job *Job
+	if err == nil {
+		job, err = m.client.GetJob(ctx)
+	}
+
+	m.mu.Lock()
+	m.lastUpdateTime = time.Now()
+
+	if err != nil {
+		m.status = nil
+
+		var nonOkErr ERRRespNotOk
+		if util.IsErrNetworkProblem(err) {
+			m.state = printer.Disconnected
+			m.lastError = nil
+		} else if errors.As(err, &nonOkErr) {
+			m.state = printer.InternalError
+			code := nonOkErr.RespStatusCode()
+			m.lastError = &printer.ErrorInfo{Code: &code, Message: err.Error()}
+			m.logger.Warnf("Failed to get printer status: %s, status_code: %d\n", err, code)
+		} else {
+			m.state = printer.InternalError
+			m.lastError = &printer.ErrorInfo{Message: err.Error()}
+			m.logger.Errorf("Error getting printer status: %s\n", err)
+		}
+
+		m.mu.Unlock()
+		return
+	}
+
+	m.status = status
+
+	var printDuration time.Duration
+	var progress float32
+	if status.Job != nil {
+		if status.Job.TimePrinting != nil {
+			printDuration = time.Duration(*status.Job.TimePrinting) * time.Second
+		}
+		if status.Job.Progress != nil {
+			progress = *status.Job.Progress / 100
+		}
+	}
+
+	m.state = mapState(status.Printer.State, status.Job != nil, printDuration)
+	m.lastError = nil
+	if m.state == printer.Error {
+		m.lastError = &printer.ErrorInfo{Message: status.Printer.State}
+		if status.Printer.StatusPrinter != nil && status.Printer.StatusPrinter.Message != "" {
+			m.lastError.Message = status.Printer.StatusPrinter.Message
+		}
+	}
+
+	m.trackJob(job, printDuration)
+
+	activeJobId := ""
+	if m.job != nil && m.job.status == "in_progress" {
+		activeJobId = strconv.Itoa(m.job.job.Id)
+	}
+
+	obs := printer.Observation{
+		State:         m.state,
+		PrintDuration: printDuration,
+		Progress:      progress,
+	}
+	m.mu.Unlock()
+
+	m.enforcer.ClearStaleRegistration(activeJobId)
+	m.enforcer.Enforce(ctx, obs)
+}
+
+// trackJob updates m.job from the current job, nil when PrusaLink reports
+// none. It must be called with m.mu held.
+func (m *Monitor) trackJob(job *Job, printDuration time.Duration) {
+	if job == nil {
+		if m.job != nil && m.job.status == "in_progress" {
+			endTime := time.Now()
+			m.job.endTime = &endTime
+			m.job.status = "interrupted"
+		}
+		return
+	}
+
+	if m.job == nil || m.job.job.Id != job.Id {
+		m.job = &trackedJob{startTime: time.Now().Add(-printDuration)}
+	}
+
+	m.job.job = *job
+
+	status := jobStatus(job.State)
+	if status != "in_progress" && m.job.endTime == nil {
+		endTime := time.Now()
+		m.job.endTime = &endTime
+	}
+	m.job.status = status
+}
+
+func (m *Monitor) LatestThumbnail(ctx context.Context, w io.Writer) (string, error) {
+	m.mu.RLock()
+	thumbnail := ""
+	if m.job != nil && m.job.job.File != nil {
+		thumbnail = m.job.job.File.Refs.Thumbnail
+	}
+	m.mu.RUnlock()
+
+	if thumbnail == "" {
+		return "", printer.ErrNoThumbnail
+	}
+
+	return m.client.Download(ctx, thumbnail, w)
+}
+
+func (m *Monitor) currentJobId() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.job == nil || m.job.status != "in_progress" {
+		return 0, errors.New("no job in progress")
+	}
+
+	return m.job.job.Id, nil
+}
+
+// PausePrint, ResumePrint, CancelPrint and SetStatusMessage implement
+// printer.JobCommander for the Enforcer.
+
+func (m *Monitor) PausePrint(ctx context.Context) error {
+	id, err := m.currentJobId()
+	if err != nil {
+		return err
+	}
+
+	return m.client.PauseJob(ctx, id)
+}
+
+func (m *Monitor) ResumePrint(ctx context.Context) error {
+	id, err := m.currentJobId()
+	if err != nil {
+		return err
+	}
+
+	return m.client.ResumeJob(ctx, id)
+}
+
+func (m *Monitor) CancelPrint(ctx context.Context) error {
+	id, err := m.currentJobId()
+	if err != nil {
+		return err
+	}
+
+	return m.client.StopJob(ctx, id)
+}
+
+// SetStatusMessage is a no-op: PrusaLink has no way to show a message.
+func (m *Monitor) SetStatusMessage(_ context.Context, _ string) error {
+	return nil
+}
+
+// mapState maps PrusaLink's printer state onto printer.PrinterState. The
+// printer is also BUSY running a command or a calibration, so BUSY only
+// counts as preparing a print when there is a job.
+func mapState(state string, hasJob bool, printDuration time.Duration) printer.PrinterState {
+	switch state {
+	case "IDLE", "READY", "FINISHED", "STOPPED":
+		return printer.Ready
+	case "BUSY":
+		if !hasJob {
+			return printer.Unknown
+		}
+		return printer.PrePrint
+	case "PRINTING":
+		if printDuration > 0 {
+			return printer.Printing
+		}
+		return printer.PrePrint
+	case "PAUSED":
+		return printer.Pause
+	case "ERROR", "ATTENTION":
+		return printer.Error
+	default:
+		return printer.Unknown
+	}
+}
+
+func jobStatus(state string) string {
+	switch state {
+	case "FINISHED":
+		return "completed"
+	case "STOPPED":
+		return "cancelled"
+	case "ERROR":
+		return "error"
+	default:
+		return "in_progress"
+	}
+}
@@ -0,0 +1,33 @@
+package prusalink
+
+import (
+	"3dp-controller/internal/printer"
+	"testing"
+	"time"
+)
+
+func TestMapState(t *testing.T) {
+	tests := []struct {
+		state         string
+		hasJob        bool
+		printDuration time.Duration
+		want          printer.PrinterState
+	}{
+		{"IDLE", false, 0, printer.Ready},
+		{"FINISHED", true, time.Second, printer.Ready},
+		{"BUSY", false, 0, printer.Unknown},
+		{"BUSY", true, 0, printer.PrePrint},
+		{"PRINTING", true, 0, printer.PrePrint},
+		{"PRINTING", true, time.Second, printer.Printing},
+		{"PAUSED", true, time.Second, printer.Pause},
+		{"ATTENTION", true, time.Second, printer.Error},
+		{"ERROR", false, 0, printer.Error},
+		{"OFFLINE", false, 0, printer.Unknown},
+	}
+
+	for _, tt := range tests {
+		if got := mapState(tt.state, tt.hasJob, tt.printDuration); got != tt.want {
+			t.Errorf("mapState(%q, %t, %s) = %s, want %s", tt.state, tt.hasJob, tt.printDuration, got, tt.want)
+		}
+	}
+}
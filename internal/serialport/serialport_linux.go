@@ -0,0 +1,45 @@
+//go:build linux
+
+package serialport
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open opens the device at path in raw 8N1 mode at the given baud
+// rate. Any rate is accepted (BOTHER), so non-standard rates such as 250000
+// work too.
+func Open(path string, baudRate int) (*os.File, error) {
+	f, err := os.OpenFile(path, unix.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := int(f.Fd())
+
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR |
+		unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | unix.BOTHER
+	t.Ispeed = uint32(baudRate)
+	t.Ospeed = uint32(baudRate)
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS2, t); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package serialport
+
+import (
+	"errors"
+	"os"
+)
+
+// Open is only implemented on Linux.
+func Open(_ string, _ int) (*os.File, error) {
+	return nil, errors.New("serial ports are only supported on linux")
+}
@@ -0,0 +1,73 @@
+// Package testutil holds the helpers the backends' tests share to follow
+// their monitors and the fakes they run against.
+package testutil
+
+import (
+	"3dp-controller/internal/printer"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// Timeout bounds every wait. It covers a few of the monitors' 2s polls.
+const Timeout = 10 * time.Second
+
+// Eventually polls cond until it holds, and fails t if it doesn't within
+// Timeout.
+func Eventually(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WaitFor receives from ch until it gets want, skipping anything before it,
+// and fails t if want doesn't come within Timeout.
+func WaitFor[T comparable](t testing.TB, ch <-chan T, want T) {
+	t.Helper()
+
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case got := <-ch:
+			if got == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+}
+
+// ExpectNone receives from ch for d, and fails t if it gets unwanted.
+func ExpectNone[T comparable](t testing.TB, ch <-chan T, unwanted T, d time.Duration) {
+	t.Helper()
+
+	timeout := time.After(d)
+	for {
+		select {
+		case got := <-ch:
+			if got == unwanted {
+				t.Fatalf("unexpected %v", got)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// MonitorConfig is the configuration the monitors are tested with. Their
+// tests leave the policy to printer's; unregistered printing is allowed,
+// as by default.
+func MonitorConfig() printer.MonitorConfig {
+	return printer.MonitorConfig{
+		NoPauseDuration:  time.Minute,
+		WillPauseMessage: template.Must(template.New("").Parse("will pause")),
+		PauseMessage:     template.Must(template.New("").Parse("paused")),
+	}
+}
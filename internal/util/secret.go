@@ -0,0 +1,83 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secret is a config value that is given inline, or read from an
+// environment variable or a file when the config is loaded, so it does not
+// have to sit in config.yaml:
+//
+//	password: hunter2
+//	password: { env: MOONRAKER_PASSWORD }
+//	password: { file: /run/secrets/moonraker_password }
+//
+// A file's trailing newline is dropped.
+type Secret string
+
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var v string
+		if err := node.Decode(&v); err != nil {
+			return err
+		}
+
+		*s = Secret(v)
+		return nil
+	}
+
+	var src struct {
+		Env  string `yaml:"env"`
+		File string `yaml:"file"`
+	}
+
+	if node.Kind == yaml.MappingNode {
+		// Reject typos such as "enf:" instead of silently reading nothing.
+		for i := 0; i < len(node.Content); i += 2 {
+			if k := node.Content[i].Value; k != "env" && k != "file" {
+				return fmt.Errorf("unknown secret source '%s'", k)
+			}
+		}
+	}
+
+	if err := node.Decode(&src); err != nil {
+		return err
+	}
+
+	switch {
+	case src.Env != "" && src.File != "":
+		return errors.New("secret has both env and file")
+	case src.Env != "":
+		v, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", src.Env)
+		}
+
+		*s = Secret(v)
+	case src.File != "":
+		b, err := os.ReadFile(src.File)
+		if err != nil {
+			return err
+		}
+
+		*s = Secret(strings.TrimRight(string(b), "\r\n"))
+	default:
+		return errors.New("secret needs a value, env or file")
+	}
+
+	return nil
+}
+
+// String redacts the secret so that it does not end up in logs; convert
+// it with string() to use it.
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
@@ -21,6 +21,32 @@ func (s *Server) registerAPIRoutes(r *gin.RouterGroup) {
 	r.GET("/printers/:key", s.PrinterHandler)
 	r.PUT("/printers/:key", s.UpdatePrinter)
 	r.GET("/printers/:key/latest_thumb", s.GetLatestThumbnail)
+	r.GET("/printers/:key/temperatures", s.GetTemperatures)
+	r.GET("/printers/:key/system", s.GetSystemInfo)
+	r.POST("/printers/:key/actions/:action", s.PrinterAction)
+	r.GET("/printers/:key/objects", s.GetPrintObjects)
+	r.POST("/printers/:key/objects/:name/exclude", s.ExcludeObject)
+	r.GET("/printers/:key/tuning", s.GetTuning)
+	r.PATCH("/printers/:key/tuning", s.UpdateTuning)
+	r.PUT("/printers/:key/scheduled_pause", s.SchedulePause)
+	r.DELETE("/printers/:key/scheduled_pause", s.CancelScheduledPause)
+
+	r.GET("/printers/:key/files", s.ListFiles)
+	r.POST("/printers/:key/files", s.UploadFile)
+	r.POST("/printers/:key/files/print", s.StartPrint)
+	r.DELETE("/printers/:key/files/*path", s.DeleteFile)
+
+	r.GET("/printers/:key/webcams", s.GetWebcams)
+	r.GET("/printers/:key/webcam/snapshot", s.GetWebcamSnapshot)
+	r.GET("/printers/:key/webcam/stream", s.GetWebcamStream)
+
+	r.POST("/printers/:key/gcode", s.RunGCode)
+	r.GET("/printers/:key/console", s.GetConsole)
+
+	r.GET("/printers/:key/jobs", s.GetJobs)
+
+	r.GET("/printers/:key/power", s.GetPowerDevices)
+	r.POST("/printers/:key/power/:device", s.SetPower)
 }
 
 //	@BasePath	/api/v1
@@ -82,7 +108,7 @@ type UpdatePrinterResponse struct {
 }
 
 func makePrinter(key string, p printer.Printer) Printer {
-	return Printer{
+	dto := Printer{
 		Key:  key,
 		Name: p.PrinterName(),
 		Url:  p.PrinterUrl(),
@@ -92,6 +118,8 @@ func makePrinter(key string, p printer.Printer) Printer {
 		AllowNoRegPrint: p.AllowNoRegPrint(),
 		NoPauseDuration: p.Config().NoPauseDuration.Seconds(),
 
+		JobPausedByMonitor: p.JobPausedByMonitor(),
+
 		State:          p.State(),
 		Message:        p.Message(),
 		ErrorDetail:    p.ErrorDetail(),
@@ -99,6 +127,17 @@ func makePrinter(key string, p printer.Printer) Printer {
 
 		Job: p.Job(),
 	}
+
+	if t, ok := p.(printer.TemperatureReporter); ok {
+		dto.Temperatures = t.Temperatures()
+	}
+
+	if ps, ok := p.(printer.PauseScheduler); ok {
+		dto.ScheduledPause = ps.ScheduledPause()
+		dto.JobPausedBySchedule = ps.JobPausedBySchedule()
+	}
+
+	return dto
 }
 
 // UpdatePrinter godoc
@@ -208,3 +247,116 @@ func (s *Server) GetLatestThumbnail(g *gin.Context) {
 		s.logger.Errorf("copy error: %s", err.Error())
 	}
 }
+
+// GetTemperatures godoc
+//
+//	@Summary	Get heater and sensor temperatures
+//	@Tags		Printers
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{array}		printer.Temperature
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/temperatures [get]
+func (s *Server) GetTemperatures(g *gin.Context) {
+	printerKey := g.Param("key")
+
+	p, ok := s.monitors[printerKey]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	t, ok := p.(printer.TemperatureReporter)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "temperatures not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return
+	}
+
+	temps := t.Temperatures()
+	if temps == nil {
+		temps = make([]printer.Temperature, 0)
+	}
+
+	g.JSON(http.StatusOK, temps)
+}
+
+// PrinterAction godoc
+//
+//	@Summary	Pause, resume or cancel the current job, or emergency stop
+//	@Tags		Printers
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		action	path	string	true	"action to run"	Enums(pause, resume, cancel, estop)
+//	@Produce	json
+//	@Success	204
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	409	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/actions/{action} [post]
+func (s *Server) PrinterAction(g *gin.Context) {
+	printerKey := g.Param("key")
+
+	p, ok := s.monitors[printerKey]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	c, ok := p.(printer.JobController)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "job control not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return
+	}
+
+	var run func(ctx context.Context) error
+	switch action := g.Param("action"); action {
+	case "pause":
+		run = c.Pause
+	case "resume":
+		run = c.Resume
+	case "cancel":
+		run = c.Cancel
+	case "estop":
+		run = c.EmergencyStop
+	default:
+		resp := APIErrorResp{
+			Error: fmt.Sprintf("unknown action %q", action),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	if err := run(ctx); errors.Is(err, printer.ErrPausedByMonitor) {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusConflict, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("printer action %s error: %s", g.Param("action"), err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
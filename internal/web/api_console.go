@@ -0,0 +1,202 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RunGCodeRequest struct {
+	Script string `json:"script" binding:"required"`
+}
+
+type RunGCodeResponse struct {
+	Lines []string `json:"lines"`
+	// Error is what the printer rejected the script with, if it did.
+	Error *string `json:"error"`
+}
+
+// defaultConsoleCount is how many lines of history GetConsole returns by
+// default.
+const defaultConsoleCount = 100
+
+// console looks up the printer's printer.Console, or responds with 404/501
+// and returns false.
+func (s *Server) console(g *gin.Context) (printer.Console, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	c, ok := p.(printer.Console)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "console not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return c, true
+}
+
+// RunGCode godoc
+//
+//	@Summary	Run G-code or macros
+//	@Tags		Console
+//	@Param		key		path	string			true	"key of printer"
+//	@Param		script	body	RunGCodeRequest	true	"script to run"
+//	@Accept		json
+//	@Produce	json
+//	@Success	200	{object}	RunGCodeResponse
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	403	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/gcode [post]
+func (s *Server) RunGCode(g *gin.Context) {
+	c, ok := s.console(g)
+	if !ok {
+		return
+	}
+
+	var req RunGCodeRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		resp := APIErrorResp{
+			Error: "invalid request: " + err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	if err := s.gcodePolicy.Check(req.Script); err != nil {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusForbidden, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	lines, err := c.RunGCode(ctx, req.Script)
+
+	resp := RunGCodeResponse{
+		Lines: lines,
+	}
+	if resp.Lines == nil {
+		resp.Lines = make([]string, 0)
+	}
+
+	var gcodeErr *printer.GCodeError
+	if errors.As(err, &gcodeErr) {
+		resp.Error = &gcodeErr.Message
+	} else if err != nil {
+		s.logger.Errorf("run gcode error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.JSON(http.StatusOK, resp)
+}
+
+// GetConsole godoc
+//
+//	@Summary		Get the console history
+//	@Description	With "Accept: text/event-stream" the history is followed by live console output, one "line" event per line.
+//	@Tags			Console
+//	@Param			key		path	string	true	"key of printer"
+//	@Param			count	query	int		false	"number of history lines"	default(100)
+//	@Produce		json
+//	@Produce		text/event-stream
+//	@Success		200	{array}		printer.ConsoleLine
+//	@Failure		400	{object}	APIErrorResp
+//	@Failure		404	{object}	APIErrorResp
+//	@Failure		500	{object}	APIErrorResp
+//	@Failure		501	{object}	APIErrorResp
+//	@Router			/printers/{key}/console [get]
+func (s *Server) GetConsole(g *gin.Context) {
+	c, ok := s.console(g)
+	if !ok {
+		return
+	}
+
+	count := defaultConsoleCount
+	if raw := g.Query("count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			resp := APIErrorResp{
+				Error: "invalid count",
+			}
+			g.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		count = n
+	}
+
+	stream := strings.Contains(g.GetHeader("Accept"), "text/event-stream")
+
+	// Subscribe first, so that no line falls between history and stream.
+	var live <-chan printer.ConsoleLine
+	if stream {
+		var unsubscribe func()
+		live, unsubscribe = c.SubscribeConsole()
+		defer unsubscribe()
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	history, err := c.ConsoleHistory(ctx, count)
+	if err != nil {
+		s.logger.Errorf("get console history error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	if history == nil {
+		history = make([]printer.ConsoleLine, 0)
+	}
+
+	if !stream {
+		g.JSON(http.StatusOK, history)
+		return
+	}
+
+	g.Header("Cache-Control", "no-store")
+
+	for _, line := range history {
+		g.SSEvent("line", line)
+	}
+	g.Writer.Flush()
+
+	for {
+		select {
+		case <-g.Request.Context().Done():
+			return
+		case <-s.streamCtx.Done():
+			return
+		case line := <-live:
+			g.SSEvent("line", line)
+			g.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadFileResponse struct {
+	File printer.File `json:"file"`
+	// JobId is the registered job's ID when the upload started a print.
+	JobId *string `json:"job_id"`
+}
+
+type StartPrintResponse struct {
+	JobId string `json:"job_id"`
+}
+
+// cleanFilePath makes p relative to a printer's file storage. It reports
+// false if p names nothing in it, e.g. because it climbs out with "..".
+func cleanFilePath(p string) (string, bool) {
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+
+	return p, true
+}
+
+// fileManager looks up the printer's printer.FileManager, or responds with
+// 404/501 and returns false.
+func (s *Server) fileManager(g *gin.Context) (printer.FileManager, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	f, ok := p.(printer.FileManager)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "file management not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return f, true
+}
+
+// ListFiles godoc
+//
+//	@Summary	List the print files stored on a printer
+//	@Tags		Files
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{array}		printer.File
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/files [get]
+func (s *Server) ListFiles(g *gin.Context) {
+	f, ok := s.fileManager(g)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	files, err := f.Files(ctx)
+	if err != nil {
+		s.logger.Errorf("list files error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	if files == nil {
+		files = make([]printer.File, 0)
+	}
+
+	g.JSON(http.StatusOK, files)
+}
+
+// UploadFile godoc
+//
+//	@Summary	Upload a print file, and optionally print it
+//	@Tags		Files
+//	@Param		key		path		string	true	"key of printer"
+//	@Param		file	formData	file	true	"file to upload"
+//	@Param		path	formData	string	false	"path to store the file at, defaults to its name"
+//	@Param		start	formData	boolean	false	"start printing the file and register its job"
+//	@Accept		multipart/form-data
+//	@Produce	json
+//	@Success	201	{object}	UploadFileResponse
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/files [post]
+func (s *Server) UploadFile(g *gin.Context) {
+	f, ok := s.fileManager(g)
+	if !ok {
+		return
+	}
+
+	header, err := g.FormFile("file")
+	if err != nil {
+		resp := APIErrorResp{
+			Error: "missing file",
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	filePath, ok := cleanFilePath(g.DefaultPostForm("path", header.Filename))
+	if !ok {
+		resp := APIErrorResp{
+			Error: "invalid path",
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	start := false
+	if raw := g.PostForm("start"); raw != "" {
+		if start, err = strconv.ParseBool(raw); err != nil {
+			resp := APIErrorResp{
+				Error: "invalid start",
+			}
+			g.JSON(http.StatusBadRequest, resp)
+			return
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		s.logger.Errorf("open upload error: %s", err.Error())
+		g.Status(http.StatusInternalServerError)
+		return
+	}
+
+	defer file.Close()
+
+	uploaded, err := f.UploadFile(s.ctx, filePath, file)
+	if err != nil {
+		s.logger.Errorf("upload file error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	resp := UploadFileResponse{
+		File: *uploaded,
+	}
+
+	if start {
+		ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+		defer cancel()
+
+		jobId, err := f.StartPrint(ctx, uploaded.Path)
+		if err != nil {
+			s.logger.Errorf("start print error: %s", err.Error())
+			resp := APIErrorResp{
+				Error: "file uploaded, but failed to start printing: " + err.Error(),
+			}
+			g.JSON(http.StatusInternalServerError, resp)
+			return
+		}
+
+		resp.JobId = &jobId
+	}
+
+	g.JSON(http.StatusCreated, resp)
+}
+
+// DeleteFile godoc
+//
+//	@Summary	Delete a print file
+//	@Tags		Files
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		path	path	string	true	"path of file"
+//	@Produce	json
+//	@Success	204
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/files/{path} [delete]
+func (s *Server) DeleteFile(g *gin.Context) {
+	f, ok := s.fileManager(g)
+	if !ok {
+		return
+	}
+
+	filePath, ok := cleanFilePath(g.Param("path"))
+	if !ok {
+		resp := APIErrorResp{
+			Error: "invalid path",
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	if err := f.DeleteFile(ctx, filePath); errors.Is(err, printer.ErrFileNotFound) {
+		resp := APIErrorResp{
+			Error: "file not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("delete file error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
+
+// StartPrint godoc
+//
+//	@Summary	Print a stored file and register its job
+//	@Tags		Files
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		path	query	string	true	"path of file"
+//	@Produce	json
+//	@Success	200	{object}	StartPrintResponse
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/files/print [post]
+func (s *Server) StartPrint(g *gin.Context) {
+	f, ok := s.fileManager(g)
+	if !ok {
+		return
+	}
+
+	filePath, ok := cleanFilePath(g.Query("path"))
+	if !ok {
+		resp := APIErrorResp{
+			Error: "invalid path",
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	jobId, err := f.StartPrint(ctx, filePath)
+	if errors.Is(err, printer.ErrFileNotFound) {
+		resp := APIErrorResp{
+			Error: "file not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("start print error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.JSON(http.StatusOK, StartPrintResponse{JobId: jobId})
+}
@@ -0,0 +1,135 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultJobsLimit is how many jobs GetJobs returns by default.
+	defaultJobsLimit = 20
+	maxJobsLimit     = 100
+)
+
+// parseTimeQuery parses a query parameter given as RFC 3339 or as unix
+// seconds. It returns nil when the parameter is absent.
+func parseTimeQuery(g *gin.Context, name string) (*time.Time, error) {
+	raw := g.Query(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+
+	sec, err := strconv.ParseFloat(raw, 64)
+	if err != nil || sec < 0 {
+		return nil, errors.New("invalid " + name)
+	}
+
+	t := time.Unix(0, int64(sec*float64(time.Second)))
+	return &t, nil
+}
+
+// GetJobs godoc
+//
+//	@Summary		List the jobs a printer printed
+//	@Description	Jobs are listed newest first. since and before bound their start time and take RFC 3339 or unix seconds. Pass next_cursor as cursor for the next page.
+//	@Tags			Jobs
+//	@Param			key		path	string	true	"key of printer"
+//	@Param			since	query	string	false	"only jobs started after"
+//	@Param			before	query	string	false	"only jobs started before"
+//	@Param			limit	query	int		false	"jobs per page"	default(20)	maximum(100)
+//	@Param			cursor	query	string	false	"next_cursor of the page before"
+//	@Produce		json
+//	@Success		200	{object}	printer.JobPage
+//	@Failure		400	{object}	APIErrorResp
+//	@Failure		404	{object}	APIErrorResp
+//	@Failure		500	{object}	APIErrorResp
+//	@Failure		501	{object}	APIErrorResp
+//	@Router			/printers/{key}/jobs [get]
+func (s *Server) GetJobs(g *gin.Context) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	h, ok := p.(printer.JobHistory)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "job history not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return
+	}
+
+	query := printer.JobQuery{
+		Limit:  defaultJobsLimit,
+		Cursor: g.Query("cursor"),
+	}
+
+	var err error
+	if query.Since, err = parseTimeQuery(g, "since"); err != nil {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	if query.Before, err = parseTimeQuery(g, "before"); err != nil {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	if raw := g.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxJobsLimit {
+			resp := APIErrorResp{
+				Error: "invalid limit",
+			}
+			g.JSON(http.StatusBadRequest, resp)
+			return
+		}
+		query.Limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	page, err := h.Jobs(ctx, query)
+	if errors.Is(err, printer.ErrInvalidCursor) {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("get jobs error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	if page.Jobs == nil {
+		page.Jobs = make([]printer.Job, 0)
+	}
+
+	g.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// objectExcluder looks up the printer's printer.ObjectExcluder, or responds
+// with 404/501 and returns false.
+func (s *Server) objectExcluder(g *gin.Context) (printer.ObjectExcluder, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	oe, ok := p.(printer.ObjectExcluder)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "excluding objects not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return oe, true
+}
+
+// GetPrintObjects godoc
+//
+//	@Summary	List the parts of a printer's current job
+//	@Tags		Printers
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{object}	printer.PrintObjects
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/objects [get]
+func (s *Server) GetPrintObjects(g *gin.Context) {
+	oe, ok := s.objectExcluder(g)
+	if !ok {
+		return
+	}
+
+	objects := oe.PrintObjects()
+	if objects == nil {
+		objects = &printer.PrintObjects{
+			Objects:  make([]printer.PrintObject, 0),
+			Excluded: make([]string, 0),
+		}
+	}
+
+	g.JSON(http.StatusOK, objects)
+}
+
+// ExcludeObject godoc
+//
+//	@Summary	Stop printing one part of a printer's current job
+//	@Tags		Printers
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		name	path	string	true	"name of part"
+//	@Success	204
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/objects/{name}/exclude [post]
+func (s *Server) ExcludeObject(g *gin.Context) {
+	oe, ok := s.objectExcluder(g)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	err := oe.ExcludeObject(ctx, g.Param("name"))
+
+	var gcodeErr *printer.GCodeError
+	if errors.Is(err, printer.ErrNoObject) {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	} else if errors.As(err, &gcodeErr) {
+		resp := APIErrorResp{
+			Error: gcodeErr.Message,
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("exclude object error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
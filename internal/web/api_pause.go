@@ -0,0 +1,91 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pauseScheduler looks up the printer's printer.PauseScheduler, or responds
+// with 404/501 and returns false.
+func (s *Server) pauseScheduler(g *gin.Context) (printer.PauseScheduler, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	ps, ok := p.(printer.PauseScheduler)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "scheduled pauses not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return ps, true
+}
+
+// SchedulePause godoc
+//
+//	@Summary		Pause a printer's current or next job at a layer or Z height
+//	@Description	Set either layer or z_height. The pause replaces any scheduled before, happens once, and is never resumed by the monitor.
+//	@Tags			Printers
+//	@Param			key		path	string			true	"key of printer"
+//	@Param			pause	body	printer.PauseAt	true	"when to pause"
+//	@Accept			json
+//	@Success		204
+//	@Failure		400	{object}	APIErrorResp
+//	@Failure		404	{object}	APIErrorResp
+//	@Failure		501	{object}	APIErrorResp
+//	@Router			/printers/{key}/scheduled_pause [put]
+func (s *Server) SchedulePause(g *gin.Context) {
+	ps, ok := s.pauseScheduler(g)
+	if !ok {
+		return
+	}
+
+	var req printer.PauseAt
+	if err := g.ShouldBindJSON(&req); err != nil {
+		resp := APIErrorResp{
+			Error: "invalid request: " + err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	if err := ps.SchedulePause(req); err != nil {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
+
+// CancelScheduledPause godoc
+//
+//	@Summary	Cancel a printer's scheduled pause
+//	@Tags		Printers
+//	@Param		key	path	string	true	"key of printer"
+//	@Success	204
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/scheduled_pause [delete]
+func (s *Server) CancelScheduledPause(g *gin.Context) {
+	ps, ok := s.pauseScheduler(g)
+	if !ok {
+		return
+	}
+
+	ps.CancelScheduledPause()
+
+	g.Status(http.StatusNoContent)
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SetPowerRequest struct {
+	On *bool `json:"on" binding:"required"`
+}
+
+// powerController looks up the printer's printer.PowerController, or
+// responds with 404/501 and returns false.
+func (s *Server) powerController(g *gin.Context) (printer.PowerController, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	pc, ok := p.(printer.PowerController)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "power control not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return pc, true
+}
+
+// GetPowerDevices godoc
+//
+//	@Summary	List the power devices of a printer
+//	@Tags		Power
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{array}		printer.PowerDevice
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/power [get]
+func (s *Server) GetPowerDevices(g *gin.Context) {
+	pc, ok := s.powerController(g)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	devices, err := pc.PowerDevices(ctx)
+	if err != nil {
+		s.logger.Errorf("list power devices error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	if devices == nil {
+		devices = make([]printer.PowerDevice, 0)
+	}
+
+	g.JSON(http.StatusOK, devices)
+}
+
+// SetPower godoc
+//
+//	@Summary	Switch a power device of a printer on or off
+//	@Tags		Power
+//	@Param		key		path	string			true	"key of printer"
+//	@Param		device	path	string			true	"name of power device"
+//	@Param		power	body	SetPowerRequest	true	"whether to switch it on"
+//	@Accept		json
+//	@Success	204
+//	@Failure	400	{object}	APIErrorResp
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/power/{device} [post]
+func (s *Server) SetPower(g *gin.Context) {
+	pc, ok := s.powerController(g)
+	if !ok {
+		return
+	}
+
+	var req SetPowerRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		resp := APIErrorResp{
+			Error: "invalid request: " + err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+	defer cancel()
+
+	err := pc.SetPower(ctx, g.Param("device"), *req.On)
+	if errors.Is(err, printer.ErrNoPowerDevice) {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("set power error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
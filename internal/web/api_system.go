@@ -0,0 +1,56 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSystemInfo godoc
+//
+//	@Summary	Get the host and software versions of a printer
+//	@Tags		Printers
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{object}	printer.HostInfo
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/system [get]
+func (s *Server) GetSystemInfo(g *gin.Context) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	}
+
+	si, ok := p.(printer.SystemInfo)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "system info not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	info, err := si.SystemInfo(ctx)
+	if err != nil {
+		s.logger.Errorf("get system info error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,125 @@
+package web
+
+import (
+	"3dp-controller/internal/printer"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tuner looks up the printer's printer.Tuner, or responds with 404/501 and
+// returns false.
+func (s *Server) tuner(g *gin.Context) (printer.Tuner, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, false
+	}
+
+	t, ok := p.(printer.Tuner)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "tuning not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, false
+	}
+
+	return t, true
+}
+
+// GetTuning godoc
+//
+//	@Summary	Get the speed, flow, fan and Z offset of a printer
+//	@Tags		Tuning
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{object}	printer.Tuning
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Failure	503	{object}	APIErrorResp
+//	@Router		/printers/{key}/tuning [get]
+func (s *Server) GetTuning(g *gin.Context) {
+	t, ok := s.tuner(g)
+	if !ok {
+		return
+	}
+
+	tuning := t.Tuning()
+	if tuning == nil {
+		resp := APIErrorResp{
+			Error: "tuning not available while the printer is offline",
+		}
+		g.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	g.JSON(http.StatusOK, tuning)
+}
+
+// UpdateTuning godoc
+//
+//	@Summary		Change the speed, flow, fan or Z offset of a printer
+//	@Description	Fields left out are not changed. Values must be within the configured bounds.
+//	@Tags			Tuning
+//	@Param			key		path	string					true	"key of printer"
+//	@Param			tuning	body	printer.TuningChange	true	"values to change"
+//	@Accept			json
+//	@Success		204
+//	@Failure		400	{object}	APIErrorResp
+//	@Failure		404	{object}	APIErrorResp
+//	@Failure		500	{object}	APIErrorResp
+//	@Failure		501	{object}	APIErrorResp
+//	@Router			/printers/{key}/tuning [patch]
+func (s *Server) UpdateTuning(g *gin.Context) {
+	t, ok := s.tuner(g)
+	if !ok {
+		return
+	}
+
+	var change printer.TuningChange
+	if err := g.ShouldBindJSON(&change); err != nil {
+		resp := APIErrorResp{
+			Error: "invalid request: " + err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	if err := s.tuningLimits.Check(change); err != nil {
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	err := t.SetTuning(ctx, change)
+
+	var gcodeErr *printer.GCodeError
+	if errors.As(err, &gcodeErr) {
+		resp := APIErrorResp{
+			Error: gcodeErr.Message,
+		}
+		g.JSON(http.StatusBadRequest, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("set tuning error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return
+	}
+
+	g.Status(http.StatusNoContent)
+}
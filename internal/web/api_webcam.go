@@ -0,0 +1,247 @@
+package web
+
+import (
+	"3dp-controller/internal/mjpeg"
+	"3dp-controller/internal/printer"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webcams looks up the printer's printer.Webcam and lists its cameras, or
+// responds with an error and returns false.
+func (s *Server) webcams(g *gin.Context) (printer.Webcam, []string, bool) {
+	p, ok := s.monitors[g.Param("key")]
+	if !ok {
+		resp := APIErrorResp{
+			Error: "printer not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, nil, false
+	}
+
+	w, ok := p.(printer.Webcam)
+	if !ok {
+		resp := APIErrorResp{
+			Error: "webcams not supported by this printer",
+		}
+		g.JSON(http.StatusNotImplemented, resp)
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	names, err := w.Webcams(ctx)
+	if err != nil {
+		s.logger.Errorf("list webcams error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusInternalServerError, resp)
+		return nil, nil, false
+	}
+
+	return w, names, true
+}
+
+// webcam is webcams for the camera the request names, the first by default.
+func (s *Server) webcam(g *gin.Context) (printer.Webcam, string, bool) {
+	w, names, ok := s.webcams(g)
+	if !ok {
+		return nil, "", false
+	}
+
+	name := g.Query("camera")
+	if name == "" && len(names) > 0 {
+		name = names[0]
+	}
+
+	if !slices.Contains(names, name) {
+		resp := APIErrorResp{
+			Error: "webcam not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return nil, "", false
+	}
+
+	return w, name, true
+}
+
+// GetWebcams godoc
+//
+//	@Summary	List the webcams of a printer
+//	@Tags		Webcam
+//	@Param		key	path	string	true	"key of printer"
+//	@Produce	json
+//	@Success	200	{array}		string
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Router		/printers/{key}/webcams [get]
+func (s *Server) GetWebcams(g *gin.Context) {
+	_, names, ok := s.webcams(g)
+	if !ok {
+		return
+	}
+
+	if names == nil {
+		names = make([]string, 0)
+	}
+
+	g.JSON(http.StatusOK, names)
+}
+
+// GetWebcamSnapshot godoc
+//
+//	@Summary	Get a snapshot from a printer's webcam
+//	@Tags		Webcam
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		camera	query	string	false	"name of webcam, defaults to the first"
+//	@Produce	image/jpeg
+//	@Success	200
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Failure	502	{object}	APIErrorResp
+//	@Router		/printers/{key}/webcam/snapshot [get]
+func (s *Server) GetWebcamSnapshot(g *gin.Context) {
+	w, name, ok := s.webcam(g)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	var buf bytes.Buffer
+	contentType, err := w.WebcamSnapshot(ctx, name, &buf)
+	if errors.Is(err, printer.ErrNoWebcam) {
+		resp := APIErrorResp{
+			Error: "webcam not found",
+		}
+		g.JSON(http.StatusNotFound, resp)
+		return
+	} else if err != nil {
+		s.logger.Errorf("get webcam snapshot error: %s", err.Error())
+		resp := APIErrorResp{
+			Error: err.Error(),
+		}
+		g.JSON(http.StatusBadGateway, resp)
+		return
+	}
+
+	g.Status(http.StatusOK)
+	g.Header("Content-Length", fmt.Sprintf("%d", buf.Len()))
+	g.Header("Content-Type", contentType)
+	g.Header("Cache-Control", "no-store")
+
+	if _, err := io.Copy(g.Writer, &buf); err != nil {
+		s.logger.Errorf("copy error: %s", err.Error())
+	}
+}
+
+// webcamFirstFrameTimeout bounds the wait for a stream's first frame, e.g.
+// from a camera that accepts the connection but never sends anything.
+var webcamFirstFrameTimeout = 10 * time.Second
+
+// GetWebcamStream godoc
+//
+//	@Summary	Stream a printer's webcam as multipart MJPEG
+//	@Tags		Webcam
+//	@Param		key		path	string	true	"key of printer"
+//	@Param		camera	query	string	false	"name of webcam, defaults to the first"
+//	@Produce	multipart/x-mixed-replace
+//	@Success	200
+//	@Failure	404	{object}	APIErrorResp
+//	@Failure	500	{object}	APIErrorResp
+//	@Failure	501	{object}	APIErrorResp
+//	@Failure	502	{object}	APIErrorResp
+//	@Router		/printers/{key}/webcam/stream [get]
+func (s *Server) GetWebcamStream(g *gin.Context) {
+	w, name, ok := s.webcam(g)
+	if !ok {
+		return
+	}
+
+	b := s.webcamStream(g.Param("key"), name, w)
+
+	frames, leave := b.Subscribe()
+	defer leave()
+
+	// Wait for the first frame, so that a failure can still be reported.
+	timeout := time.NewTimer(webcamFirstFrameTimeout)
+	defer timeout.Stop()
+
+	var frame []byte
+	select {
+	case <-g.Request.Context().Done():
+		return
+	case <-timeout.C:
+		resp := APIErrorResp{
+			Error: "webcam stream failed: no frame received",
+		}
+		g.JSON(http.StatusBadGateway, resp)
+		return
+	case frame, ok = <-frames:
+		if !ok {
+			err := b.Err()
+			if err == nil {
+				err = mjpeg.ErrStreamEnded
+			}
+
+			resp := APIErrorResp{
+				Error: "webcam stream failed: " + err.Error(),
+			}
+			g.JSON(http.StatusBadGateway, resp)
+			return
+		}
+	}
+
+	mw := mjpeg.NewWriter(g.Writer)
+
+	g.Header("Content-Type", mw.ContentType())
+	g.Header("Cache-Control", "no-store")
+	g.Status(http.StatusOK)
+
+	for {
+		if err := mw.WriteFrame(frame); err != nil {
+			return
+		}
+		g.Writer.Flush()
+
+		select {
+		case <-g.Request.Context().Done():
+			return
+		case frame, ok = <-frames:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// webcamStream returns the broadcaster relaying the named webcam's stream.
+func (s *Server) webcamStream(key string, name string, w printer.Webcam) *mjpeg.Broadcaster {
+	s.webcamStreamsMu.Lock()
+	defer s.webcamStreamsMu.Unlock()
+
+	streamKey := key + "/" + name
+
+	b, ok := s.webcamStreams[streamKey]
+	if !ok {
+		b = mjpeg.NewBroadcaster(s.streamCtx, func(ctx context.Context) (io.ReadCloser, string, error) {
+			return w.WebcamStream(ctx, name)
+		}, s.logger.With("printer", key, "webcam", name))
+		s.webcamStreams[streamKey] = b
+	}
+
+	return b
+}
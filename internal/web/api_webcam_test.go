@@ -0,0 +1,279 @@
+package web
+
+import (
+	"3dp-controller/internal/mjpeg"
+	"3dp-controller/internal/printer"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// webcamPrinter is a printer.Printer with a single webcam, "cam", whose
+// stream is served by an httptest server. Only the printer.Webcam methods
+// are implemented.
+type webcamPrinter struct {
+	printer.Printer
+
+	camera *httptest.Server
+	frames chan []byte
+	// empty makes the camera end its streams before their first frame.
+	empty bool
+
+	opened chan struct{}
+	closed chan struct{}
+}
+
+func newWebcamPrinter(t *testing.T) *webcamPrinter {
+	p := &webcamPrinter{
+		frames: make(chan []byte),
+		opened: make(chan struct{}, 10),
+		closed: make(chan struct{}, 10),
+	}
+
+	p.camera = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.opened <- struct{}{}
+		defer func() { p.closed <- struct{}{} }()
+
+		mw := mjpeg.NewWriter(w)
+		w.Header().Set("Content-Type", mw.ContentType())
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		if p.empty {
+			return
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case frame := <-p.frames:
+				if err := mw.WriteFrame(frame); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	t.Cleanup(p.camera.Close)
+
+	return p
+}
+
+func (p *webcamPrinter) Webcams(ctx context.Context) ([]string, error) {
+	return []string{"cam"}, nil
+}
+
+func (p *webcamPrinter) WebcamSnapshot(ctx context.Context, name string, w io.Writer) (string, error) {
+	return "", errors.New("no snapshots")
+}
+
+func (p *webcamPrinter) WebcamStream(ctx context.Context, name string) (io.ReadCloser, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.camera.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// sendFrame sends a frame once the camera has a stream open.
+func (p *webcamPrinter) sendFrame(t *testing.T, frame string) {
+	t.Helper()
+
+	select {
+	case p.frames <- []byte(frame):
+	case <-time.After(5 * time.Second):
+		t.Fatalf("camera stream did not take frame %s", frame)
+	}
+}
+
+func waitSignal(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func newWebcamTestServer(t *testing.T, monitors map[string]printer.Printer) *httptest.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := NewServer(ctx, false, zap.NewNop().Sugar(), monitors, printer.GCodePolicy{}, printer.TuningLimits{})
+
+	srv := httptest.NewServer(s.r)
+	t.Cleanup(func() {
+		cancel()
+		srv.Close()
+	})
+
+	return srv
+}
+
+// openStream requests the webcam stream, sending frame to the camera until
+// the response starts.
+func openStream(t *testing.T, srv *httptest.Server, p *webcamPrinter, frame string) (*http.Response, *mjpeg.Reader) {
+	t.Helper()
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(srv.URL + "/api/v1/printers/p/webcam/stream")
+		done <- result{resp, err}
+	}()
+
+	var res result
+	for res.resp == nil && res.err == nil {
+		select {
+		case res = <-done:
+		case p.frames <- []byte(frame):
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out opening the stream")
+		}
+	}
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	t.Cleanup(func() { _ = res.resp.Body.Close() })
+
+	if res.resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", res.resp.StatusCode)
+	}
+
+	r, err := mjpeg.NewReader(res.resp.Body, res.resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.resp, r
+}
+
+// expectFrame reads frames until want. openStream may have sent its frame
+// more than once.
+func expectFrame(t *testing.T, r *mjpeg.Reader, want string) {
+	t.Helper()
+
+	for {
+		frame, err := r.NextFrame()
+		if err != nil {
+			t.Fatalf("waiting for %s: %s", want, err)
+		}
+		if string(frame) == want {
+			return
+		}
+	}
+}
+
+func TestGetWebcamStreamSharesUpstream(t *testing.T) {
+	p := newWebcamPrinter(t)
+	srv := newWebcamTestServer(t, map[string]printer.Printer{"p": p})
+
+	resp1, r1 := openStream(t, srv, p, "first")
+	waitSignal(t, p.opened, "the camera stream")
+	expectFrame(t, r1, "first")
+
+	p.sendFrame(t, "second")
+	expectFrame(t, r1, "second")
+
+	// The second viewer joins the same camera stream.
+	resp2, r2 := openStream(t, srv, p, "third")
+	expectFrame(t, r2, "third")
+	expectFrame(t, r1, "third")
+	select {
+	case <-p.opened:
+		t.Fatal("camera stream opened per viewer")
+	default:
+	}
+
+	_ = resp1.Body.Close()
+	select {
+	case <-p.closed:
+		t.Fatal("camera stream closed with a viewer left")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = resp2.Body.Close()
+	waitSignal(t, p.closed, "the camera stream to close after the last viewer")
+}
+
+func TestGetWebcamStreamEndedBeforeFirstFrame(t *testing.T) {
+	p := newWebcamPrinter(t)
+	p.empty = true
+	srv := newWebcamTestServer(t, map[string]printer.Printer{"p": p})
+
+	resp, err := http.Get(srv.URL + "/api/v1/printers/p/webcam/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body APIErrorResp
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusBadGateway || body.Error == "" {
+		t.Fatalf("status %d, %+v", resp.StatusCode, body)
+	}
+}
+
+func TestGetWebcamStreamFirstFrameTimeout(t *testing.T) {
+	defer func(d time.Duration) { webcamFirstFrameTimeout = d }(webcamFirstFrameTimeout)
+	webcamFirstFrameTimeout = 200 * time.Millisecond
+
+	p := newWebcamPrinter(t)
+	srv := newWebcamTestServer(t, map[string]printer.Printer{"p": p})
+
+	resp, err := http.Get(srv.URL + "/api/v1/printers/p/webcam/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	waitSignal(t, p.closed, "the camera stream to close")
+}
+
+func TestGetWebcamStreamErrors(t *testing.T) {
+	srv := newWebcamTestServer(t, map[string]printer.Printer{
+		"p":      newWebcamPrinter(t),
+		"nocams": struct{ printer.Printer }{},
+	})
+
+	tests := map[string]int{
+		"/api/v1/printers/missing/webcam/stream":      http.StatusNotFound,
+		"/api/v1/printers/nocams/webcam/stream":       http.StatusNotImplemented,
+		"/api/v1/printers/p/webcam/stream?camera=x":   http.StatusNotFound,
+		"/api/v1/printers/p/webcam/snapshot?camera=x": http.StatusNotFound,
+		"/api/v1/printers/p/webcam/snapshot":          http.StatusBadGateway,
+	}
+
+	for path, want := range tests {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
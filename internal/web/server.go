@@ -2,10 +2,12 @@ package web
 
 import (
 	"3dp-controller/docs"
+	"3dp-controller/internal/mjpeg"
 	"3dp-controller/internal/printer"
 	"context"
 	"errors"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -24,10 +26,24 @@ type Server struct {
 
 	monitors map[string]printer.Printer
 
+	// gcodePolicy decides which commands the console may run.
+	gcodePolicy printer.GCodePolicy
+	// tuningLimits bounds what printers may be tuned to.
+	tuningLimits printer.TuningLimits
+
+	// webcamStreams relays each webcam's stream to its viewers, keyed by
+	// printer key and webcam name.
+	webcamStreamsMu sync.Mutex
+	webcamStreams   map[string]*mjpeg.Broadcaster
+
 	ctx context.Context
+	// streamCtx ends long-lived responses, webcam and console streams, so that
+	// Shutdown does not wait on them.
+	streamCtx   context.Context
+	stopStreams context.CancelFunc
 }
 
-func NewServer(ctx context.Context, isDevMode bool, logger *zap.SugaredLogger, monitors map[string]printer.Printer) *Server {
+func NewServer(ctx context.Context, isDevMode bool, logger *zap.SugaredLogger, monitors map[string]printer.Printer, gcodePolicy printer.GCodePolicy, tuningLimits printer.TuningLimits) *Server {
 	var engine *gin.Engine
 
 	if !isDevMode {
@@ -61,11 +77,21 @@ func NewServer(ctx context.Context, isDevMode bool, logger *zap.SugaredLogger, m
 		engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	}
 
+	streamCtx, stopStreams := context.WithCancel(ctx)
+
 	server := Server{
 		r:        engine,
 		logger:   logger,
 		monitors: monitors,
 		ctx:      ctx,
+
+		gcodePolicy:  gcodePolicy,
+		tuningLimits: tuningLimits,
+
+		streamCtx:   streamCtx,
+		stopStreams: stopStreams,
+
+		webcamStreams: make(map[string]*mjpeg.Broadcaster),
 	}
 
 	server.registerAPIRoutes(engine.Group(docs.SwaggerInfo.BasePath))
@@ -95,6 +121,8 @@ func (s *Server) Shutdown() {
 	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
 	defer cancel()
 
+	s.stopStreams()
+
 	if err := s.srv.Shutdown(ctx); err != nil {
 		s.logger.Fatalf("Server Shutdown: %s\n", err)
 	}
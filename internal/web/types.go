@@ -15,6 +15,12 @@ type Printer struct {
 	RegJobId        string  `json:"registered_job_id"`
 	AllowNoRegPrint bool    `json:"allow_no_register_print"`
 	NoPauseDuration float64 `json:"no_pause_duration"`
+	// JobPausedByMonitor tells a pause by the monitor apart from a manual one.
+	JobPausedByMonitor bool `json:"job_paused_by_monitor"`
+	// ScheduledPause and JobPausedBySchedule are unset for backends without
+	// printer.PauseScheduler.
+	ScheduledPause      *printer.PauseAt `json:"scheduled_pause"`
+	JobPausedBySchedule bool             `json:"job_paused_by_schedule"`
 
 	State          printer.PrinterState `json:"state"`
 	Message        string               `json:"message"`
@@ -22,4 +28,7 @@ type Printer struct {
 	LastUpdateTime int64                `json:"last_update_time"`
 
 	Job *printer.Job `json:"job"`
+
+	// Temperatures is nil for backends without printer.TemperatureReporter.
+	Temperatures []printer.Temperature `json:"temperatures"`
 }